@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/config"
+	"github.com/ilivestrong/auth-service/internal/export"
+	"github.com/ilivestrong/auth-service/internal/migrate"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	"github.com/ilivestrong/auth-service/internal/retention"
+)
+
+const usage = `usage: auth-service [flags] [command]
+
+Runs the server when no command is given.
+
+commands:
+  migrate up [-to <version>]              apply pending database migrations
+  migrate down [-steps <n>]               revert the latest n migrations, 1 by default
+  migrate status                          list applied and pending migrations
+  migrate unlock                          remove a migration lock left behind by a crashed run
+  audit verify [-phone-number <number>]   walk the audit log hash chain and report the first broken link
+  events export [flags]                   write events of a time range as NDJSON or CSV, see -h
+  events purge [-dry-run]                 delete or archive events past EVENT_RETENTION once
+  pii rotate                              encrypt plaintext rows and re-wrap rows encrypted with older keys
+  config print                            show the effective configuration, with secrets masked
+`
+
+func runCommand(cfg *config.Config, args []string) {
+	switch args[0] {
+	case "migrate":
+		runMigrateCommand(cfg, args[1:])
+	case "audit":
+		runAuditCommand(cfg, args[1:])
+	case "events":
+		runEventsCommand(cfg, args[1:])
+	case "pii":
+		runPIICommand(cfg, args[1:])
+	case "config":
+		runConfigCommand(cfg, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+func runMigrateCommand(cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	configure(cfg, "db", "log")
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	to := fs.Int64("to", 0, "version to migrate up to, the latest when 0")
+	steps := fs.Int("steps", 1, "number of migrations to revert")
+	fs.Parse(args[1:])
+
+	ctx := context.Background()
+	migrator := newMigrator(cfg, openDB(cfg))
+
+	var (
+		done []migrate.Migration
+		verb string
+		err  error
+	)
+	switch args[0] {
+	case "up":
+		done, err = migrator.Up(ctx, *to)
+		verb = "applied"
+	case "down":
+		done, err = migrator.Down(ctx, *steps)
+		verb = "reverted"
+	case "status":
+		printMigrationStatus(ctx, migrator)
+		return
+	case "unlock":
+		if err := migrator.Unlock(ctx); err != nil {
+			log.Fatalf("migrate unlock failed, %v", err)
+		}
+		fmt.Println("migration lock removed")
+		return
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	for _, migration := range done {
+		fmt.Printf("%s %04d_%s\n", verb, migration.Version, migration.Name)
+	}
+	if err != nil {
+		log.Fatalf("migrate %s failed, %v", args[0], err)
+	}
+	if len(done) == 0 {
+		fmt.Println("nothing to do")
+	}
+}
+
+func printMigrationStatus(ctx context.Context, migrator migrate.Migrator) {
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		log.Fatalf("migrate status failed, %v", err)
+	}
+
+	fmt.Printf("schema version: %d, latest: %d\n", status.Current, status.Latest)
+	for _, migration := range status.Applied {
+		fmt.Printf("  applied  %04d_%s at %s\n", migration.Version, migration.Name, migration.AppliedAt.Format(time.RFC3339))
+	}
+	for _, migration := range status.Pending {
+		fmt.Printf("  pending  %04d_%s\n", migration.Version, migration.Name)
+	}
+}
+
+func runAuditCommand(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	configure(cfg, "db", "pii", "audit", "log")
+
+	fs := flag.NewFlagSet("audit verify", flag.ExitOnError)
+	phoneNumber := fs.String("phone-number", "", "only verify the events of this phone number")
+	fs.Parse(args[1:])
+
+	db, _ := bootDB(cfg)
+	eventRepo := persist.NewEventRepository(db, persist.EventRepoOptions{ChainKey: chainKey(cfg), PII: piiKeys(cfg), QueryTimeout: queryTimeout(cfg)})
+	report, err := eventRepo.VerifyChain(context.Background(), eventRepo.PhoneNumberStream(*phoneNumber))
+	if err != nil {
+		log.Fatalf("audit verify failed, %v", err)
+	}
+
+	fmt.Printf("streams checked: %d, events checked: %d\n", report.StreamsChecked, report.EventsChecked)
+	if link := report.BrokenLink; link != nil {
+		fmt.Printf("BROKEN at stream: %s, sequence: %d, event: %s: %s\n", link.Stream, link.Sequence, link.EventID, link.Reason)
+		os.Exit(1)
+	}
+	fmt.Println("audit log intact")
+}
+
+func runEventsCommand(cfg *config.Config, args []string) {
+	if len(args) > 0 && args[0] == "purge" {
+		runEventsPurgeCommand(cfg, args[1:])
+		return
+	}
+	if len(args) == 0 || args[0] != "export" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	configure(cfg, "db", "pii", "audit", "log")
+
+	fs := flag.NewFlagSet("events export", flag.ExitOnError)
+	from := fs.String("from", "", "start of the time range, RFC 3339, inclusive")
+	to := fs.String("to", "", "end of the time range, RFC 3339, exclusive")
+	format := fs.String("format", export.FormatNDJSON, "ndjson or csv")
+	redact := fs.Bool("redact", false, "mask all but the last four digits of phone numbers and leave out the hash chain fields")
+	eventType := fs.String("event-type", "", "comma separated event types to export, all when empty")
+	out := fs.String("out", "", "file to write to, stdout when empty")
+	fs.Parse(args[1:])
+
+	filter := persist.EventFilter{From: mustParseTime("from", *from), To: mustParseTime("to", *to)}
+	if *eventType != "" {
+		filter.EventTypes = strings.Split(*eventType, ",")
+	}
+
+	var dst io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("failed to create %s, %v", *out, err)
+		}
+		defer f.Close()
+		dst = f
+	}
+
+	w, err := export.NewEventWriter(dst, export.Options{Format: *format, RedactPhoneNumbers: *redact})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, _ := bootDB(cfg)
+	var exported int
+	err = persist.NewEventRepository(db, persist.EventRepoOptions{PII: piiKeys(cfg), QueryTimeout: queryTimeout(cfg)}).ForEach(context.Background(), filter, func(event *models.Event) error {
+		exported++
+		return w.Write(event)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatalf("events export failed, %v", err)
+	}
+	log.Printf("exported %d events\n", exported)
+}
+
+func runEventsPurgeCommand(cfg *config.Config, args []string) {
+	configure(cfg, "db", "pii", "audit", "retention", "log")
+
+	fs := flag.NewFlagSet("events purge", flag.ExitOnError)
+	purgeOptions := retentionOptions(cfg)
+	dryRun := fs.Bool("dry-run", purgeOptions.DryRun, "only report how many events would be purged")
+	fs.Parse(args)
+
+	if purgeOptions.Policy.IsEmpty() {
+		log.Fatal("nothing to purge, EVENT_RETENTION is not set")
+	}
+	purgeOptions.DryRun = *dryRun
+
+	db, _ := bootDB(cfg)
+	purgeOptions.Lease = persist.NewLeaseRepository(db)
+	purger := retention.NewPurger(persist.NewEventRepository(db, persist.EventRepoOptions{ChainKey: optionalChainKey(cfg), PII: piiKeys(cfg), QueryTimeout: queryTimeout(cfg)}), purgeOptions)
+	report, err := purger.PurgeOnce(context.Background())
+	if err != nil {
+		log.Fatalf("events purge failed, %v", err)
+	}
+
+	verb := "purged"
+	if report.DryRun {
+		verb = "would purge"
+	}
+	for _, rule := range report.Rules {
+		fmt.Printf("%s: %s %d events created before %s\n", rule.Name, verb, rule.Events, rule.Before.Format(time.RFC3339))
+	}
+}
+
+func runPIICommand(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "rotate" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	configure(cfg, "db", "pii", "log")
+
+	db, _ := bootDB(cfg)
+	rotation, err := persist.RotatePII(context.Background(), db, piiKeys(cfg))
+	if err != nil {
+		log.Fatalf("pii rotate failed after %d profiles and %d events, %v", rotation.Profiles, rotation.Events, err)
+	}
+	fmt.Printf("rotated %d profiles, %d events, %d data exports and %d archive chunks\n", rotation.Profiles, rotation.Events, rotation.DataExports, rotation.ArchiveChunks)
+}
+
+func runConfigCommand(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	// shows the settings as they are, also when invalid
+
+	if err := cfg.Print(os.Stdout); err != nil {
+		log.Fatalf("config print failed, %v", err)
+	}
+}
+
+func mustParseTime(name string, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("invalid -%s, %v", name, err)
+	}
+	return t
+}
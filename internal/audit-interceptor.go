@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"connectrpc.com/connect"
+	"github.com/ilivestrong/auth-service/internal/logging"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+)
+
+const (
+	RequestIDHeader      = "x-request-id"
+	forwardedForHeader   = "x-forwarded-for"
+	userAgentHeader      = "user-agent"
+	auditEventTypePrefix = "RPC_"
+)
+
+var (
+	// auditEventTypes names the audit event recorded for each RPC, others get auditEventTypePrefix+rpc.
+	auditEventTypes = map[string]string{
+		RpcSignupWithPhoneNumber: EventTypeSignup,
+		RpcVerifyPhoneNumber:     EventTypeVerify,
+		RpcLoginWithPhoneNumber:  EventTypeLogin,
+		RpcGetProfile:            EventTypeGetProfile,
+		RpcLogout:                EventTypeLogout,
+	}
+
+	// subjectAPIs are the public RPCs whose request phone number is the caller's own. The
+	// phone number in other requests, like an admin's filter, is a target and only goes into
+	// the metadata.
+	subjectAPIs = map[string]struct{}{RpcSignupWithPhoneNumber: {}, RpcVerifyPhoneNumber: {}, RpcLoginWithPhoneNumber: {}}
+)
+
+type (
+	// auditRecord is the event being assembled for the current RPC. Handlers add what
+	// only they know, like the profile ID, through the annotateAudit helpers.
+	auditRecord struct {
+		mu    sync.Mutex
+		event models.Event
+		// saved is set when the handler stored the event within its own transaction.
+		saved bool
+	}
+
+	auditRecordKey struct{}
+
+	auditInterceptor struct {
+		eventRepo persist.EventRepo
+	}
+
+	phoneNumberGetter interface {
+		GetPhoneNumber() string
+	}
+)
+
+func (ai *auditInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return connect.UnaryFunc(func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		record := newAuditRecord(ctx, req.Spec().Procedure, req.Header(), req.Peer())
+		if msg, ok := req.Any().(phoneNumberGetter); ok && msg.GetPhoneNumber() != "" {
+			if _, ok := subjectAPIs[rpcName(req.Spec().Procedure)]; ok {
+				record.event.PhoneNumber = msg.GetPhoneNumber()
+			} else {
+				record.event.Metadata["target_stream"] = ai.eventRepo.PhoneNumberStream(msg.GetPhoneNumber())
+			}
+		}
+
+		res, err := next(context.WithValue(ctx, auditRecordKey{}, record), req)
+		ai.save(ctx, record, req.Header(), err)
+		return res, err
+	})
+}
+
+func (ai *auditInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (ai *auditInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return connect.StreamingHandlerFunc(func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		record := newAuditRecord(ctx, conn.Spec().Procedure, conn.RequestHeader(), conn.Peer())
+
+		err := next(context.WithValue(ctx, auditRecordKey{}, record), conn)
+		ai.save(ctx, record, conn.RequestHeader(), err)
+		return err
+	})
+}
+
+// save completes record with the caller identity resolved by the token interceptor and
+// the outcome of the call, then stores it. Calls the client gave up on are recorded too.
+func (ai *auditInterceptor) save(ctx context.Context, record *auditRecord, header http.Header, err error) {
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	if record.saved {
+		return
+	}
+	event := record.complete(header, err)
+	if _, createErr := ai.eventRepo.Create(context.WithoutCancel(ctx), event); createErr != nil {
+		slog.ErrorContext(ctx, "failed to create audit event", "event_type", event.EventType, "error", createErr)
+	}
+}
+
+// complete returns a copy of the event with the caller identity and the outcome filled in.
+func (record *auditRecord) complete(header http.Header, err error) *models.Event {
+	event := record.event
+	if phoneNumber := header.Get(PhoneNumberHeader); phoneNumber != "" {
+		event.PhoneNumber = phoneNumber
+	}
+	if sessionID := header.Get(SessionIDHeader); sessionID != "" && event.SessionID == "" {
+		event.SessionID = sessionID
+	}
+
+	event.Outcome = models.OutcomeSuccess
+	if err != nil {
+		event.Outcome = models.OutcomeFailure
+		event.Reason = auditReason(err)
+	}
+	return &event
+}
+
+// saveAuditInTx stores the event of the current call as successful with the transaction
+// carried by ctx, so it is committed or rolled back together with the writes of the call.
+// Call it last in the unit of work, and unsaveAudit when the unit of work fails, so the
+// interceptor records the failure instead.
+func saveAuditInTx(ctx context.Context, eventRepo persist.EventRepo, header http.Header) error {
+	record := auditFromContext(ctx)
+	if record == nil {
+		return nil
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+
+	if _, err := eventRepo.Create(ctx, record.complete(header, nil)); err != nil {
+		return err
+	}
+	record.saved = true
+	return nil
+}
+
+func unsaveAudit(ctx context.Context) {
+	record := auditFromContext(ctx)
+	if record == nil {
+		return
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	record.saved = false
+}
+
+func newAuditRecord(ctx context.Context, procedure string, header http.Header, peer connect.Peer) *auditRecord {
+	requestID := logging.RequestID(ctx)
+	if requestID == "" {
+		requestID = header.Get(RequestIDHeader)
+	}
+
+	return &auditRecord{event: models.Event{
+		EventType: auditEventType(rpcName(procedure)),
+		ClientIP:  clientIP(header, peer),
+		UserAgent: header.Get(userAgentHeader),
+		RequestID: requestID,
+		Metadata:  models.Metadata{"procedure": procedure},
+	}}
+}
+
+// NewAuditInterceptor records an audit event with its outcome for every RPC. It must wrap
+// the token interceptor so the caller identity resolved there is available.
+func NewAuditInterceptor(eventRepo persist.EventRepo) connect.Interceptor {
+	return &auditInterceptor{eventRepo}
+}
+
+func auditFromContext(ctx context.Context) *auditRecord {
+	record, _ := ctx.Value(auditRecordKey{}).(*auditRecord)
+	return record
+}
+
+func (record *auditRecord) update(fn func(event *models.Event)) {
+	if record == nil {
+		return
+	}
+
+	record.mu.Lock()
+	defer record.mu.Unlock()
+	fn(&record.event)
+}
+
+func annotateAuditProfile(ctx context.Context, profileID string, phoneNumber string) {
+	auditFromContext(ctx).update(func(event *models.Event) {
+		event.ProfileID = profileID
+		event.PhoneNumber = phoneNumber
+	})
+}
+
+func annotateAuditSession(ctx context.Context, sessionID string) {
+	auditFromContext(ctx).update(func(event *models.Event) {
+		event.SessionID = sessionID
+	})
+}
+
+func annotateAuditMetadata(ctx context.Context, key string, value string) {
+	auditFromContext(ctx).update(func(event *models.Event) {
+		if event.Metadata == nil {
+			event.Metadata = models.Metadata{}
+		}
+		event.Metadata[key] = value
+	})
+}
+
+func rpcName(procedure string) string {
+	urlBits := strings.Split(procedure, "/")
+	return urlBits[len(urlBits)-1]
+}
+
+func auditEventType(rpc string) string {
+	if eventType, ok := auditEventTypes[rpc]; ok {
+		return eventType
+	}
+	return auditEventTypePrefix + rpc
+}
+
+func auditReason(err error) string {
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		return connectErr.Code().String() + ": " + connectErr.Message()
+	}
+	return err.Error()
+}
+
+// clientIP prefers the first X-Forwarded-For hop, set by proxies in front of the service.
+func clientIP(header http.Header, peer connect.Peer) string {
+	if forwarded := header.Get(forwardedForHeader); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+
+	addr := peer.Addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -2,75 +2,146 @@ package internal
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
+	"net/http"
 	"strings"
 
 	"connectrpc.com/connect"
 )
 
 const (
-	tokenHeader   = "authorization"
-	RpcGetProfile = "GetProfile"
-	RpcLogout     = "Logout"
+	tokenHeader              = "authorization"
+	RpcSignupWithPhoneNumber = "SignupWithPhoneNumber"
+	RpcVerifyPhoneNumber     = "VerifyPhoneNumber"
+	RpcLoginWithPhoneNumber  = "LoginWithPhoneNumber"
+	RpcGetProfile            = "GetProfile"
+	RpcLogout                = "Logout"
+	RpcListMyEvents          = "ListMyEvents"
+	RpcListEvents            = "ListEvents"
+	RpcVerifyAuditLog        = "VerifyAuditLog"
+	RpcExportEvents          = "ExportEvents"
+	RpcExportMyData          = "ExportMyData"
+	RpcGetMyDataExport       = "GetMyDataExport"
+	RpcDownloadMyDataExport  = "DownloadMyDataExport"
+	RpcExportUserData        = "ExportUserData"
+	RpcGetDataExport         = "GetDataExport"
+	RpcDownloadDataExport    = "DownloadDataExport"
 
 	PhoneNumberHeader = "x-phone-number"
+	SessionIDHeader   = "x-session-id"
 )
 
 var (
 	ErrInvalidToken = errors.New("invalid token provided")
 	ErrTokenMissing = errors.New("no token provided")
+	ErrAdminOnly    = errors.New("this api requires the admin token")
 
-	securedAPIs = map[string]struct{}{RpcGetProfile: {}, RpcLogout: {}}
+	securedAPIs = map[string]struct{}{RpcGetProfile: {}, RpcLogout: {}, RpcListMyEvents: {}, RpcExportMyData: {}, RpcGetMyDataExport: {}, RpcDownloadMyDataExport: {}}
+	adminAPIs   = map[string]struct{}{RpcListEvents: {}, RpcVerifyAuditLog: {}, RpcExportEvents: {}, RpcExportUserData: {}, RpcGetDataExport: {}, RpcDownloadDataExport: {}}
 )
 
-func NewTokenInterceptor(auth SessionAuthenticator, cache Cache) connect.UnaryInterceptorFunc {
-	interceptor := func(next connect.UnaryFunc) connect.UnaryFunc {
-		return connect.UnaryFunc(func(
-			ctx context.Context,
-			req connect.AnyRequest,
-		) (connect.AnyResponse, error) {
-			urlBits := strings.Split(req.Spec().Procedure, "/")
-			rpcinvoked := urlBits[len(urlBits)-1]
-
-			if _, ok := securedAPIs[rpcinvoked]; !ok {
-				return next(ctx, req)
-			}
-
-			authHeaders := req.Header().Get(tokenHeader)
-			headerSlice := strings.Split(authHeaders, " ")
-			if len(headerSlice) < 2 {
-				return nil, connect.NewError(
-					connect.CodeUnauthenticated,
-					ErrInvalidToken,
-				)
-			}
-
-			tokenString := headerSlice[1]
-			if tokenString == "" {
-				return nil, connect.NewError(
-					connect.CodeUnauthenticated,
-					ErrTokenMissing,
-				)
-			}
-
-			phoneNumber, err := auth.ParseToken(tokenString)
-			if err != nil {
-				return nil, connect.NewError(
-					connect.CodeUnauthenticated,
-					err,
-				)
-			}
-
-			if rpcinvoked == RpcLogout {
-				if exists := cache.Get(phoneNumber); exists {
-					req.Header().Set(PhoneNumberHeader, phoneNumber)
-				}
-			} else {
-				req.Header().Set(PhoneNumberHeader, phoneNumber)
-			}
-
-			return next(ctx, req)
-		})
+type (
+	tokenInterceptor struct {
+		auth       SessionAuthenticator
+		cache      Cache
+		adminToken string
 	}
-	return connect.UnaryInterceptorFunc(interceptor)
+)
+
+func (ti *tokenInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return connect.UnaryFunc(func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		if err := ti.authorize(req.Spec().Procedure, req.Header()); err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	})
+}
+
+func (ti *tokenInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (ti *tokenInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return connect.StreamingHandlerFunc(func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if err := ti.authorize(conn.Spec().Procedure, conn.RequestHeader()); err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	})
+}
+
+// authorize checks the token required by procedure, if any, and passes the caller
+// identity on to the handler through PhoneNumberHeader and SessionIDHeader.
+func (ti *tokenInterceptor) authorize(procedure string, header http.Header) error {
+	urlBits := strings.Split(procedure, "/")
+	rpcinvoked := urlBits[len(urlBits)-1]
+
+	// these are only ever set by this interceptor, never trust them from clients
+	header.Del(PhoneNumberHeader)
+	header.Del(SessionIDHeader)
+
+	if _, ok := adminAPIs[rpcinvoked]; ok {
+		if !isAdminToken(header.Get(tokenHeader), ti.adminToken) {
+			return connect.NewError(connect.CodePermissionDenied, ErrAdminOnly)
+		}
+		return nil
+	}
+
+	if _, ok := securedAPIs[rpcinvoked]; !ok {
+		return nil
+	}
+
+	authHeaders := header.Get(tokenHeader)
+	headerSlice := strings.Split(authHeaders, " ")
+	if len(headerSlice) < 2 {
+		return connect.NewError(
+			connect.CodeUnauthenticated,
+			ErrInvalidToken,
+		)
+	}
+
+	tokenString := headerSlice[1]
+	if tokenString == "" {
+		return connect.NewError(
+			connect.CodeUnauthenticated,
+			ErrTokenMissing,
+		)
+	}
+
+	session, err := ti.auth.ParseToken(tokenString)
+	if err != nil {
+		return connect.NewError(
+			connect.CodeUnauthenticated,
+			err,
+		)
+	}
+
+	if rpcinvoked == RpcLogout {
+		if exists := ti.cache.Get(session.PhoneNumber); exists {
+			header.Set(PhoneNumberHeader, session.PhoneNumber)
+		}
+	} else {
+		header.Set(PhoneNumberHeader, session.PhoneNumber)
+	}
+	header.Set(SessionIDHeader, session.SessionID)
+
+	return nil
+}
+
+// NewTokenInterceptor guards securedAPIs with session tokens and adminAPIs with adminToken.
+// Admin APIs are disabled altogether when adminToken is empty.
+func NewTokenInterceptor(auth SessionAuthenticator, cache Cache, adminToken string) connect.Interceptor {
+	return &tokenInterceptor{auth, cache, adminToken}
+}
+
+func isAdminToken(authHeader string, adminToken string) bool {
+	headerSlice := strings.Split(authHeader, " ")
+	if adminToken == "" || len(headerSlice) < 2 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(headerSlice[1]), []byte(adminToken)) == 1
 }
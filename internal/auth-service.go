@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"regexp"
 	"time"
 
 	"connectrpc.com/connect"
+	"github.com/ilivestrong/auth-service/internal/dataexport"
+	"github.com/ilivestrong/auth-service/internal/metrics"
 	"github.com/ilivestrong/auth-service/internal/models"
 	"github.com/ilivestrong/auth-service/internal/persist"
 	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
@@ -16,9 +18,12 @@ import (
 )
 
 const (
-	SendOTPMessage  = "SendOTP"
-	EventTypeLogin  = "PROFILE_LOGIN"
-	EventTypeLogout = "PROFILE_LOGOUT"
+	SendOTPMessage      = "SendOTP"
+	EventTypeSignup     = "PROFILE_SIGNUP"
+	EventTypeVerify     = "PROFILE_VERIFY"
+	EventTypeLogin      = "PROFILE_LOGIN"
+	EventTypeGetProfile = "PROFILE_VIEW"
+	EventTypeLogout     = "PROFILE_LOGOUT"
 )
 
 var (
@@ -29,15 +34,20 @@ var (
 	ErrGenerateTokenFailed        = errors.New("failed to generate session token")
 	ErrInvaliPhoneNumber          = errors.New("invalid phone number")
 	ErrInvalidSession             = errors.New("token is invalid or user logged out")
+	ErrSendOTPFailed              = errors.New("failed to send otp, please try again later")
+	ErrOtpExpired                 = errors.New("otp has expired")
 )
 
 type (
 	authService struct {
 		profileRepo   persist.ProfileRepo
 		eventRepo     persist.EventRepo
+		uow           persist.UnitOfWork
 		mqclient      mq.MQClient
+		events        mq.EventPublisher
 		authenticator SessionAuthenticator
 		cache         Cache
+		exporter      *dataexport.Exporter
 	}
 )
 
@@ -45,19 +55,30 @@ func (auth *authService) SignupWithPhoneNumber(
 	ctx context.Context,
 	req *connect.Request[authv1.SignupWithPhoneNumberRequest],
 ) (*connect.Response[authv1.SignupWithPhoneNumberResponse], error) {
-	profileID, err := auth.profileRepo.Create(req.Msg.GetPhoneNumber(), req.Msg.Name)
-	if err != nil {
-		return nil, connect.NewError(connect.CodeInvalidArgument, err)
-	}
-
 	if valid := validatePhoneNumber(req.Msg.PhoneNumber); !valid {
 		return nil, connect.NewError(connect.CodeInvalidArgument, ErrInvaliPhoneNumber)
 	}
 
-	c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	profileID, err := auth.profileRepo.Create(ctx, req.Msg.GetPhoneNumber(), req.Msg.Name)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	annotateAuditProfile(ctx, profileID, req.Msg.GetPhoneNumber())
+
+	c, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
 	defer cancel()
 
-	auth.mqclient.Publish(c, req.Msg.GetPhoneNumber())
+	if err := auth.mqclient.Publish(c, req.Msg.GetPhoneNumber()); err != nil {
+		slog.ErrorContext(ctx, "failed to request otp", "phone_number", req.Msg.GetPhoneNumber(), "error", err)
+		metrics.MQPublishFailures.WithLabelValues(mq.MessageTypeOtpRequested).Inc()
+		return nil, connect.NewError(connect.CodeUnavailable, ErrSendOTPFailed)
+	}
+
+	metrics.Signups.Inc()
+	auth.publishEvent(ctx, mq.EventProfileCreated, &authv1.ProfileEvent{
+		ProfileId:   profileID,
+		PhoneNumber: req.Msg.GetPhoneNumber(),
+	})
 
 	return connect.NewResponse(&authv1.SignupWithPhoneNumberResponse{
 		Id:          profileID,
@@ -70,23 +91,37 @@ func (auth *authService) VerifyPhoneNumber(
 	ctx context.Context,
 	req *connect.Request[authv1.VerifyPhoneNumberRequest],
 ) (*connect.Response[authv1.VerifyPhoneNumberResponse], error) {
-	profile, err := auth.profileRepo.Get(req.Msg.GetPhoneNumber())
+	profile, err := auth.profileRepo.Get(ctx, req.Msg.GetPhoneNumber())
 	if err != nil || profile == nil {
 		return nil, connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
 	}
+	annotateAuditProfile(ctx, profile.ID, profile.PhoneNumber)
 
-	if profile.IsVerified {
+	// checked and applied in one conditional update, so a new otp arriving meanwhile cannot interfere
+	err = auth.profileRepo.SetOTPVerified(ctx, req.Msg.GetPhoneNumber(), req.Msg.GetOtp())
+	switch {
+	case err == nil:
+	case errors.Is(err, persist.ErrAlreadyVerified):
 		return nil, connect.NewError(connect.CodeInvalidArgument, ErrPhoneNumberAlreadyVerified)
-	}
-
-	if profile.Otp != req.Msg.Otp {
+	case errors.Is(err, persist.ErrOtpMismatch):
+		auth.publishOtpFailed(ctx, profile, RpcVerifyPhoneNumber)
 		return nil, connect.NewError(connect.CodeInvalidArgument, ErrIncorrectOtp)
-	}
-
-	if err := auth.profileRepo.SetOTPVerified(req.Msg.GetPhoneNumber()); err != nil {
+	case errors.Is(err, persist.ErrOtpExpired):
+		return nil, connect.NewError(connect.CodeInvalidArgument, ErrOtpExpired)
+	case errors.Is(err, persist.ErrProfileNotFound):
+		return nil, connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
+	case errors.Is(err, persist.ErrProfileConflict):
+		return nil, connect.NewError(connect.CodeAborted, err)
+	default:
 		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
+	metrics.Verifications.Inc()
+	auth.publishEvent(ctx, mq.EventProfileVerified, &authv1.ProfileEvent{
+		ProfileId:   profile.ID,
+		PhoneNumber: profile.PhoneNumber,
+	})
+
 	return connect.NewResponse(&authv1.VerifyPhoneNumberResponse{
 		Verified: true,
 	}), nil
@@ -96,32 +131,69 @@ func (auth *authService) LoginWithPhoneNumber(
 	ctx context.Context,
 	req *connect.Request[authv1.LoginWithPhoneNumberRequest],
 ) (*connect.Response[authv1.LoginWithPhoneNumberResponse], error) {
-	profile, err := auth.profileRepo.Get(req.Msg.GetPhoneNumber())
-	if err != nil || profile == nil {
-		return nil, connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
-	}
-
-	if !profile.IsVerified {
-		return nil, connect.NewError(connect.CodeInternal, ErrPhoneNumberNotVerified)
-	}
-
-	if profile.Otp != req.Msg.Otp {
-		return nil, connect.NewError(connect.CodeInvalidArgument, ErrIncorrectOtp)
-	}
-
-	token, err := auth.authenticator.GenerateToken(req.Msg.PhoneNumber)
+	var (
+		profile *models.Profile
+		token   string
+	)
+
+	// the successful login is audited in the same transaction as the token it issued
+	err := auth.uow.Do(ctx, func(ctx context.Context) error {
+		var err error
+		profile, err = auth.profileRepo.Get(ctx, req.Msg.GetPhoneNumber())
+		if err != nil || profile == nil {
+			return connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
+		}
+		annotateAuditProfile(ctx, profile.ID, profile.PhoneNumber)
+
+		// the same conditional check as verifying, expiry included
+		switch err := auth.profileRepo.MatchOTP(ctx, req.Msg.GetPhoneNumber(), req.Msg.GetOtp()); {
+		case err == nil:
+		case errors.Is(err, persist.ErrNotVerified):
+			return connect.NewError(connect.CodeInternal, ErrPhoneNumberNotVerified)
+		case errors.Is(err, persist.ErrOtpMismatch):
+			return connect.NewError(connect.CodeInvalidArgument, ErrIncorrectOtp)
+		case errors.Is(err, persist.ErrOtpExpired):
+			return connect.NewError(connect.CodeInvalidArgument, ErrOtpExpired)
+		case errors.Is(err, persist.ErrProfileNotFound):
+			return connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
+		case errors.Is(err, persist.ErrProfileConflict):
+			return connect.NewError(connect.CodeAborted, err)
+		default:
+			return connect.NewError(connect.CodeInternal, err)
+		}
+
+		var session *SessionClaims
+		token, session, err = auth.authenticator.GenerateToken(req.Msg.PhoneNumber)
+		if err != nil {
+			return connect.NewError(connect.CodeInternal, ErrGenerateTokenFailed)
+		}
+		annotateAuditSession(ctx, session.SessionID)
+
+		if err := saveAuditInTx(ctx, auth.eventRepo, req.Header()); err != nil {
+			return connect.NewError(connect.CodeInternal, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, connect.NewError(connect.CodeInternal, ErrGenerateTokenFailed)
-	}
-
-	log.Println("event repo setting", profile.PhoneNumber)
-
-	if _, err := auth.eventRepo.Create(profile, EventTypeLogin); err != nil {
-		log.Println("event repo error", err)
-		log.Printf("failed to create event log for phone number:%s, event: %s\n", profile.PhoneNumber, EventTypeLogin)
+		unsaveAudit(ctx)
+		// published once the transaction is over, so the broker never holds it open
+		if errors.Is(err, ErrIncorrectOtp) {
+			auth.publishOtpFailed(ctx, profile, RpcLoginWithPhoneNumber)
+		}
+		var connectErr *connect.Error
+		if errors.As(err, &connectErr) {
+			return nil, connectErr
+		}
+		return nil, connect.NewError(connect.CodeInternal, err)
 	}
 
 	auth.cache.Set(req.Msg.PhoneNumber) // logged in users cache
+	metrics.Logins.Inc()
+
+	auth.publishEvent(ctx, mq.EventSessionStarted, &authv1.ProfileEvent{
+		ProfileId:   profile.ID,
+		PhoneNumber: profile.PhoneNumber,
+	})
 
 	return connect.NewResponse(&authv1.LoginWithPhoneNumberResponse{
 		SessionToken: token,
@@ -138,10 +210,11 @@ func (auth *authService) GetProfile(
 		return nil, connect.NewError(connect.CodeUnauthenticated, ErrInvalidSession)
 	}
 
-	profile, err := auth.profileRepo.Get(loggedInUserPhoneNumber)
+	profile, err := auth.profileRepo.Get(ctx, loggedInUserPhoneNumber)
 	if err != nil || profile == nil {
 		return nil, connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
 	}
+	annotateAuditProfile(ctx, profile.ID, profile.PhoneNumber)
 
 	return connect.NewResponse(&authv1.GetProfileResponse{
 		Id:          profile.ID,
@@ -159,29 +232,60 @@ func (auth *authService) Logout(
 	loggedInUserPhoneNumber := req.Header().Get(PhoneNumberHeader)
 
 	if loggedInUserPhoneNumber == "" {
+		annotateAuditMetadata(ctx, "logout", "already_logged_out")
 		return connect.NewResponse(&authv1.LogoutResponse{
 			Message: "user is already logged out, invalid token",
 		}), nil
 	}
 
 	auth.cache.Remove(loggedInUserPhoneNumber) // remove user from logged in users cache
-
-	// log the logout event
-	auth.eventRepo.Create(&models.Profile{PhoneNumber: loggedInUserPhoneNumber}, EventTypeLogout)
+	metrics.Logouts.Inc()
+
+	// the session ends either way, consumers correlate the event by profile id where it can be found
+	var profileID string
+	if profile, err := auth.profileRepo.Get(ctx, loggedInUserPhoneNumber); err == nil && profile != nil {
+		profileID = profile.ID
+		annotateAuditProfile(ctx, profile.ID, profile.PhoneNumber)
+	} else {
+		slog.WarnContext(ctx, "failed to find profile of ended session", "phone_number", loggedInUserPhoneNumber, "error", err)
+	}
+	auth.publishEvent(ctx, mq.EventSessionEnded, &authv1.ProfileEvent{
+		ProfileId:   profileID,
+		PhoneNumber: loggedInUserPhoneNumber,
+	})
 
 	return connect.NewResponse(&authv1.LogoutResponse{
 		Message: fmt.Sprintf("user with phone number: %s logged out successfully.", loggedInUserPhoneNumber),
 	}), nil
 }
 
+func (auth *authService) publishEvent(ctx context.Context, eventType string, event *authv1.ProfileEvent) {
+	if err := auth.events.PublishEvent(ctx, eventType, event); err != nil {
+		slog.ErrorContext(ctx, "failed to publish event", "event_type", eventType, "phone_number", event.PhoneNumber, "error", err)
+		metrics.MQPublishFailures.WithLabelValues(eventType).Inc()
+	}
+}
+
+func (auth *authService) publishOtpFailed(ctx context.Context, profile *models.Profile, rpc string) {
+	metrics.OtpMismatches.WithLabelValues(rpc).Inc()
+	auth.publishEvent(ctx, mq.EventOtpFailed, &authv1.ProfileEvent{
+		ProfileId:   profile.ID,
+		PhoneNumber: profile.PhoneNumber,
+		Reason:      fmt.Sprintf("%s: %s", rpc, ErrIncorrectOtp),
+	})
+}
+
 func NewAuthService(
 	profileRepo persist.ProfileRepo,
 	eventRepo persist.EventRepo,
+	uow persist.UnitOfWork,
 	publisher mq.MQClient,
+	events mq.EventPublisher,
 	authenticator SessionAuthenticator,
 	cache Cache,
+	exporter *dataexport.Exporter,
 ) *authService {
-	return &authService{profileRepo, eventRepo, publisher, authenticator, cache}
+	return &authService{profileRepo, eventRepo, uow, publisher, events, authenticator, cache, exporter}
 }
 
 func validatePhoneNumber(phoneNumber string) bool {
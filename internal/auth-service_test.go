@@ -0,0 +1,684 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/ilivestrong/auth-service/internal"
+	"github.com/ilivestrong/auth-service/internal/dataexport"
+	"github.com/ilivestrong/auth-service/internal/migrate"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	"github.com/ilivestrong/auth-service/internal/pii"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
+	"github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1/authv1connect"
+	mq "github.com/ilivestrong/auth-service/internal/rabbitmq"
+)
+
+const (
+	testPhoneNumber = "+911234567890"
+	testAdminToken  = "test-admin-token"
+)
+
+var errLoginAudit = errors.New("login audit failed")
+
+type (
+	testService struct {
+		client      authv1connect.AuthServiceClient
+		profileRepo persist.ProfileRepo
+		eventRepo   persist.EventRepo
+		published   *recordingPublisher
+	}
+
+	// failingLoginAudit is an event repository that cannot audit successful logins.
+	failingLoginAudit struct {
+		persist.EventRepo
+	}
+
+	// recordingPublisher keeps the domain events published through it.
+	recordingPublisher struct {
+		mq.EventPublisher
+		mu     sync.Mutex
+		events map[string][]*authv1.ProfileEvent
+	}
+)
+
+// Create stores event, but fails successful logins once their audit event is written
+// in the login transaction, which then has to roll it back.
+func (repo *failingLoginAudit) Create(ctx context.Context, event *models.Event) (string, error) {
+	id, err := repo.EventRepo.Create(ctx, event)
+	if err == nil && event.EventType == internal.EventTypeLogin && event.Outcome == models.OutcomeSuccess {
+		return "", errLoginAudit
+	}
+	return id, err
+}
+
+func (pub *recordingPublisher) PublishEvent(ctx context.Context, eventType string, event *authv1.ProfileEvent) error {
+	pub.mu.Lock()
+	pub.events[eventType] = append(pub.events[eventType], event)
+	pub.mu.Unlock()
+	return pub.EventPublisher.PublishEvent(ctx, eventType, event)
+}
+
+func (pub *recordingPublisher) get(eventType string) []*authv1.ProfileEvent {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	return pub.events[eventType]
+}
+
+// newTestService serves the auth service over httptest with in-memory storage and the
+// in-memory message bus, whose fake otp-service answers every signup with an OTP.
+func newTestService(t *testing.T) *testService {
+	t.Helper()
+
+	return serveTestService(t,
+		persist.NewInMemoryProfileRepository(persist.ProfileRepoOptions{}),
+		persist.NewInMemoryEventRepository(persist.EventRepoOptions{HashChain: true, ChainKey: make(persist.ChainKey, 32)}),
+		persist.NewInMemoryUnitOfWork(),
+	)
+}
+
+// serveTestService serves the auth service over httptest with the given storage and the
+// in-memory message bus.
+func serveTestService(t *testing.T, profileRepo persist.ProfileRepo, eventRepo persist.EventRepo, uow persist.UnitOfWork) *testService {
+	t.Helper()
+
+	cache := internal.NewInMemoryCache()
+
+	bus := mq.NewMemoryBus(mq.Options{})
+	mqclient := mq.NewInMemoryMQClient(bus, profileRepo, mq.Options{})
+	go mqclient.Consume(context.Background())
+
+	exporter := dataexport.NewExporter(persist.NewInMemoryDataExportRepository(), profileRepo, eventRepo, dataexport.Options{})
+	ctx, stopExporter := context.WithCancel(context.Background())
+	go exporter.Run(ctx)
+
+	published := &recordingPublisher{EventPublisher: mq.NewInMemoryEventPublisher(bus, mq.Options{}), events: make(map[string][]*authv1.ProfileEvent)}
+	authenticator := internal.NewAuthenticator(5)
+	authSvc := internal.NewAuthService(
+		profileRepo,
+		eventRepo,
+		uow,
+		mqclient,
+		published,
+		authenticator,
+		cache,
+		exporter,
+	)
+	interceptors := connect.WithInterceptors(
+		internal.NewRequestIDInterceptor(),
+		internal.NewAuditInterceptor(eventRepo),
+		internal.NewTokenInterceptor(authenticator, cache, testAdminToken),
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle(authv1connect.NewAuthServiceHandler(authSvc, interceptors))
+	server := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		server.Close()
+		stopExporter()
+		bus.Close()
+	})
+
+	return &testService{
+		client:      authv1connect.NewAuthServiceClient(server.Client(), server.URL),
+		profileRepo: profileRepo,
+		eventRepo:   eventRepo,
+		published:   published,
+	}
+}
+
+func (ts *testService) signup(t *testing.T, phoneNumber string) string {
+	t.Helper()
+
+	_, err := ts.client.SignupWithPhoneNumber(context.Background(), connect.NewRequest(&authv1.SignupWithPhoneNumberRequest{
+		PhoneNumber: phoneNumber,
+		Name:        "Jane",
+	}))
+	if err != nil {
+		t.Fatalf("signup failed: %v", err)
+	}
+	return ts.waitForOtp(t, phoneNumber)
+}
+
+// waitForOtp waits for the fake otp-service reply to reach the profile.
+func (ts *testService) waitForOtp(t *testing.T, phoneNumber string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		profile, err := ts.profileRepo.Get(context.Background(), phoneNumber)
+		if err == nil && profile.Otp != "" {
+			return profile.Otp
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no otp arrived for %s", phoneNumber)
+	return ""
+}
+
+func (ts *testService) verify(t *testing.T, phoneNumber string, otp string) {
+	t.Helper()
+
+	_, err := ts.client.VerifyPhoneNumber(context.Background(), connect.NewRequest(&authv1.VerifyPhoneNumberRequest{
+		PhoneNumber: phoneNumber,
+		Otp:         otp,
+	}))
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+}
+
+func (ts *testService) login(t *testing.T, phoneNumber string, otp string) string {
+	t.Helper()
+
+	res, err := ts.client.LoginWithPhoneNumber(context.Background(), connect.NewRequest(&authv1.LoginWithPhoneNumberRequest{
+		PhoneNumber: phoneNumber,
+		Otp:         otp,
+	}))
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	return res.Msg.GetSessionToken()
+}
+
+func withToken[T any](msg *T, token string) *connect.Request[T] {
+	req := connect.NewRequest(msg)
+	req.Header().Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func assertCode(t *testing.T, err error, want connect.Code) {
+	t.Helper()
+
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) {
+		t.Fatalf("got error %v, want code %s", err, want)
+	}
+	if connectErr.Code() != want {
+		t.Fatalf("got code %s (%s), want %s", connectErr.Code(), connectErr.Message(), want)
+	}
+}
+
+func TestSignupVerifyLoginProfileLogout(t *testing.T) {
+	ts := newTestService(t)
+	ctx := context.Background()
+
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+	token := ts.login(t, testPhoneNumber, otp)
+
+	profile, err := ts.client.GetProfile(ctx, withToken(&authv1.GetProfileRequest{}, token))
+	if err != nil {
+		t.Fatalf("get profile failed: %v", err)
+	}
+	if profile.Msg.GetPhoneNumber() != testPhoneNumber || profile.Msg.GetName() != "Jane" || !profile.Msg.GetIsVerified() {
+		t.Fatalf("unexpected profile: %v", profile.Msg)
+	}
+
+	logout, err := ts.client.Logout(ctx, withToken(&authv1.LogoutRequest{}, token))
+	if err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	if logout.Msg.GetMessage() == "" {
+		t.Fatal("logout returned no message")
+	}
+
+	_, err = ts.client.GetProfile(ctx, withToken(&authv1.GetProfileRequest{}, token))
+	assertCode(t, err, connect.CodeUnauthenticated)
+
+	// every call above, including the rejected one, is audited and chained
+	events, err := ts.client.ListEvents(ctx, withToken(&authv1.ListEventsRequest{PhoneNumber: testPhoneNumber}, testAdminToken))
+	if err != nil {
+		t.Fatalf("list events failed: %v", err)
+	}
+
+	wantTypes := []string{
+		internal.EventTypeGetProfile,
+		internal.EventTypeLogout,
+		internal.EventTypeGetProfile,
+		internal.EventTypeLogin,
+		internal.EventTypeVerify,
+		internal.EventTypeSignup,
+	}
+	if len(events.Msg.GetEvents()) != len(wantTypes) {
+		t.Fatalf("got %d events, want %d", len(events.Msg.GetEvents()), len(wantTypes))
+	}
+	for i, event := range events.Msg.GetEvents() {
+		if event.GetEventType() != wantTypes[i] {
+			t.Errorf("event %d: got type %s, want %s", i, event.GetEventType(), wantTypes[i])
+		}
+	}
+	if outcome := events.Msg.GetEvents()[0].GetOutcome(); outcome != "failure" {
+		t.Errorf("rejected get profile recorded as %s", outcome)
+	}
+
+	report, err := ts.client.VerifyAuditLog(ctx, withToken(&authv1.VerifyAuditLogRequest{}, testAdminToken))
+	if err != nil {
+		t.Fatalf("verify audit log failed: %v", err)
+	}
+	if !report.Msg.GetIntact() {
+		t.Fatalf("audit log broken: %v", report.Msg.GetFirstBrokenLink())
+	}
+
+	// the admin's filter is recorded as target, not as the phone number of the admin's call
+	adminEvents, err := ts.client.ListEvents(ctx, withToken(&authv1.ListEventsRequest{EventTypes: []string{"RPC_ListEvents"}}, testAdminToken))
+	if err != nil {
+		t.Fatalf("list events failed: %v", err)
+	}
+	for _, event := range adminEvents.Msg.GetEvents() {
+		if event.GetPhoneNumber() != "" || event.GetMetadata()["target_stream"] == "" {
+			t.Fatalf("admin call recorded with phone number %q, metadata %v", event.GetPhoneNumber(), event.GetMetadata())
+		}
+	}
+	if len(adminEvents.Msg.GetEvents()) == 0 {
+		t.Fatal("admin call was not audited")
+	}
+}
+
+func TestListMyEventsPagination(t *testing.T) {
+	ts := newTestService(t)
+	ctx := context.Background()
+
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+	token := ts.login(t, testPhoneNumber, otp)
+
+	seen := map[string]bool{}
+	var pageToken string
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not end")
+		}
+
+		res, err := ts.client.ListMyEvents(ctx, withToken(&authv1.ListMyEventsRequest{PageSize: 2, PageToken: pageToken}, token))
+		if err != nil {
+			t.Fatalf("list my events failed: %v", err)
+		}
+		for _, event := range res.Msg.GetEvents() {
+			if seen[event.GetId()] {
+				t.Fatalf("event %s listed twice", event.GetId())
+			}
+			seen[event.GetId()] = true
+		}
+
+		if pageToken = res.Msg.GetNextPageToken(); pageToken == "" {
+			break
+		}
+	}
+
+	// signup, verify and login, the running ListMyEvents calls are recorded once they finish
+	if len(seen) < 3 {
+		t.Fatalf("got %d events, want at least 3", len(seen))
+	}
+}
+
+func TestErrorPaths(t *testing.T) {
+	ts := newTestService(t)
+	ctx := context.Background()
+
+	verifiedOtp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, verifiedOtp)
+
+	const unverifiedPhoneNumber = "+919876543210"
+	unverifiedOtp := ts.signup(t, unverifiedPhoneNumber)
+
+	tests := []struct {
+		name string
+		call func() error
+		want connect.Code
+	}{
+		{"signup with invalid phone number", func() error {
+			_, err := ts.client.SignupWithPhoneNumber(ctx, connect.NewRequest(&authv1.SignupWithPhoneNumberRequest{PhoneNumber: "12345"}))
+			return err
+		}, connect.CodeInvalidArgument},
+		{"signup twice", func() error {
+			_, err := ts.client.SignupWithPhoneNumber(ctx, connect.NewRequest(&authv1.SignupWithPhoneNumberRequest{PhoneNumber: testPhoneNumber}))
+			return err
+		}, connect.CodeInvalidArgument},
+		{"signup again before verifying", func() error {
+			_, err := ts.client.SignupWithPhoneNumber(ctx, connect.NewRequest(&authv1.SignupWithPhoneNumberRequest{PhoneNumber: unverifiedPhoneNumber}))
+			return err
+		}, connect.CodeInvalidArgument},
+		{"verify unknown phone number", func() error {
+			_, err := ts.client.VerifyPhoneNumber(ctx, connect.NewRequest(&authv1.VerifyPhoneNumberRequest{PhoneNumber: "+910000000000", Otp: "123456"}))
+			return err
+		}, connect.CodeNotFound},
+		{"verify with wrong otp", func() error {
+			_, err := ts.client.VerifyPhoneNumber(ctx, connect.NewRequest(&authv1.VerifyPhoneNumberRequest{PhoneNumber: unverifiedPhoneNumber, Otp: "wrong"}))
+			return err
+		}, connect.CodeInvalidArgument},
+		{"verify twice", func() error {
+			_, err := ts.client.VerifyPhoneNumber(ctx, connect.NewRequest(&authv1.VerifyPhoneNumberRequest{PhoneNumber: testPhoneNumber, Otp: verifiedOtp}))
+			return err
+		}, connect.CodeInvalidArgument},
+		{"login unknown phone number", func() error {
+			_, err := ts.client.LoginWithPhoneNumber(ctx, connect.NewRequest(&authv1.LoginWithPhoneNumberRequest{PhoneNumber: "+910000000000", Otp: "123456"}))
+			return err
+		}, connect.CodeNotFound},
+		{"login before verifying", func() error {
+			_, err := ts.client.LoginWithPhoneNumber(ctx, connect.NewRequest(&authv1.LoginWithPhoneNumberRequest{PhoneNumber: unverifiedPhoneNumber, Otp: unverifiedOtp}))
+			return err
+		}, connect.CodeInternal},
+		{"login with wrong otp", func() error {
+			_, err := ts.client.LoginWithPhoneNumber(ctx, connect.NewRequest(&authv1.LoginWithPhoneNumberRequest{PhoneNumber: testPhoneNumber, Otp: "wrong"}))
+			return err
+		}, connect.CodeInvalidArgument},
+		{"get profile without token", func() error {
+			_, err := ts.client.GetProfile(ctx, connect.NewRequest(&authv1.GetProfileRequest{}))
+			return err
+		}, connect.CodeUnauthenticated},
+		{"get profile with invalid token", func() error {
+			_, err := ts.client.GetProfile(ctx, withToken(&authv1.GetProfileRequest{}, "not-a-token"))
+			return err
+		}, connect.CodeUnauthenticated},
+		{"get profile with spoofed identity header", func() error {
+			req := connect.NewRequest(&authv1.GetProfileRequest{})
+			req.Header().Set(internal.PhoneNumberHeader, testPhoneNumber)
+			_, err := ts.client.GetProfile(ctx, req)
+			return err
+		}, connect.CodeUnauthenticated},
+		{"list events without admin token", func() error {
+			_, err := ts.client.ListEvents(ctx, connect.NewRequest(&authv1.ListEventsRequest{}))
+			return err
+		}, connect.CodePermissionDenied},
+		{"list events with a session token", func() error {
+			token := ts.login(t, testPhoneNumber, verifiedOtp)
+			_, err := ts.client.ListEvents(ctx, withToken(&authv1.ListEventsRequest{}, token))
+			return err
+		}, connect.CodePermissionDenied},
+		{"list my events with invalid page token", func() error {
+			token := ts.login(t, testPhoneNumber, verifiedOtp)
+			_, err := ts.client.ListMyEvents(ctx, withToken(&authv1.ListMyEventsRequest{PageToken: "%%%"}, token))
+			return err
+		}, connect.CodeInvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertCode(t, tt.call(), tt.want)
+		})
+	}
+
+	if profile, err := ts.profileRepo.Get(ctx, "12345"); err == nil && profile != nil {
+		t.Fatal("signup with an invalid phone number created a profile")
+	}
+}
+
+func TestSessionEventsCarryProfileID(t *testing.T) {
+	ts := newTestService(t)
+
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+	token := ts.login(t, testPhoneNumber, otp)
+	if _, err := ts.client.Logout(context.Background(), withToken(&authv1.LogoutRequest{}, token)); err != nil {
+		t.Fatal(err)
+	}
+
+	profile, err := ts.profileRepo.Get(context.Background(), testPhoneNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, eventType := range []string{mq.EventProfileCreated, mq.EventProfileVerified, mq.EventSessionStarted, mq.EventSessionEnded} {
+		events := ts.published.get(eventType)
+		if len(events) != 1 || events[0].GetProfileId() != profile.ID {
+			t.Errorf("got %s events %v, want one of profile %s", eventType, events, profile.ID)
+		}
+	}
+}
+
+func TestFailedLoginRollsBackItsAudit(t *testing.T) {
+	ctx := context.Background()
+	db, err := persist.OpenSQLite(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator, err := migrate.NewMigrator(db, migrate.DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	keyring, err := pii.ParseKeys("1:" + key + ",index:" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eventRepo := persist.NewEventRepository(db, persist.EventRepoOptions{PII: keyring})
+	ts := serveTestService(t,
+		persist.NewProfileRepository(db, persist.ProfileRepoOptions{PII: keyring}),
+		&failingLoginAudit{eventRepo},
+		persist.NewUnitOfWork(db, 0),
+	)
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+	_, err = ts.client.LoginWithPhoneNumber(ctx, connect.NewRequest(&authv1.LoginWithPhoneNumberRequest{
+		PhoneNumber: testPhoneNumber,
+		Otp:         otp,
+	}))
+	assertCode(t, err, connect.CodeInternal)
+
+	events, err := eventRepo.List(ctx, persist.EventFilter{EventTypes: []string{internal.EventTypeLogin}}, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Outcome != models.OutcomeFailure {
+		t.Fatalf("got login events %+v, want only the failure recorded after the rollback", events)
+	}
+}
+
+func TestLoginWithWrongOtpPublishesOtpFailed(t *testing.T) {
+	ts := newTestService(t)
+
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+	_, err := ts.client.LoginWithPhoneNumber(context.Background(), connect.NewRequest(&authv1.LoginWithPhoneNumberRequest{
+		PhoneNumber: testPhoneNumber,
+		Otp:         "not" + otp,
+	}))
+	assertCode(t, err, connect.CodeInvalidArgument)
+
+	if events := ts.published.get(mq.EventOtpFailed); len(events) != 1 || events[0].GetPhoneNumber() != testPhoneNumber {
+		t.Fatalf("got otp.failed events %v, want one for the login", events)
+	}
+	if events := ts.published.get(mq.EventSessionStarted); len(events) != 0 {
+		t.Fatalf("got session.started events %v for a failed login", events)
+	}
+}
+
+func TestLogoutTwice(t *testing.T) {
+	ts := newTestService(t)
+	ctx := context.Background()
+
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+	token := ts.login(t, testPhoneNumber, otp)
+
+	first, err := ts.client.Logout(ctx, withToken(&authv1.LogoutRequest{}, token))
+	if err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	second, err := ts.client.Logout(ctx, withToken(&authv1.LogoutRequest{}, token))
+	if err != nil {
+		t.Fatalf("second logout failed: %v", err)
+	}
+	if first.Msg.GetMessage() == second.Msg.GetMessage() {
+		t.Fatalf("second logout was not reported as already logged out: %q", second.Msg.GetMessage())
+	}
+}
+
+func TestExportEventsRedacted(t *testing.T) {
+	ts := newTestService(t)
+	ctx := context.Background()
+
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+
+	stream, err := ts.client.ExportEvents(ctx, withToken(&authv1.ExportEventsRequest{RedactPhoneNumbers: true}, testAdminToken))
+	if err != nil {
+		t.Fatalf("export events failed: %v", err)
+	}
+	var data []byte
+	for stream.Receive() {
+		data = append(data, stream.Msg().GetData()...)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("export events failed: %v", err)
+	}
+
+	records := 0
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var record map[string]any
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("export is not ndjson: %v", err)
+		}
+		if record["phone_number"] != "+********7890" {
+			t.Errorf("got phone number %v in redacted export", record["phone_number"])
+		}
+		for _, field := range []string{"stream", "sequence", "prev_hash", "hash"} {
+			if _, ok := record[field]; ok {
+				t.Errorf("redacted export has %s", field)
+			}
+		}
+		records++
+	}
+	if records != 2 {
+		t.Fatalf("got %d exported events, want 2", records)
+	}
+}
+
+func TestExportMyData(t *testing.T) {
+	ts := newTestService(t)
+	ctx := context.Background()
+
+	otp := ts.signup(t, testPhoneNumber)
+	ts.verify(t, testPhoneNumber, otp)
+	token := ts.login(t, testPhoneNumber, otp)
+
+	started, err := ts.client.ExportMyData(ctx, withToken(&authv1.ExportMyDataRequest{}, token))
+	if err != nil {
+		t.Fatalf("export my data failed: %v", err)
+	}
+	exportID := started.Msg.GetExport().GetId()
+
+	var export *authv1.DataExport
+	deadline := time.Now().Add(2 * time.Second)
+	for export.GetStatus() != authv1.DataExportStatus_DATA_EXPORT_STATUS_DONE {
+		if time.Now().After(deadline) {
+			t.Fatalf("export did not finish: %v", export)
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		res, err := ts.client.GetMyDataExport(ctx, withToken(&authv1.GetMyDataExportRequest{ExportId: exportID}, token))
+		if err != nil {
+			t.Fatalf("get my data export failed: %v", err)
+		}
+		export = res.Msg.GetExport()
+	}
+
+	stream, err := ts.client.DownloadMyDataExport(ctx, withToken(&authv1.DownloadMyDataExportRequest{ExportId: exportID}, token))
+	if err != nil {
+		t.Fatalf("download my data export failed: %v", err)
+	}
+	var data []byte
+	for stream.Receive() {
+		data = append(data, stream.Msg().GetData()...)
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("download my data export failed: %v", err)
+	}
+	if int64(len(data)) != export.GetArchiveSize() {
+		t.Fatalf("downloaded %d bytes, archive has %d", len(data), export.GetArchiveSize())
+	}
+
+	var archive dataexport.Archive
+	if err := json.Unmarshal(data, &archive); err != nil {
+		t.Fatalf("archive is not json: %v", err)
+	}
+	if archive.Profile.PhoneNumber != testPhoneNumber || archive.Profile.Name != "Jane" {
+		t.Fatalf("unexpected profile in archive: %+v", archive.Profile)
+	}
+	// signup, verify, login and the export request itself
+	if len(archive.Events) < 4 || len(archive.Sessions) != 1 {
+		t.Fatalf("got %d events and %d sessions in archive", len(archive.Events), len(archive.Sessions))
+	}
+
+	const otherPhoneNumber = "+919876543210"
+	otherOtp := ts.signup(t, otherPhoneNumber)
+	ts.verify(t, otherPhoneNumber, otherOtp)
+	otherToken := ts.login(t, otherPhoneNumber, otherOtp)
+
+	_, err = ts.client.GetMyDataExport(ctx, withToken(&authv1.GetMyDataExportRequest{ExportId: exportID}, otherToken))
+	assertCode(t, err, connect.CodeNotFound)
+
+	stream, err = ts.client.DownloadMyDataExport(ctx, withToken(&authv1.DownloadMyDataExportRequest{ExportId: exportID}, otherToken))
+	if err != nil {
+		t.Fatalf("download my data export failed: %v", err)
+	}
+	for stream.Receive() {
+		t.Fatal("downloaded the export of another profile")
+	}
+	assertCode(t, stream.Err(), connect.CodeNotFound)
+
+	res, err := ts.client.GetDataExport(ctx, withToken(&authv1.GetDataExportRequest{ExportId: exportID}, testAdminToken))
+	if err != nil || res.Msg.GetExport().GetProfileId() != export.GetProfileId() {
+		t.Fatalf("admin could not get export: %v, %v", res, err)
+	}
+
+	adminStream, err := ts.client.DownloadDataExport(ctx, withToken(&authv1.DownloadDataExportRequest{ExportId: exportID}, testAdminToken))
+	if err != nil {
+		t.Fatalf("download data export failed: %v", err)
+	}
+	var adminData []byte
+	for adminStream.Receive() {
+		adminData = append(adminData, adminStream.Msg().GetData()...)
+	}
+	if err := adminStream.Err(); err != nil || !bytes.Equal(adminData, data) {
+		t.Fatalf("admin download differs from the archive: %v", err)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ts := newTestService(t)
+	ctx := context.Background()
+
+	req := connect.NewRequest(&authv1.SignupWithPhoneNumberRequest{PhoneNumber: testPhoneNumber, Name: "Jane"})
+	req.Header().Set(internal.RequestIDHeader, "req-123")
+	res, err := ts.client.SignupWithPhoneNumber(ctx, req)
+	if err != nil {
+		t.Fatalf("signup failed: %v", err)
+	}
+	if got := res.Header().Get(internal.RequestIDHeader); got != "req-123" {
+		t.Fatalf("got request id %q in response", got)
+	}
+
+	// invalid ids are replaced, also on failed calls
+	req = connect.NewRequest(&authv1.SignupWithPhoneNumberRequest{PhoneNumber: "12345"})
+	req.Header().Set(internal.RequestIDHeader, "bad id")
+	_, err = ts.client.SignupWithPhoneNumber(ctx, req)
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) || connectErr.Meta().Get(internal.RequestIDHeader) == "" || connectErr.Meta().Get(internal.RequestIDHeader) == "bad id" {
+		t.Fatalf("failed call got no generated request id: %v", err)
+	}
+
+	events, err := ts.client.ListEvents(ctx, withToken(&authv1.ListEventsRequest{PhoneNumber: testPhoneNumber}, testAdminToken))
+	if err != nil {
+		t.Fatalf("list events failed: %v", err)
+	}
+	if got := events.Msg.GetEvents()[0].GetRequestId(); got != "req-123" {
+		t.Fatalf("got request id %q on audit event", got)
+	}
+}
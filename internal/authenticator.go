@@ -3,10 +3,11 @@ package internal
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 const (
@@ -15,30 +16,36 @@ const (
 
 type (
 	SessionAuthenticator interface {
-		GenerateToken(phoneNumber string) (string, error)
-		ParseToken(token string) (string, error)
+		GenerateToken(phoneNumber string) (string, *SessionClaims, error)
+		ParseToken(token string) (*SessionClaims, error)
+	}
+	SessionClaims struct {
+		PhoneNumber string
+		SessionID   string
 	}
 	authenticator struct {
 		tokenTimeoutInMins int
 	}
 )
 
-func (auth *authenticator) GenerateToken(phoneNumber string) (string, error) {
+func (auth *authenticator) GenerateToken(phoneNumber string) (string, *SessionClaims, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
+	session := &SessionClaims{PhoneNumber: phoneNumber, SessionID: uuid.New().String()}
 
 	claims := token.Claims.(jwt.MapClaims)
-	claims["phone_number"] = phoneNumber
+	claims["phone_number"] = session.PhoneNumber
+	claims["jti"] = session.SessionID
 	claims["exp"] = time.Now().Add(time.Minute * time.Duration(auth.tokenTimeoutInMins)).Unix()
 
 	tokenString, err := token.SignedString([]byte(Jwt_Signing_Secret_Key))
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return tokenString, nil
+	return tokenString, session, nil
 }
 
-func (auth *authenticator) ParseToken(tokenString string) (string, error) {
+func (auth *authenticator) ParseToken(tokenString string) (*SessionClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -46,21 +53,22 @@ func (auth *authenticator) ParseToken(tokenString string) (string, error) {
 		return []byte(Jwt_Signing_Secret_Key), nil
 	})
 	if err != nil {
-		log.Println(err)
-		return "", errors.New("invalid token")
+		slog.Debug("failed to parse token", "error", err)
+		return nil, errors.New("invalid token")
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		fmt.Println(err)
-		return "", err
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
 	}
 
-	if !token.Valid {
-		return "", errors.New("invalid token")
+	phoneNumber, ok := claims["phone_number"].(string)
+	if !ok {
+		return nil, errors.New("invalid token")
 	}
+	sessionID, _ := claims["jti"].(string)
 
-	return claims["phone_number"].(string), nil
+	return &SessionClaims{PhoneNumber: phoneNumber, SessionID: sessionID}, nil
 }
 
 func NewAuthenticator(timeoutInMins int) SessionAuthenticator {
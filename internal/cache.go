@@ -1,12 +1,19 @@
 package internal
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type (
 	Cache interface {
 		Get(key string) bool
 		Set(key string)
 		Remove(key string)
+		// Len counts the keys set.
+		Len() int
+		// Ping reports whether the cache backend is reachable.
+		Ping(ctx context.Context) error
 	}
 
 	inMemoryCache struct {
@@ -37,6 +44,17 @@ func (memCache *inMemoryCache) Remove(key string) {
 	delete(memCache.data, key)
 }
 
+func (memCache *inMemoryCache) Len() int {
+	memCache.mu.Lock()
+	defer memCache.mu.Unlock()
+
+	return len(memCache.data)
+}
+
+func (memCache *inMemoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
 func NewInMemoryCache() Cache {
 	return &inMemoryCache{data: make(map[string]struct{})}
 }
@@ -0,0 +1,111 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCheckInterval = 10 * time.Second
+)
+
+type (
+	// Reloader serves a certificate from disk and picks up renewals, e.g. by cert-manager or
+	// certbot, without restarting the server.
+	Reloader struct {
+		certFile      string
+		keyFile       string
+		checkInterval time.Duration
+
+		cert atomic.Pointer[tls.Certificate]
+		// only touched by reload, which runs before the handshakes and in Run
+		modTime time.Time
+	}
+)
+
+// GetCertificate implements tls.Config.GetCertificate, serving the key pair loaded last.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// TLSConfig returns a server configuration serving the certificate of r.
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Run reloads the key pair every check interval when either file changed, until ctx is done. A
+// key pair that fails to load is logged and the current one is kept.
+func (r *Reloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := r.reload(); err != nil {
+			slog.Warn("tls: failed to reload certificate, keeping the current one", "cert_file", r.certFile, "error", err)
+		}
+	}
+}
+
+func (r *Reloader) reload() error {
+	modTime, err := lastModified(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert.Load() != nil && modTime.Equal(r.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert.Load() != nil {
+		slog.Info("tls: certificate reloaded", "cert_file", r.certFile)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return nil
+}
+
+// lastModified is the latest modification time of files, following symlinks as mounted
+// Kubernetes secrets are swapped by replacing one.
+func lastModified(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewReloader loads the key pair of certFile and keyFile, which Run checks for changes every
+// checkInterval, 10 seconds when zero.
+func NewReloader(certFile string, keyFile string, checkInterval time.Duration) (*Reloader, error) {
+	if checkInterval <= 0 {
+		checkInterval = defaultCheckInterval
+	}
+
+	r := &Reloader{certFile: certFile, keyFile: keyFile, checkInterval: checkInterval}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,163 @@
+package certs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/certs"
+)
+
+const checkInterval = 10 * time.Millisecond
+
+func TestReloaderPicksUpSwappedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeKeyPair(t, certFile, keyFile, "old", time.Now())
+
+	reloader, err := certs.NewReloader(certFile, keyFile, checkInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(t, reloader); name != "old" {
+		t.Fatalf("serves %s, want old", name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Run(ctx)
+
+	writeKeyPair(t, certFile, keyFile, "new", time.Now().Add(time.Second))
+	waitForName(t, reloader, "new")
+
+	// a broken renewal keeps the current certificate
+	brokenAt := time.Now().Add(2 * time.Second)
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, brokenAt, brokenAt); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * checkInterval)
+	if name := servedName(t, reloader); name != "new" {
+		t.Fatalf("serves %s after a broken renewal, want new", name)
+	}
+
+	writeKeyPair(t, certFile, keyFile, "fixed", time.Now().Add(3*time.Second))
+	waitForName(t, reloader, "fixed")
+}
+
+func TestReloaderServesOverTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeKeyPair(t, certFile, keyFile, "localhost", time.Now())
+
+	reloader, err := certs.NewReloader(certFile, keyFile, checkInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", reloader.TLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if name := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "localhost" {
+		t.Fatalf("handshake served %s, want localhost", name)
+	}
+}
+
+func TestNewReloaderFailsWithoutKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := certs.NewReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), 0); err == nil {
+		t.Fatal("got a reloader without certificate files")
+	}
+}
+
+// waitForName fails the test unless reloader serves the certificate named name within a second.
+func waitForName(t *testing.T, reloader *certs.Reloader, name string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for servedName(t, reloader) != name {
+		if time.Now().After(deadline) {
+			t.Fatalf("still serves %s, want %s", servedName(t, reloader), name)
+		}
+		time.Sleep(checkInterval)
+	}
+}
+
+// servedName returns the common name of the certificate reloader serves.
+func servedName(t *testing.T, reloader *certs.Reloader) string {
+	t.Helper()
+
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// writeKeyPair writes a self-signed certificate for name and its key, both last modified at
+// modTime, so a swap is noticed however coarse the file system's timestamps are.
+func writeKeyPair(t *testing.T, certFile string, keyFile string, name string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var certPEM, keyPEM bytes.Buffer
+	pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&keyPEM, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	for file, content := range map[string][]byte{certFile: certPEM.Bytes(), keyFile: keyPEM.Bytes()} {
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
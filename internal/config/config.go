@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	MQDriverAMQP   = "amqp"
+	MQDriverMemory = "memory"
+
+	DBDriverPostgres = "postgres"
+	DBDriverSQLite   = "sqlite"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid configuration")
+)
+
+// Every setting is tagged with its key in configuration files, from which its flag is derived,
+// and with its environment variable. Secrets are masked when printed and can be read from the
+// file named by <key>_file or <ENV>_FILE.
+type (
+	Config struct {
+		Server     Server     `config:"server"`
+		Auth       Auth       `config:"auth"`
+		DB         DB         `config:"db"`
+		MQ         MQ         `config:"mq"`
+		PII        PII        `config:"pii"`
+		Audit      Audit      `config:"audit"`
+		Retention  Retention  `config:"retention"`
+		DataExport DataExport `config:"data_export"`
+		Log        Log        `config:"log"`
+		Tracing    Tracing    `config:"tracing"`
+
+		sources map[string]string
+	}
+
+	Server struct {
+		BindAddress             string `config:"bind_address" env:"BIND_ADDRESS" default:"localhost" usage:"host name or IP to listen on, all interfaces when empty"`
+		Port                    int    `config:"port" env:"PORT" default:"8080" usage:"port to serve on"`
+		TLSCertFile             string `config:"tls_cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate chain, serves HTTPS when set, reloaded when it changes"`
+		TLSKeyFile              string `config:"tls_key_file" env:"TLS_KEY_FILE" usage:"PEM private key of the certificate"`
+		H2C                     bool   `config:"h2c" env:"HTTP_H2C" usage:"accept HTTP/2 without TLS"`
+		ReadHeaderTimeoutInSecs int    `config:"read_header_timeout_in_seconds" env:"HTTP_READ_HEADER_TIMEOUT_IN_SECONDS" default:"10" usage:"time clients have to send request headers"`
+		IdleTimeoutInSecs       int    `config:"idle_timeout_in_seconds" env:"HTTP_IDLE_TIMEOUT_IN_SECONDS" default:"120" usage:"time idle keep-alive connections are kept open"`
+		MaxBodyBytes            int    `config:"max_body_bytes" env:"HTTP_MAX_BODY_BYTES" default:"4194304" usage:"largest request body accepted"`
+		ShutdownTimeoutInSecs   int    `config:"shutdown_timeout_in_seconds" env:"SHUTDOWN_TIMEOUT_IN_SECONDS" default:"25" usage:"time a graceful shutdown may take"`
+		DrainDelayInSecs        int    `config:"drain_delay_in_seconds" env:"SHUTDOWN_DRAIN_DELAY_IN_SECONDS" default:"5" usage:"time between reporting not ready and refusing connections on shutdown"`
+	}
+
+	Auth struct {
+		TokenExpiryInMinutes int    `config:"token_expiry_in_minutes" env:"TOKEN_EXPIRY_IN_MINUTES" default:"20" usage:"validity of login tokens"`
+		OtpTTLInMinutes      int    `config:"otp_ttl_in_minutes" env:"OTP_TTL_IN_MINUTES" default:"0" usage:"validity of OTPs for verifying and logging in, 0 for no expiry"`
+		AdminToken           string `config:"admin_token" env:"ADMIN_TOKEN" secret:"true" usage:"bearer token of admin RPCs, disabled when empty"`
+	}
+
+	DB struct {
+		Driver              string   `config:"driver" env:"DB_DRIVER" default:"postgres" usage:"postgres or sqlite"`
+		Path                string   `config:"path" env:"DB_PATH" default:"auth-service.db" usage:"sqlite database file, :memory: for none"`
+		Host                string   `config:"host" env:"DB_HOST" usage:"postgres host"`
+		Port                int      `config:"port" env:"DB_PORT" default:"5432" usage:"postgres port"`
+		Name                string   `config:"name" env:"DB_NAME" usage:"postgres database"`
+		Username            string   `config:"username" env:"DB_USERNAME" usage:"postgres user"`
+		Password            string   `config:"password" env:"DB_PASSWORD" secret:"true" usage:"postgres password"`
+		QueryTimeoutInSecs  int      `config:"query_timeout_in_seconds" env:"DB_QUERY_TIMEOUT_IN_SECONDS" default:"5" usage:"upper bound of every database call"`
+		MigrateOnStart      bool     `config:"migrate_on_start" env:"DB_MIGRATE_ON_START" usage:"apply pending migrations on start"`
+		ReplicaDSNs         []string `config:"replica_dsns" env:"DB_REPLICA_DSNS" secret:"true" usage:"comma separated postgres DSNs of read replicas"`
+		ReplicaMaxLagInSecs int      `config:"replica_max_lag_in_seconds" env:"DB_REPLICA_MAX_LAG_IN_SECONDS" default:"5" usage:"replication lag after which a replica is skipped"`
+		ReplicaStickyInSecs int      `config:"replica_sticky_in_seconds" env:"DB_REPLICA_STICKY_IN_SECONDS" default:"10" usage:"time a phone number is read from the primary after writing it"`
+	}
+
+	MQ struct {
+		Driver           string `config:"driver" env:"MQ_DRIVER" default:"amqp" usage:"amqp or memory"`
+		AMQPAddress      string `config:"amqp_address" env:"AMQP_ADDRESS" secret:"true" usage:"RabbitMQ URL"`
+		ContentType      string `config:"content_type" env:"MQ_CONTENT_TYPE" default:"application/json" usage:"application/json or application/x-protobuf"`
+		Prefetch         int    `config:"prefetch" env:"MQ_PREFETCH" default:"10" usage:"otps_created messages in flight"`
+		Concurrency      int    `config:"concurrency" env:"MQ_CONCURRENCY" default:"1" usage:"otps_created workers"`
+		MaxRetries       int    `config:"max_retries" env:"MQ_MAX_RETRIES" default:"5" usage:"retries of otps_created messages before dead-lettering"`
+		RetryDelayInSecs int    `config:"retry_delay_in_seconds" env:"MQ_RETRY_DELAY_IN_SECONDS" default:"5" usage:"delay between retries"`
+	}
+
+	PII struct {
+		Keys string `config:"keys" env:"PII_KEYS" secret:"true" usage:"keys encrypting phone numbers and names"`
+	}
+
+	Audit struct {
+		HashChain bool   `config:"hash_chain" env:"AUDIT_HASH_CHAIN" usage:"hash chain audit events"`
+		HashKey   string `config:"hash_key" env:"AUDIT_HASH_KEY" secret:"true" usage:"base64 encoded 32 byte key of the audit hash chain"`
+	}
+
+	Retention struct {
+		Policy                 string `config:"policy" env:"EVENT_RETENTION" usage:"how long events are kept, e.g. 90d,otp.failed=7d"`
+		PurgeIntervalInMinutes int    `config:"purge_interval_in_minutes" env:"EVENT_PURGE_INTERVAL_IN_MINUTES" default:"60" usage:"time between purges"`
+		PurgeBatchSize         int    `config:"purge_batch_size" env:"EVENT_PURGE_BATCH_SIZE" default:"1000" usage:"events deleted per transaction"`
+		PurgeDryRun            bool   `config:"purge_dry_run" env:"EVENT_PURGE_DRY_RUN" usage:"only report what would be purged"`
+		ArchiveDir             string `config:"archive_dir" env:"EVENT_ARCHIVE_DIR" usage:"directory purged events are archived to"`
+	}
+
+	DataExport struct {
+		TTLInHours            int `config:"ttl_in_hours" env:"DATA_EXPORT_TTL_IN_HOURS" default:"24" usage:"time finished exports are kept"`
+		PollIntervalInSeconds int `config:"poll_interval_in_seconds" env:"DATA_EXPORT_POLL_INTERVAL_IN_SECONDS" default:"60" usage:"time between looks for pending exports"`
+	}
+
+	Log struct {
+		Format string `config:"format" env:"LOG_FORMAT" default:"text" usage:"text or json"`
+		Level  string `config:"level" env:"LOG_LEVEL" default:"info" usage:"debug, info, warn or error"`
+	}
+
+	Tracing struct {
+		Exporter    string  `config:"exporter" env:"OTEL_TRACES_EXPORTER" default:"none" usage:"none, otlp or stdout"`
+		ServiceName string  `config:"service_name" env:"OTEL_SERVICE_NAME" default:"auth-service" usage:"service name of spans"`
+		SampleRatio float64 `config:"sample_ratio" env:"OTEL_TRACES_SAMPLER_ARG" default:"1" usage:"share of new traces recorded, 0 to 1"`
+	}
+)
+
+// Validate checks the settings of the sections named by their key in configuration files,
+// e.g. "db", or of all sections when none are named, as far as this package understands
+// them. Settings parsed by the packages using them, such as keys or the log level, are left
+// to the caller, which checks them with those parsers through the returned Validator.
+func (cfg *Config) Validate(sections ...string) *Validator {
+	v := &Validator{settings: indexSettings(settingsOf(cfg)), sections: sections}
+
+	v.Ensure("server.port", cfg.Server.Port > 0 && cfg.Server.Port <= 65535, "must be a port number")
+	v.Ensure("server.tls_key_file", (cfg.Server.TLSCertFile == "") == (cfg.Server.TLSKeyFile == ""), "must be set together with server.tls_cert_file")
+	v.Ensure("server.h2c", !cfg.Server.H2C || cfg.Server.TLSCertFile == "", "cannot be used with TLS, which negotiates HTTP/2 by itself")
+	v.Ensure("server.read_header_timeout_in_seconds", cfg.Server.ReadHeaderTimeoutInSecs > 0, "must be positive")
+	v.Ensure("server.idle_timeout_in_seconds", cfg.Server.IdleTimeoutInSecs > 0, "must be positive")
+	v.Ensure("server.max_body_bytes", cfg.Server.MaxBodyBytes > 0, "must be positive")
+	v.Ensure("server.shutdown_timeout_in_seconds", cfg.Server.ShutdownTimeoutInSecs > 0, "must be positive")
+	v.Ensure("server.drain_delay_in_seconds", cfg.Server.DrainDelayInSecs >= 0 && cfg.Server.DrainDelayInSecs < cfg.Server.ShutdownTimeoutInSecs, "must not be negative and must be below server.shutdown_timeout_in_seconds")
+	v.Ensure("auth.token_expiry_in_minutes", cfg.Auth.TokenExpiryInMinutes > 0, "must be positive")
+	v.Ensure("auth.otp_ttl_in_minutes", cfg.Auth.OtpTTLInMinutes >= 0, "must not be negative")
+
+	switch cfg.DB.Driver {
+	case DBDriverPostgres:
+		v.Required("db.host", cfg.DB.Host)
+		v.Required("db.name", cfg.DB.Name)
+		v.Required("db.username", cfg.DB.Username)
+		v.Required("db.password", cfg.DB.Password)
+		v.Ensure("db.port", cfg.DB.Port > 0 && cfg.DB.Port <= 65535, "must be a port number")
+	case DBDriverSQLite:
+		v.Required("db.path", cfg.DB.Path)
+		v.Ensure("db.replica_dsns", len(cfg.DB.ReplicaDSNs) == 0, "read replicas need db.driver "+DBDriverPostgres)
+	default:
+		v.Invalid("db.driver", fmt.Sprintf("must be %s or %s", DBDriverPostgres, DBDriverSQLite))
+	}
+	v.Ensure("db.query_timeout_in_seconds", cfg.DB.QueryTimeoutInSecs > 0, "must be positive")
+	v.Ensure("db.replica_max_lag_in_seconds", cfg.DB.ReplicaMaxLagInSecs > 0, "must be positive")
+	v.Ensure("db.replica_sticky_in_seconds", cfg.DB.ReplicaStickyInSecs >= 0, "must not be negative")
+
+	switch cfg.MQ.Driver {
+	case MQDriverAMQP:
+		v.Required("mq.amqp_address", cfg.MQ.AMQPAddress)
+	case MQDriverMemory:
+	default:
+		v.Invalid("mq.driver", fmt.Sprintf("must be %s or %s", MQDriverAMQP, MQDriverMemory))
+	}
+	v.Ensure("mq.prefetch", cfg.MQ.Prefetch > 0, "must be positive")
+	v.Ensure("mq.concurrency", cfg.MQ.Concurrency > 0, "must be positive")
+	v.Ensure("mq.max_retries", cfg.MQ.MaxRetries >= 0, "must not be negative")
+	v.Ensure("mq.retry_delay_in_seconds", cfg.MQ.RetryDelayInSecs > 0, "must be positive")
+
+	if cfg.Audit.HashChain {
+		v.Required("audit.hash_key", cfg.Audit.HashKey)
+	}
+
+	v.Ensure("retention.purge_interval_in_minutes", cfg.Retention.PurgeIntervalInMinutes > 0, "must be positive")
+	v.Ensure("retention.purge_batch_size", cfg.Retention.PurgeBatchSize > 0, "must be positive")
+
+	v.Ensure("data_export.ttl_in_hours", cfg.DataExport.TTLInHours > 0, "must be positive")
+	v.Ensure("data_export.poll_interval_in_seconds", cfg.DataExport.PollIntervalInSeconds > 0, "must be positive")
+
+	v.Ensure("tracing.sample_ratio", cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "must be between 0 and 1")
+	return v
+}
+
+// source tells where the setting with key got its value from: default, file, env or flag.
+func (cfg *Config) source(key string) string {
+	if source, ok := cfg.sources[key]; ok {
+		return source
+	}
+	return sourceDefault
+}
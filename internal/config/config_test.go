@@ -0,0 +1,291 @@
+package config_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ilivestrong/auth-service/internal/config"
+)
+
+// baseEnv is the least environment that loads without a database or broker.
+var baseEnv = map[string]string{
+	"DB_DRIVER": config.DBDriverSQLite,
+	"MQ_DRIVER": config.MQDriverMemory,
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want int
+	}{
+		{"default", "", nil, nil, 8080},
+		{"file", "server:\n  port: 8081\n", nil, nil, 8081},
+		{"env over file", "server:\n  port: 8081\n", map[string]string{"PORT": "8082"}, nil, 8082},
+		{"flag over env", "server:\n  port: 8081\n", map[string]string{"PORT": "8082"}, []string{"-server.port", "8083"}, 8083},
+		{"flag over default", "", nil, []string{"-server.port", "8083"}, 8083},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := withEnv(test.env)
+			if test.file != "" {
+				env["CONFIG_FILE"] = writeFile(t, "config.yaml", test.file)
+			}
+
+			cfg, _, err := load(test.args, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != test.want {
+				t.Fatalf("got port %d, want %d", cfg.Server.Port, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"yaml", "config.yaml", "server:\n  port: 8081\ndb:\n  replica_dsns:\n    - postgres://a\n    - postgres://b\n"},
+		{"yml", "config.yml", "server:\n  port: 8081\ndb:\n  replica_dsns: [postgres://a, postgres://b]\n"},
+		{"toml", "config.toml", "[server]\nport = 8081\n[db]\nreplica_dsns = [\"postgres://a\", \"postgres://b\"]\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeFile(t, test.file, test.content)
+			cfg, _, err := load([]string{"-config", path}, withEnv(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != 8081 || strings.Join(cfg.DB.ReplicaDSNs, " ") != "postgres://a postgres://b" {
+				t.Fatalf("got port %d and replicas %v, want the values of the file", cfg.Server.Port, cfg.DB.ReplicaDSNs)
+			}
+		})
+	}
+}
+
+func TestLoadReturnsArgs(t *testing.T) {
+	_, args, err := load([]string{"-server.port", "8081", "migrate", "up"}, withEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(args, " ") != "migrate up" {
+		t.Fatalf("got %v, want the arguments after the flags", args)
+	}
+}
+
+func TestLoadSecretsFromFiles(t *testing.T) {
+	secret := writeFile(t, "secret", "s3cret\n")
+
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+	}{
+		{"env", "", map[string]string{"ADMIN_TOKEN_FILE": secret}},
+		{"config file", "auth:\n  admin_token_file: " + secret + "\n", nil},
+		{"env over config file", "auth:\n  admin_token: other\n", map[string]string{"ADMIN_TOKEN_FILE": secret}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := withEnv(test.env)
+			if test.file != "" {
+				env["CONFIG_FILE"] = writeFile(t, "config.yaml", test.file)
+			}
+
+			cfg, _, err := load(nil, env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Auth.AdminToken != "s3cret" {
+				t.Fatalf("got %q, want the trimmed content of the file", cfg.Auth.AdminToken)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	secret := writeFile(t, "secret", "s3cret")
+
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		err  error
+		want string
+	}{
+		{"secret in env twice", "", map[string]string{"ADMIN_TOKEN": "a", "ADMIN_TOKEN_FILE": secret}, config.ErrAmbiguousSecret, "ADMIN_TOKEN and ADMIN_TOKEN_FILE"},
+		{"secret in config file twice", "auth:\n  admin_token: a\n  admin_token_file: " + secret + "\n", nil, config.ErrAmbiguousSecret, "auth.admin_token in "},
+		{"missing secret file", "", map[string]string{"ADMIN_TOKEN_FILE": filepath.Join(t.TempDir(), "missing")}, os.ErrNotExist, "ADMIN_TOKEN_FILE"},
+		{"unknown key", "server:\n  prot: 8081\n", nil, config.ErrUnknownKey, "server.prot in "},
+		{"unknown section", "sever:\n  port: 8081\n", nil, config.ErrUnknownKey, "sever.port in "},
+		{"value in place of a section", "server: 8081\n", nil, config.ErrUnknownKey, "server in "},
+		{"file of a setting that is not secret", "server:\n  port_file: " + secret + "\n", nil, config.ErrUnknownKey, "server.port_file in "},
+		{"unsupported config file", "", map[string]string{"CONFIG_FILE": writeFile(t, "config.json", "{}")}, config.ErrUnsupportedFile, "config.json"},
+		{"not a number in env", "", map[string]string{"PORT": "http"}, nil, `PORT: "http" is not a whole number`},
+		{"not a number in config file", "server:\n  port: http\n", nil, nil, `server.port in config file: "http" is not a whole number`},
+		{"not a bool", "", map[string]string{"AUDIT_HASH_CHAIN": "maybe"}, nil, `AUDIT_HASH_CHAIN: "maybe" is not true or false`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			env := withEnv(test.env)
+			if test.file != "" {
+				env["CONFIG_FILE"] = writeFile(t, "config.yaml", test.file)
+			}
+
+			_, _, err := load(nil, env)
+			if err == nil {
+				t.Fatal("loaded, want an error")
+			}
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got %v, want it to mention %s", err, test.want)
+			}
+		})
+	}
+}
+
+func TestLoadLeavesValidationToValidate(t *testing.T) {
+	if _, _, err := load(nil, withEnv(map[string]string{"PORT": "0", "DB_DRIVER": "mysql"})); err != nil {
+		t.Fatalf("got %v, want invalid settings loaded, e.g. to be printed", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"invalid port", map[string]string{"PORT": "70000"}, "server.port (PORT): must be a port number"},
+		{"key without cert", map[string]string{"TLS_KEY_FILE": "key.pem"}, "server.tls_key_file (TLS_KEY_FILE): must be set together with server.tls_cert_file"},
+		{"drain delay past timeout", map[string]string{"SHUTDOWN_DRAIN_DELAY_IN_SECONDS": "30"}, "server.drain_delay_in_seconds (SHUTDOWN_DRAIN_DELAY_IN_SECONDS): must not be negative and must be below server.shutdown_timeout_in_seconds"},
+		{"postgres settings", map[string]string{"DB_DRIVER": config.DBDriverPostgres}, "db.host (DB_HOST): required"},
+		{"unknown driver", map[string]string{"MQ_DRIVER": "kafka"}, "mq.driver (MQ_DRIVER): must be amqp or memory"},
+		{"hash chain without key", map[string]string{"AUDIT_HASH_CHAIN": "true"}, "audit.hash_key (AUDIT_HASH_KEY): required"},
+		{"invalid sample ratio", map[string]string{"OTEL_TRACES_SAMPLER_ARG": "2"}, "tracing.sample_ratio (OTEL_TRACES_SAMPLER_ARG): must be between 0 and 1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, _, err := load(nil, withEnv(test.env))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = cfg.Validate().Err()
+			if !errors.Is(err, config.ErrInvalidConfig) {
+				t.Fatalf("got %v, want %v", err, config.ErrInvalidConfig)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("got %v, want it to mention %s", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryInvalidSetting(t *testing.T) {
+	cfg, _, err := load(nil, withEnv(map[string]string{"PORT": "0", "MQ_PREFETCH": "0", "DATA_EXPORT_TTL_IN_HOURS": "0"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cfg.Validate().Err()
+	for _, want := range []string{"server.port (PORT)", "mq.prefetch (MQ_PREFETCH)", "data_export.ttl_in_hours (DATA_EXPORT_TTL_IN_HOURS)"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestValidateSections(t *testing.T) {
+	cfg, _, err := load(nil, withEnv(map[string]string{"PORT": "0", "MQ_DRIVER": "kafka", "DB_DRIVER": config.DBDriverPostgres}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := cfg.Validate("db")
+	v.Check("log.level", errors.New("not a level"))
+	err = v.Err()
+	if err == nil || !strings.Contains(err.Error(), "db.host (DB_HOST)") {
+		t.Fatalf("got %v, want it to mention db.host", err)
+	}
+	for _, unchecked := range []string{"server.port", "mq.driver", "log.level"} {
+		if strings.Contains(err.Error(), unchecked) {
+			t.Errorf("got %v, want %s of another section left out", err, unchecked)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	env := withEnv(map[string]string{"ADMIN_TOKEN": "s3cret", "CONFIG_FILE": writeFile(t, "config.yaml", "server:\n  port: 8081\n")})
+	cfg, _, err := load([]string{"-log.level", "debug"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	printed := out.String()
+	if strings.Contains(printed, "s3cret") {
+		t.Fatalf("printed the admin token:\n%s", printed)
+	}
+
+	for _, want := range []string{
+		"admin_token: '********' # env",
+		"password: \"\" # default",
+		"port: 8081 # file",
+		"level: debug # flag",
+		"driver: sqlite # env",
+		"shutdown_timeout_in_seconds: 25 # default",
+	} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("printed config lacks %q:\n%s", want, printed)
+		}
+	}
+}
+
+func load(args []string, env map[string]string) (*config.Config, []string, error) {
+	return config.Load(config.Options{
+		Args: args,
+		LookupEnv: func(key string) (string, bool) {
+			value, ok := env[key]
+			return value, ok
+		},
+	})
+}
+
+// withEnv returns baseEnv with env added.
+func withEnv(env map[string]string) map[string]string {
+	merged := make(map[string]string, len(baseEnv)+len(env))
+	for key, value := range baseEnv {
+		merged[key] = value
+	}
+	for key, value := range env {
+		merged[key] = value
+	}
+	return merged
+}
+
+// writeFile writes content to a file named name in a new temporary directory and returns its path.
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,368 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+
+	configFileEnv = "CONFIG_FILE"
+	secretFileKey = "_file"
+	secretFileEnv = "_FILE"
+)
+
+var (
+	ErrUnknownKey      = errors.New("unknown key")
+	ErrUnsupportedFile = errors.New("unsupported config file, must be .yaml, .yml or .toml")
+	ErrAmbiguousSecret = errors.New("set either the value or the file to read it from")
+)
+
+type (
+	Options struct {
+		// Args are the command line arguments without the program name.
+		Args      []string
+		LookupEnv func(key string) (string, bool)
+		// Usage is printed by -h before the flags.
+		Usage string
+	}
+
+	setting struct {
+		key    string
+		env    string
+		flag   string
+		usage  string
+		def    string
+		secret bool
+		value  reflect.Value
+	}
+
+	// Validator collects every missing or invalid setting of the sections it checks, so they
+	// are reported together. Settings of other sections are ignored.
+	Validator struct {
+		settings map[string]*setting
+		sections []string
+		errs     []error
+	}
+)
+
+// Load builds the configuration from defaults, the YAML or TOML file named by -config or
+// CONFIG_FILE, environment variables and flags, each overriding the ones before. It fails on
+// unknown keys and values of the wrong type, whether the values make sense is left to
+// Validate. The arguments following the flags are returned. -h and invalid flags exit the
+// process.
+func Load(options Options) (*Config, []string, error) {
+	cfg := &Config{sources: make(map[string]string)}
+	settings := settingsOf(cfg)
+	index := indexSettings(settings)
+
+	for _, s := range settings {
+		if s.def != "" {
+			if err := s.set(s.def); err != nil {
+				panic(fmt.Sprintf("config: invalid default of %s, %v", s.key, err))
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), options.Usage)
+		fmt.Fprintf(fs.Output(), "\nflags, each overriding the environment variable named in brackets:\n")
+		fs.PrintDefaults()
+	}
+	configFile := fs.String("config", "", "YAML or TOML configuration file (CONFIG_FILE)")
+
+	var flagValues []func() error
+	for _, s := range settings {
+		s := s
+		stash := func(value string) error {
+			if _, err := s.parse(value); err != nil {
+				return err
+			}
+			flagValues = append(flagValues, func() error { return cfg.set(s, value, sourceFlag) })
+			return nil
+		}
+
+		usage := fmt.Sprintf("%s (%s)", s.usage, s.env)
+		if s.value.Kind() == reflect.Bool {
+			fs.BoolFunc(s.flag, usage, stash)
+		} else {
+			fs.Func(s.flag, usage, stash)
+		}
+	}
+	fs.Parse(options.Args)
+
+	v := Validator{settings: index}
+	if *configFile == "" {
+		*configFile, _ = options.LookupEnv(configFileEnv)
+	}
+	if *configFile != "" {
+		v.errs = append(v.errs, cfg.loadFile(*configFile, index)...)
+	}
+	v.errs = append(v.errs, cfg.loadEnv(options.LookupEnv, settings)...)
+	for _, apply := range flagValues {
+		if err := apply(); err != nil {
+			v.errs = append(v.errs, err)
+		}
+	}
+
+	if err := v.Err(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+func (cfg *Config) loadFile(path string, settings map[string]*setting) []error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("failed to read config file, %w", err)}
+	}
+
+	var sections map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &sections)
+	case ".toml":
+		err = toml.Unmarshal(b, &sections)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedFile, path)
+	}
+	if err != nil {
+		return []error{fmt.Errorf("failed to parse config file, %w", err)}
+	}
+
+	var errs []error
+	for _, section := range sortedKeys(sections) {
+		keys, ok := sections[section].(map[string]any)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s in %s: %w", section, path, ErrUnknownKey))
+			continue
+		}
+
+		for _, name := range sortedKeys(keys) {
+			key, value := section+"."+name, keys[name]
+			if s, ok := settings[strings.TrimSuffix(key, secretFileKey)]; ok && s.secret && key != s.key {
+				if _, set := keys[strings.TrimSuffix(name, secretFileKey)]; set {
+					errs = append(errs, fmt.Errorf("%s in %s: %w", s.key, path, ErrAmbiguousSecret))
+					continue
+				}
+				if secret, err := readSecret(fmt.Sprint(value)); err != nil {
+					errs = append(errs, fmt.Errorf("%s in %s: %w", key, path, err))
+				} else if err := cfg.set(s, secret, sourceFile); err != nil {
+					errs = append(errs, err)
+				}
+				continue
+			}
+
+			s, ok := settings[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s in %s: %w", key, path, ErrUnknownKey))
+				continue
+			}
+			if err := cfg.set(s, fileValue(value), sourceFile); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func (cfg *Config) loadEnv(lookupEnv func(key string) (string, bool), settings []*setting) []error {
+	var errs []error
+	for _, s := range settings {
+		value, ok := lookupEnv(s.env)
+		if path, fromFile := lookupEnv(s.env + secretFileEnv); s.secret && fromFile {
+			if ok {
+				errs = append(errs, fmt.Errorf("%s and %s: %w", s.env, s.env+secretFileEnv, ErrAmbiguousSecret))
+				continue
+			}
+
+			secret, err := readSecret(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env+secretFileEnv, err))
+				continue
+			}
+			value, ok = secret, true
+		}
+
+		if ok {
+			if err := cfg.set(s, value, sourceEnv); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func (cfg *Config) set(s *setting, value string, source string) error {
+	if err := s.set(value); err != nil {
+		switch source {
+		case sourceFlag:
+			return fmt.Errorf("flag -%s: %w", s.flag, err)
+		case sourceEnv:
+			return fmt.Errorf("%s: %w", s.env, err)
+		}
+		return fmt.Errorf("%s in config file: %w", s.key, err)
+	}
+	cfg.sources[s.key] = source
+	return nil
+}
+
+func (s *setting) set(value string) error {
+	parsed, err := s.parse(value)
+	if err != nil {
+		return err
+	}
+	s.value.Set(parsed)
+	return nil
+}
+
+func (s *setting) parse(value string) (reflect.Value, error) {
+	switch s.value.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(value), nil
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a whole number", value)
+		}
+		return reflect.ValueOf(n), nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not true or false", value)
+		}
+		return reflect.ValueOf(b), nil
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a number", value)
+		}
+		return reflect.ValueOf(f), nil
+	case reflect.Slice:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		return reflect.ValueOf(values), nil
+	}
+	panic(fmt.Sprintf("config: unsupported type of %s: %s", s.key, s.value.Type()))
+}
+
+// fileValue turns a value decoded from a file into its textual form, lists are comma separated.
+func fileValue(value any) string {
+	if list, ok := value.([]any); ok {
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = fmt.Sprint(v)
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// settingsOf finds the settings of cfg by the tags of its fields, in the order they are declared.
+func settingsOf(cfg *Config) []*setting {
+	var settings []*setting
+
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section, ok := sections.Type().Field(i).Tag.Lookup("config")
+		if !ok {
+			continue
+		}
+
+		fields := sections.Field(i)
+		for j := 0; j < fields.NumField(); j++ {
+			field := fields.Type().Field(j)
+			key := section + "." + field.Tag.Get("config")
+			settings = append(settings, &setting{
+				key:    key,
+				env:    field.Tag.Get("env"),
+				flag:   strings.ReplaceAll(key, "_", "-"),
+				usage:  field.Tag.Get("usage"),
+				def:    field.Tag.Get("default"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  fields.Field(j),
+			})
+		}
+	}
+	return settings
+}
+
+func indexSettings(settings []*setting) map[string]*setting {
+	index := make(map[string]*setting, len(settings))
+	for _, s := range settings {
+		index[s.key] = s
+	}
+	return index
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *Validator) Required(key string, value string) {
+	if value == "" {
+		v.Invalid(key, "required")
+	}
+}
+
+func (v *Validator) Ensure(key string, ok bool, message string) {
+	if !ok {
+		v.Invalid(key, message)
+	}
+}
+
+func (v *Validator) Check(key string, err error) {
+	if err != nil {
+		v.Invalid(key, err.Error())
+	}
+}
+
+// Invalid reports the setting with key, unless it belongs to a section v does not check.
+func (v *Validator) Invalid(key string, message string) {
+	section, _, _ := strings.Cut(key, ".")
+	if len(v.sections) > 0 && !slices.Contains(v.sections, section) {
+		return
+	}
+	v.errs = append(v.errs, fmt.Errorf("%s (%s): %s", key, v.settings[key].env, message))
+}
+
+// Err returns every setting reported, wrapping ErrInvalidConfig, nil when there are none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w\n%w", ErrInvalidConfig, errors.Join(v.errs...))
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	mask = "********"
+)
+
+// Print writes the configuration as a YAML config file, each setting commented with where its
+// value comes from. Secrets are masked unless empty.
+func (cfg *Config) Print(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := make(map[string]*yaml.Node)
+
+	for _, s := range settingsOf(cfg) {
+		name, key, _ := strings.Cut(s.key, ".")
+		section, ok := sections[name]
+		if !ok {
+			section = &yaml.Node{Kind: yaml.MappingNode}
+			sections[name] = section
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, section)
+		}
+
+		var value any = s.value.Interface()
+		if s.secret && !s.value.IsZero() {
+			value = mask
+		}
+
+		var node yaml.Node
+		if err := node.Encode(value); err != nil {
+			return err
+		}
+		node.LineComment = cfg.source(s.key)
+		section.Content = append(section.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &node)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
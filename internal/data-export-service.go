@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	ErrDataExportNotDone = errors.New("data export is not done")
+
+	dataExportStatuses = map[string]authv1.DataExportStatus{
+		models.DataExportPending: authv1.DataExportStatus_DATA_EXPORT_STATUS_PENDING,
+		models.DataExportRunning: authv1.DataExportStatus_DATA_EXPORT_STATUS_RUNNING,
+		models.DataExportDone:    authv1.DataExportStatus_DATA_EXPORT_STATUS_DONE,
+		models.DataExportFailed:  authv1.DataExportStatus_DATA_EXPORT_STATUS_FAILED,
+	}
+)
+
+func (auth *authService) ExportMyData(
+	ctx context.Context,
+	req *connect.Request[authv1.ExportMyDataRequest],
+) (*connect.Response[authv1.ExportMyDataResponse], error) {
+	profile, err := auth.loggedInProfile(ctx, req.Header().Get(PhoneNumberHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	dataExport, err := auth.exporter.Request(ctx, profile, models.RequestedBySelf)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&authv1.ExportMyDataResponse{
+		Export: toDataExportProto(dataExport),
+	}), nil
+}
+
+func (auth *authService) GetMyDataExport(
+	ctx context.Context,
+	req *connect.Request[authv1.GetMyDataExportRequest],
+) (*connect.Response[authv1.GetMyDataExportResponse], error) {
+	profile, err := auth.loggedInProfile(ctx, req.Header().Get(PhoneNumberHeader))
+	if err != nil {
+		return nil, err
+	}
+
+	dataExport, err := auth.getDataExport(ctx, req.Msg.GetExportId())
+	if err != nil {
+		return nil, err
+	}
+	// exports of other profiles are reported as missing, not as forbidden
+	if dataExport.ProfileID != profile.ID {
+		return nil, connect.NewError(connect.CodeNotFound, persist.ErrDataExportNotFound)
+	}
+
+	return connect.NewResponse(&authv1.GetMyDataExportResponse{
+		Export: toDataExportProto(dataExport),
+	}), nil
+}
+
+func (auth *authService) DownloadMyDataExport(
+	ctx context.Context,
+	req *connect.Request[authv1.DownloadMyDataExportRequest],
+	stream *connect.ServerStream[authv1.DownloadMyDataExportResponse],
+) error {
+	profile, err := auth.loggedInProfile(ctx, req.Header().Get(PhoneNumberHeader))
+	if err != nil {
+		return err
+	}
+
+	dataExport, err := auth.getDataExport(ctx, req.Msg.GetExportId())
+	if err != nil {
+		return err
+	}
+	if dataExport.ProfileID != profile.ID {
+		return connect.NewError(connect.CodeNotFound, persist.ErrDataExportNotFound)
+	}
+
+	return auth.streamArchive(ctx, dataExport, func(data []byte) error {
+		return stream.Send(&authv1.DownloadMyDataExportResponse{Data: data})
+	})
+}
+
+func (auth *authService) ExportUserData(
+	ctx context.Context,
+	req *connect.Request[authv1.ExportUserDataRequest],
+) (*connect.Response[authv1.ExportUserDataResponse], error) {
+	profile, err := auth.profileRepo.Get(ctx, req.Msg.GetPhoneNumber())
+	if err != nil || profile == nil {
+		return nil, connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
+	}
+	annotateAuditMetadata(ctx, "target_profile_id", profile.ID)
+
+	dataExport, err := auth.exporter.Request(ctx, profile, models.RequestedByAdmin)
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	return connect.NewResponse(&authv1.ExportUserDataResponse{
+		Export: toDataExportProto(dataExport),
+	}), nil
+}
+
+func (auth *authService) GetDataExport(
+	ctx context.Context,
+	req *connect.Request[authv1.GetDataExportRequest],
+) (*connect.Response[authv1.GetDataExportResponse], error) {
+	dataExport, err := auth.getDataExport(ctx, req.Msg.GetExportId())
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&authv1.GetDataExportResponse{
+		Export: toDataExportProto(dataExport),
+	}), nil
+}
+
+func (auth *authService) DownloadDataExport(
+	ctx context.Context,
+	req *connect.Request[authv1.DownloadDataExportRequest],
+	stream *connect.ServerStream[authv1.DownloadDataExportResponse],
+) error {
+	dataExport, err := auth.getDataExport(ctx, req.Msg.GetExportId())
+	if err != nil {
+		return err
+	}
+
+	return auth.streamArchive(ctx, dataExport, func(data []byte) error {
+		return stream.Send(&authv1.DownloadDataExportResponse{Data: data})
+	})
+}
+
+func (auth *authService) loggedInProfile(ctx context.Context, phoneNumber string) (*models.Profile, error) {
+	if !auth.cache.Get(phoneNumber) {
+		return nil, connect.NewError(connect.CodeUnauthenticated, ErrInvalidSession)
+	}
+
+	profile, err := auth.profileRepo.Get(ctx, phoneNumber)
+	if err != nil || profile == nil {
+		return nil, connect.NewError(connect.CodeNotFound, ErrProfileNotFound)
+	}
+	annotateAuditProfile(ctx, profile.ID, profile.PhoneNumber)
+	return profile, nil
+}
+
+func (auth *authService) getDataExport(ctx context.Context, id string) (*models.DataExport, error) {
+	dataExport, err := auth.exporter.Get(ctx, id)
+	if errors.Is(err, persist.ErrDataExportNotFound) {
+		return nil, connect.NewError(connect.CodeNotFound, err)
+	}
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+	return dataExport, nil
+}
+
+// streamArchive sends the archive of dataExport with send as it was stored, in chunks.
+func (auth *authService) streamArchive(ctx context.Context, dataExport *models.DataExport, send func(data []byte) error) error {
+	if dataExport.Status != models.DataExportDone {
+		return connect.NewError(connect.CodeFailedPrecondition, ErrDataExportNotDone)
+	}
+	if err := auth.exporter.ReadArchive(ctx, dataExport.ID, send); err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	return nil
+}
+
+func toDataExportProto(dataExport *models.DataExport) *authv1.DataExport {
+	return &authv1.DataExport{
+		Id:          dataExport.ID,
+		ProfileId:   dataExport.ProfileID,
+		Status:      dataExportStatuses[dataExport.Status],
+		Error:       dataExport.Error,
+		CreatedAt:   timestamppb.New(dataExport.CreatedAt),
+		CompletedAt: optionalTimestamp(dataExport.CompletedAt),
+		ExpiresAt:   optionalTimestamp(dataExport.ExpiresAt),
+		ArchiveSize: dataExport.ArchiveSize,
+	}
+}
+
+func optionalTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
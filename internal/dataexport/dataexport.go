@@ -0,0 +1,332 @@
+package dataexport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/export"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+)
+
+const (
+	// archiveVersion changes whenever fields of Archive are renamed or removed.
+	archiveVersion = 1
+	// archiveChunkSize is the size of the chunks archives are stored and downloaded in.
+	archiveChunkSize = 64 * 1024
+
+	defaultTTL          = 24 * time.Hour
+	defaultPollInterval = time.Minute
+	defaultStaleAfter   = 15 * time.Minute
+)
+
+type (
+	Options struct {
+		// TTL is how long finished exports are kept for download.
+		TTL time.Duration
+		// PollInterval is how often exports requested on other instances, or abandoned by
+		// them, are looked for.
+		PollInterval time.Duration
+		// StaleAfter is how long an export may be running before it is taken for abandoned
+		// and started over.
+		StaleAfter time.Duration
+	}
+
+	// Exporter assembles the archives of requested data exports in the background. Exports
+	// are claimed from the database, so any instance running an Exporter can process them.
+	Exporter struct {
+		exports  persist.DataExportRepo
+		profiles persist.ProfileRepo
+		events   persist.EventRepo
+		options  Options
+		wake     chan struct{}
+	}
+
+	// Archive is everything held about a profile. Consent records are not kept by the
+	// service, so there are none to include. It is written field by field, in this order,
+	// so the events never have to be held in memory at once.
+	Archive struct {
+		Version     int              `json:"version"`
+		GeneratedAt string           `json:"generated_at"`
+		Profile     Profile          `json:"profile"`
+		Events      []*export.Record `json:"events"`
+		Sessions    []*Session       `json:"sessions"`
+	}
+
+	// Profile leaves out the current OTP, a credential rather than data about the user.
+	Profile struct {
+		ID           string `json:"id"`
+		Name         string `json:"name"`
+		PhoneNumber  string `json:"phone_number"`
+		IsVerified   bool   `json:"is_verified"`
+		CreatedAt    string `json:"created_at"`
+		UpdatedAt    string `json:"updated_at"`
+		OtpExpiresAt string `json:"otp_expires_at,omitempty"`
+	}
+
+	// Session sums up the events recorded under one session id.
+	Session struct {
+		SessionID   string   `json:"session_id"`
+		FirstSeenAt string   `json:"first_seen_at"`
+		LastSeenAt  string   `json:"last_seen_at"`
+		ClientIPs   []string `json:"client_ips"`
+		UserAgents  []string `json:"user_agents"`
+	}
+
+	// chunkWriter stores every write as the next chunk of the archive of an export.
+	chunkWriter struct {
+		ctx      context.Context
+		exports  persist.DataExportRepo
+		id       string
+		sequence int
+		size     int64
+	}
+
+	// jsonWriter writes JSON to w and keeps the first error, to be checked once at the end.
+	jsonWriter struct {
+		w   io.Writer
+		err error
+	}
+)
+
+// Request starts an export of profile, or returns the one already pending or running.
+func (ex *Exporter) Request(ctx context.Context, profile *models.Profile, requestedBy string) (*models.DataExport, error) {
+	active, err := ex.exports.FindActive(ctx, profile.ID)
+	if err == nil {
+		return active, nil
+	}
+	if !errors.Is(err, persist.ErrDataExportNotFound) {
+		return nil, err
+	}
+
+	dataExport := &models.DataExport{
+		ProfileID:   profile.ID,
+		PhoneNumber: profile.PhoneNumber,
+		RequestedBy: requestedBy,
+	}
+	if _, err := ex.exports.Create(ctx, dataExport); err != nil {
+		return nil, err
+	}
+
+	select {
+	case ex.wake <- struct{}{}:
+	default:
+	}
+	return dataExport, nil
+}
+
+func (ex *Exporter) Get(ctx context.Context, id string) (*models.DataExport, error) {
+	return ex.exports.Get(ctx, id)
+}
+
+// ReadArchive calls fn with the archive of the done export id chunk by chunk.
+func (ex *Exporter) ReadArchive(ctx context.Context, id string, fn func(data []byte) error) error {
+	return ex.exports.ReadChunks(ctx, id, fn)
+}
+
+// RunOnce deletes expired exports and processes pending ones until none is left.
+func (ex *Exporter) RunOnce(ctx context.Context) error {
+	purged, err := ex.exports.PurgeExpired(ctx)
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		slog.Info("deleted expired data exports", "count", purged)
+	}
+
+	for ctx.Err() == nil {
+		dataExport, err := ex.exports.Claim(ctx, ex.options.StaleAfter)
+		if err != nil {
+			return err
+		}
+		if dataExport == nil {
+			return nil
+		}
+		ex.process(ctx, dataExport)
+	}
+	return ctx.Err()
+}
+
+// Run processes exports as they are requested, and every PollInterval, until ctx is done.
+func (ex *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(ex.options.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := ex.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("data export run failed", "error", err)
+		}
+
+		select {
+		case <-ex.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// process builds and stores the archive of dataExport. An export interrupted by shutdown
+// stays running and is picked up again once it is stale.
+func (ex *Exporter) process(ctx context.Context, dataExport *models.DataExport) {
+	chunks := &chunkWriter{ctx: ctx, exports: ex.exports, id: dataExport.ID}
+	out := bufio.NewWriterSize(chunks, archiveChunkSize)
+	err := ex.build(ctx, dataExport, out)
+	if err == nil {
+		err = out.Flush()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(ex.options.TTL)
+	if err != nil {
+		slog.Warn("data export failed", "export_id", dataExport.ID, "error", err)
+		err = ex.exports.Fail(ctx, dataExport.ID, err.Error(), expiresAt)
+	} else {
+		err = ex.exports.Complete(ctx, dataExport.ID, chunks.size, expiresAt)
+	}
+	if err != nil {
+		slog.Error("failed to store outcome of data export", "export_id", dataExport.ID, "error", err)
+	}
+}
+
+// build writes the archive of dataExport to w, see Archive. Only the session summaries
+// are held in memory, the events are written as they are read.
+func (ex *Exporter) build(ctx context.Context, dataExport *models.DataExport, w io.Writer) error {
+	profile, err := ex.profiles.Get(ctx, dataExport.PhoneNumber)
+	if err != nil {
+		return err
+	}
+
+	out := &jsonWriter{w: w}
+	out.raw(`{"version":`)
+	out.value(archiveVersion)
+	out.raw(`,"generated_at":`)
+	out.value(formatTime(time.Now()))
+	out.raw(`,"profile":`)
+	out.value(toProfile(profile))
+	out.raw(`,"events":[`)
+
+	sessions := make(map[string]*Session)
+	first := true
+	err = ex.events.ForEach(ctx, persist.EventFilter{PhoneNumber: profile.PhoneNumber}, func(event *models.Event) error {
+		if !first {
+			out.raw(",")
+		}
+		first = false
+		out.value(export.NewRecord(event, export.Options{}))
+		if event.SessionID != "" {
+			addToSession(sessions, event)
+		}
+		if out.err != nil {
+			return out.err
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	summaries := make([]*Session, 0, len(sessions))
+	for _, session := range sessions {
+		summaries = append(summaries, session)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].FirstSeenAt < summaries[j].FirstSeenAt })
+
+	out.raw(`],"sessions":`)
+	out.value(summaries)
+	out.raw("}")
+	return out.err
+}
+
+// addToSession adds event to the summary of its session, events arrive oldest first.
+func addToSession(sessions map[string]*Session, event *models.Event) {
+	session, exists := sessions[event.SessionID]
+	if !exists {
+		session = &Session{SessionID: event.SessionID, FirstSeenAt: formatTime(event.CreatedAt), ClientIPs: []string{}, UserAgents: []string{}}
+		sessions[event.SessionID] = session
+	}
+
+	session.LastSeenAt = formatTime(event.CreatedAt)
+	if event.ClientIP != "" && !contains(session.ClientIPs, event.ClientIP) {
+		session.ClientIPs = append(session.ClientIPs, event.ClientIP)
+	}
+	if event.UserAgent != "" && !contains(session.UserAgents, event.UserAgent) {
+		session.UserAgents = append(session.UserAgents, event.UserAgent)
+	}
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if err := cw.exports.AppendChunk(cw.ctx, cw.id, cw.sequence+1, p); err != nil {
+		return 0, err
+	}
+	cw.sequence++
+	cw.size += int64(len(p))
+	return len(p), nil
+}
+
+func (jw *jsonWriter) raw(s string) {
+	if jw.err == nil {
+		_, jw.err = io.WriteString(jw.w, s)
+	}
+}
+
+func (jw *jsonWriter) value(v any) {
+	if jw.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		jw.err = err
+		return
+	}
+	_, jw.err = jw.w.Write(data)
+}
+
+func toProfile(profile *models.Profile) Profile {
+	p := Profile{
+		ID:          profile.ID,
+		Name:        profile.Name,
+		PhoneNumber: profile.PhoneNumber,
+		IsVerified:  profile.IsVerified,
+		CreatedAt:   formatTime(profile.CreatedAt),
+		UpdatedAt:   formatTime(profile.UpdatedAt),
+	}
+	if profile.OtpExpiresAt != nil {
+		p.OtpExpiresAt = formatTime(*profile.OtpExpiresAt)
+	}
+	return p
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func NewExporter(exports persist.DataExportRepo, profiles persist.ProfileRepo, events persist.EventRepo, options Options) *Exporter {
+	if options.TTL <= 0 {
+		options.TTL = defaultTTL
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultPollInterval
+	}
+	if options.StaleAfter <= 0 {
+		options.StaleAfter = defaultStaleAfter
+	}
+	return &Exporter{exports, profiles, events, options, make(chan struct{}, 1)}
+}
@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/ilivestrong/auth-service/internal/export"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultEventsPageSize = 50
+	maxEventsPageSize     = 500
+	exportChunkSize       = 64 * 1024
+)
+
+type (
+	// exportStreamWriter sends everything written to it as ExportEventsResponse chunks.
+	exportStreamWriter struct {
+		stream *connect.ServerStream[authv1.ExportEventsResponse]
+	}
+)
+
+var (
+	ErrInvalidPageToken = errors.New("invalid page token")
+	ErrInvalidTimeRange = errors.New("start_time must be before end_time")
+)
+
+func (auth *authService) ListMyEvents(
+	ctx context.Context,
+	req *connect.Request[authv1.ListMyEventsRequest],
+) (*connect.Response[authv1.ListMyEventsResponse], error) {
+	loggedInUserPhoneNumber := req.Header().Get(PhoneNumberHeader)
+
+	if !auth.cache.Get(loggedInUserPhoneNumber) {
+		return nil, connect.NewError(connect.CodeUnauthenticated, ErrInvalidSession)
+	}
+
+	events, nextPageToken, err := auth.listEvents(
+		ctx,
+		persist.EventFilter{PhoneNumber: loggedInUserPhoneNumber},
+		req.Msg.GetEventTypes(), req.Msg.GetStartTime(), req.Msg.GetEndTime(),
+		req.Msg.GetPageSize(), req.Msg.GetPageToken(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&authv1.ListMyEventsResponse{
+		Events:        events,
+		NextPageToken: nextPageToken,
+	}), nil
+}
+
+func (auth *authService) ListEvents(
+	ctx context.Context,
+	req *connect.Request[authv1.ListEventsRequest],
+) (*connect.Response[authv1.ListEventsResponse], error) {
+	events, nextPageToken, err := auth.listEvents(
+		ctx,
+		persist.EventFilter{PhoneNumber: req.Msg.GetPhoneNumber(), ProfileID: req.Msg.GetProfileId()},
+		req.Msg.GetEventTypes(), req.Msg.GetStartTime(), req.Msg.GetEndTime(),
+		req.Msg.GetPageSize(), req.Msg.GetPageToken(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return connect.NewResponse(&authv1.ListEventsResponse{
+		Events:        events,
+		NextPageToken: nextPageToken,
+	}), nil
+}
+
+func (auth *authService) VerifyAuditLog(
+	ctx context.Context,
+	req *connect.Request[authv1.VerifyAuditLogRequest],
+) (*connect.Response[authv1.VerifyAuditLogResponse], error) {
+	report, err := auth.eventRepo.VerifyChain(ctx, auth.eventRepo.PhoneNumberStream(req.Msg.GetPhoneNumber()))
+	if err != nil {
+		return nil, connect.NewError(connect.CodeInternal, err)
+	}
+
+	res := &authv1.VerifyAuditLogResponse{
+		Intact:         report.BrokenLink == nil,
+		StreamsChecked: report.StreamsChecked,
+		EventsChecked:  report.EventsChecked,
+	}
+	if link := report.BrokenLink; link != nil {
+		res.FirstBrokenLink = &authv1.BrokenLink{
+			Stream:   link.Stream,
+			EventId:  link.EventID,
+			Sequence: link.Sequence,
+			Reason:   link.Reason,
+		}
+	}
+	return connect.NewResponse(res), nil
+}
+
+func (auth *authService) listEvents(
+	ctx context.Context,
+	filter persist.EventFilter,
+	eventTypes []string,
+	start, end *timestamppb.Timestamp,
+	pageSize int32,
+	pageToken string,
+) ([]*authv1.Event, string, error) {
+	filter.EventTypes = eventTypes
+	if err := setTimeRange(&filter, start, end); err != nil {
+		return nil, "", err
+	}
+
+	after, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, "", connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	limit := int(pageSize)
+	if limit <= 0 {
+		limit = defaultEventsPageSize
+	}
+	limit = min(limit, maxEventsPageSize)
+
+	// fetch one extra row to learn whether another page exists
+	rows, err := auth.eventRepo.List(ctx, filter, after, limit+1)
+	if err != nil {
+		return nil, "", connect.NewError(connect.CodeInternal, err)
+	}
+
+	var nextPageToken string
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[limit-1]
+		nextPageToken = encodePageToken(&persist.EventCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+
+	events := make([]*authv1.Event, 0, len(rows))
+	for _, row := range rows {
+		events = append(events, toEventProto(row))
+	}
+	return events, nextPageToken, nil
+}
+
+func (auth *authService) ExportEvents(
+	ctx context.Context,
+	req *connect.Request[authv1.ExportEventsRequest],
+	stream *connect.ServerStream[authv1.ExportEventsResponse],
+) error {
+	filter := persist.EventFilter{EventTypes: req.Msg.GetEventTypes()}
+	if err := setTimeRange(&filter, req.Msg.GetStartTime(), req.Msg.GetEndTime()); err != nil {
+		return err
+	}
+
+	format := export.FormatNDJSON
+	if req.Msg.GetFormat() == authv1.ExportFormat_EXPORT_FORMAT_CSV {
+		format = export.FormatCSV
+	}
+
+	out := bufio.NewWriterSize(exportStreamWriter{stream}, exportChunkSize)
+	w, err := export.NewEventWriter(out, export.Options{
+		Format:             format,
+		RedactPhoneNumbers: req.Msg.GetRedactPhoneNumbers(),
+	})
+	if err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	err = auth.eventRepo.ForEach(ctx, filter, func(event *models.Event) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return w.Write(event)
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		return connect.NewError(connect.CodeInternal, err)
+	}
+	return nil
+}
+
+func (sw exportStreamWriter) Write(p []byte) (int, error) {
+	if err := sw.stream.Send(&authv1.ExportEventsResponse{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func setTimeRange(filter *persist.EventFilter, start, end *timestamppb.Timestamp) error {
+	if start != nil {
+		filter.From = start.AsTime()
+	}
+	if end != nil {
+		filter.To = end.AsTime()
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return connect.NewError(connect.CodeInvalidArgument, ErrInvalidTimeRange)
+	}
+	return nil
+}
+
+func toEventProto(event models.Event) *authv1.Event {
+	return &authv1.Event{
+		Id:          event.ID,
+		PhoneNumber: event.PhoneNumber,
+		EventType:   event.EventType,
+		CreatedAt:   event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ProfileId:   event.ProfileID,
+		SessionId:   event.SessionID,
+		ClientIp:    event.ClientIP,
+		UserAgent:   event.UserAgent,
+		RequestId:   event.RequestID,
+		Outcome:     event.Outcome,
+		Reason:      event.Reason,
+		Metadata:    event.Metadata,
+	}
+}
+
+// Page tokens are opaque to clients: base64 of "<created_at unix nanos>:<event id>".
+func encodePageToken(cursor *persist.EventCursor) string {
+	raw := strconv.FormatInt(cursor.CreatedAt.UnixNano(), 10) + ":" + cursor.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageToken(token string) (*persist.EventCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok || id == "" {
+		return nil, ErrInvalidPageToken
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	return &persist.EventCursor{CreatedAt: time.Unix(0, unixNano).UTC(), ID: id}, nil
+}
@@ -0,0 +1,175 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+)
+
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+
+	redactKeepDigits = 4
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported export format, must be ndjson or csv")
+
+	csvHeader = []string{
+		"id", "created_at", "event_type", "profile_id", "phone_number", "session_id", "client_ip",
+		"user_agent", "request_id", "outcome", "reason", "metadata", "stream", "sequence", "prev_hash", "hash",
+	}
+)
+
+type (
+	// EventWriter serializes events one at a time, so exports never have to be held in memory.
+	EventWriter interface {
+		Write(event *models.Event) error
+		// Flush writes out anything buffered, call it once after the last event.
+		Flush() error
+	}
+
+	Options struct {
+		Format string
+		// RedactPhoneNumbers masks all but the last four digits of phone numbers and leaves
+		// out the hash chain fields.
+		RedactPhoneNumbers bool
+	}
+
+	// Record is the exported form of an event.
+	Record struct {
+		ID          string            `json:"id"`
+		CreatedAt   string            `json:"created_at"`
+		EventType   string            `json:"event_type"`
+		ProfileID   string            `json:"profile_id"`
+		PhoneNumber string            `json:"phone_number"`
+		SessionID   string            `json:"session_id"`
+		ClientIP    string            `json:"client_ip"`
+		UserAgent   string            `json:"user_agent"`
+		RequestID   string            `json:"request_id"`
+		Outcome     string            `json:"outcome"`
+		Reason      string            `json:"reason"`
+		Metadata    map[string]string `json:"metadata"`
+		Stream      string            `json:"stream,omitempty"`
+		Sequence    int64             `json:"sequence,omitempty"`
+		PrevHash    string            `json:"prev_hash,omitempty"`
+		Hash        string            `json:"hash,omitempty"`
+	}
+
+	ndjsonWriter struct {
+		w       *bufio.Writer
+		enc     *json.Encoder
+		options Options
+	}
+
+	csvWriter struct {
+		w           *csv.Writer
+		options     Options
+		wroteHeader bool
+	}
+)
+
+func (nw *ndjsonWriter) Write(event *models.Event) error {
+	return nw.enc.Encode(NewRecord(event, nw.options))
+}
+
+func (nw *ndjsonWriter) Flush() error {
+	return nw.w.Flush()
+}
+
+func (cw *csvWriter) Write(event *models.Event) error {
+	if !cw.wroteHeader {
+		if err := cw.w.Write(csvHeader); err != nil {
+			return err
+		}
+		cw.wroteHeader = true
+	}
+
+	r := NewRecord(event, cw.options)
+	metadata, err := json.Marshal(r.Metadata)
+	if err != nil {
+		return err
+	}
+
+	return cw.w.Write([]string{
+		r.ID, r.CreatedAt, r.EventType, r.ProfileID, r.PhoneNumber, r.SessionID, r.ClientIP,
+		r.UserAgent, r.RequestID, r.Outcome, r.Reason, string(metadata), r.Stream,
+		strconv.FormatInt(r.Sequence, 10), r.PrevHash, r.Hash,
+	})
+}
+
+func (cw *csvWriter) Flush() error {
+	if !cw.wroteHeader {
+		if err := cw.w.Write(csvHeader); err != nil {
+			return err
+		}
+		cw.wroteHeader = true
+	}
+
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// NewRecord converts event to its exported form, redacted as options say.
+func NewRecord(event *models.Event, options Options) *Record {
+	r := &Record{
+		ID:          event.ID,
+		CreatedAt:   event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		EventType:   event.EventType,
+		ProfileID:   event.ProfileID,
+		PhoneNumber: event.PhoneNumber,
+		SessionID:   event.SessionID,
+		ClientIP:    event.ClientIP,
+		UserAgent:   event.UserAgent,
+		RequestID:   event.RequestID,
+		Outcome:     event.Outcome,
+		Reason:      event.Reason,
+		Metadata:    event.Metadata,
+		Stream:      event.Stream,
+		Sequence:    event.Sequence,
+		PrevHash:    event.PrevHash,
+		Hash:        event.Hash,
+	}
+
+	if options.RedactPhoneNumbers {
+		r.PhoneNumber = RedactPhoneNumber(r.PhoneNumber)
+		// the hashes cover the full phone number, which could be guessed back from them
+		r.Stream, r.Sequence, r.PrevHash, r.Hash = "", 0, "", ""
+	}
+	return r
+}
+
+// RedactPhoneNumber replaces every digit but the last four with '*', keeping the leading '+'.
+func RedactPhoneNumber(phoneNumber string) string {
+	redacted := []byte(phoneNumber)
+	keep := redactKeepDigits
+	for i := len(redacted) - 1; i >= 0; i-- {
+		if redacted[i] < '0' || redacted[i] > '9' {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		redacted[i] = '*'
+	}
+	return string(redacted)
+}
+
+func NewEventWriter(w io.Writer, options Options) (EventWriter, error) {
+	switch options.Format {
+	case FormatNDJSON, "":
+		buffered := bufio.NewWriter(w)
+		return &ndjsonWriter{w: buffered, enc: json.NewEncoder(buffered), options: options}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w), options: options}, nil
+	}
+	return nil, ErrUnsupportedFormat
+}
@@ -0,0 +1,178 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"connectrpc.com/connect"
+	"connectrpc.com/grpchealth"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+
+	shutdownCheck  = "shutdown"
+	defaultTimeout = 2 * time.Second
+)
+
+var ErrUnknownService = errors.New("unknown service")
+
+type (
+	// Check reports why a dependency cannot be used, nil when it can.
+	Check func(ctx context.Context) error
+
+	Options struct {
+		// Checks are the dependencies the service needs to serve requests, by name.
+		Checks map[string]Check
+		// Services are the gRPC services reported healthy when all checks pass.
+		Services []string
+		// Timeout bounds every check, it defaults to 2 seconds.
+		Timeout time.Duration
+	}
+
+	Report struct {
+		Status string            `json:"status"`
+		Checks map[string]Result `json:"checks,omitempty"`
+	}
+
+	// Result is the outcome of one check. Why a check failed is only logged, as /readyz
+	// is served without authentication.
+	Result struct {
+		Status string `json:"status"`
+	}
+
+	// Checker runs the dependency checks for /readyz and the grpc.health.v1.Health service.
+	Checker struct {
+		checks   map[string]Check
+		names    []string
+		services []string
+		timeout  time.Duration
+		draining atomic.Bool
+	}
+)
+
+// Run checks the dependencies named, or all of them when there are none, concurrently.
+func (c *Checker) Run(ctx context.Context, names ...string) Report {
+	if len(names) == 0 {
+		names = c.names
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		report = Report{Status: StatusOK, Checks: make(map[string]Result, len(names))}
+	)
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			result := c.run(ctx, name)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	if c.draining.Load() {
+		report.Status = StatusUnavailable
+		report.Checks[shutdownCheck] = Result{Status: StatusUnavailable}
+	}
+	return report
+}
+
+// Drain makes the service report not ready from now on, so it is taken out of load balancing
+// while it shuts down.
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+func (c *Checker) run(ctx context.Context, name string) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if err := c.checks[name](ctx); err != nil {
+		slog.WarnContext(ctx, "health check failed", "check", name, "error", err)
+		return Result{Status: StatusUnavailable}
+	}
+	return Result{Status: StatusOK}
+}
+
+// Check implements grpchealth.Checker. The whole process and the registered services are
+// serving when all dependencies are, a dependency can also be asked for by its name.
+func (c *Checker) Check(ctx context.Context, req *grpchealth.CheckRequest) (*grpchealth.CheckResponse, error) {
+	var report Report
+	switch {
+	case req.Service == "" || slices.Contains(c.services, req.Service):
+		report = c.Run(ctx)
+	case c.checks[req.Service] != nil:
+		report = c.Run(ctx, req.Service)
+	default:
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("%w: %s", ErrUnknownService, req.Service))
+	}
+
+	if report.Status != StatusOK {
+		return &grpchealth.CheckResponse{Status: grpchealth.StatusNotServing}, nil
+	}
+	return &grpchealth.CheckResponse{Status: grpchealth.StatusServing}, nil
+}
+
+// ReadyHandler serves the report of all checks, with 503 Service Unavailable when any failed.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+
+		code := http.StatusOK
+		if report.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		writeReport(w, code, report)
+	})
+}
+
+// LiveHandler answers as long as the process can serve HTTP, whatever its dependencies do.
+func LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+func writeReport(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+func NewChecker(options Options) *Checker {
+	names := make([]string, 0, len(options.Checks))
+	for name := range options.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Checker{
+		checks:   options.Checks,
+		names:    names,
+		services: options.Services,
+		timeout:  timeout,
+	}
+}
@@ -0,0 +1,140 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+)
+
+type (
+	// StopFunc stops one part of the service. It should give up once ctx is done.
+	StopFunc func(ctx context.Context) error
+
+	stage struct {
+		name   string
+		budget time.Duration
+		stop   StopFunc
+	}
+
+	// Manager shuts the service down in stages, one after another in the order they were added,
+	// so every part is stopped before the parts it depends on. Every stage has a budget that
+	// is kept back for it from the stages before, so one running late cannot leave the later
+	// ones without time.
+	Manager struct {
+		mu      sync.Mutex
+		stages  []stage
+		timeout time.Duration
+	}
+)
+
+// OnShutdown adds a stage that gets at least budget to stop, more when the stages before it
+// finished early. A stage with no budget gets whatever the later stages leave of the timeout.
+func (m *Manager) OnShutdown(name string, budget time.Duration, stop StopFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stages = append(m.stages, stage{name, budget, stop})
+}
+
+// WaitForSignal blocks until one of signals is received and then shuts down.
+func (m *Manager) WaitForSignal(signals ...os.Signal) error {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+
+	sig := <-c
+	slog.Info("received signal, starting shutdown", "signal", sig.String())
+	return m.Shutdown(context.Background())
+}
+
+// Shutdown runs all stages within the timeout, each until the budgets of the stages after it
+// are all that is left, but for its own budget at least. Stages that fail or run out of time
+// are logged and do not keep the later ones from running, so connections are always closed.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deadline := time.Now().Add(m.timeout)
+	var reserved time.Duration
+	for _, s := range m.stages {
+		reserved += s.budget
+	}
+
+	var errs []error
+	for _, s := range m.stages {
+		reserved -= s.budget
+		start := time.Now()
+		stageDeadline := deadline.Add(-reserved)
+		if own := start.Add(s.budget); stageDeadline.Before(own) {
+			stageDeadline = own
+		}
+
+		stageCtx, cancel := context.WithDeadline(ctx, stageDeadline)
+		err := s.stop(stageCtx)
+		cancel()
+		if err != nil {
+			slog.Warn("shutdown: stage failed", "stage", s.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			continue
+		}
+		slog.Info("shutdown: stage done", "stage", s.name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+// Cancel returns a StopFunc that calls cancel, meant to stop the goroutines of wg, and waits
+// for them to return.
+func Cancel(cancel context.CancelFunc, wg *sync.WaitGroup) StopFunc {
+	return func(ctx context.Context) error {
+		cancel()
+		return Wait(ctx, wg)
+	}
+}
+
+// Wait waits for the goroutines of wgs to return, until ctx is done.
+func Wait(ctx context.Context, wgs ...*sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		for _, wg := range wgs {
+			wg.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause returns a StopFunc that waits for d, or until ctx is done.
+func Pause(d time.Duration) StopFunc {
+	return func(ctx context.Context) error {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+}
+
+// NewManager returns a manager whose shutdown takes timeout at most, 30 seconds when it is zero.
+func NewManager(timeout time.Duration) *Manager {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Manager{timeout: timeout}
+}
@@ -0,0 +1,84 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/lifecycle"
+)
+
+func TestStagesKeepTheirBudget(t *testing.T) {
+	shutdown := lifecycle.NewManager(300 * time.Millisecond)
+
+	var got []time.Duration
+	record := func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		got = append(got, time.Until(deadline))
+		return nil
+	}
+	// hangs until its context is done
+	shutdown.OnShutdown("hanging", 0, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	shutdown.OnShutdown("first", 100*time.Millisecond, record)
+	shutdown.OnShutdown("second", 100*time.Millisecond, record)
+
+	start := time.Now()
+	err := shutdown.Shutdown(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the hanging stage to run out of time", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("shutdown took %v, want the hanging stage stopped once only the budgets were left", elapsed)
+	}
+	if len(got) != 2 {
+		t.Fatalf("%d stages ran after the hanging one, want 2", len(got))
+	}
+	// first gets what second leaves, second what is left, as first finished early
+	if got[0] < 90*time.Millisecond || got[1] < 190*time.Millisecond {
+		t.Fatalf("stages got %v, want at least their budgets", got)
+	}
+}
+
+func TestStagesGetTheirBudgetPastTheTimeout(t *testing.T) {
+	shutdown := lifecycle.NewManager(50 * time.Millisecond)
+	// ignores its context
+	shutdown.OnShutdown("slow", 0, func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+
+	var remaining time.Duration
+	shutdown.OnShutdown("close", 100*time.Millisecond, func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		remaining = time.Until(deadline)
+		return nil
+	})
+
+	if err := shutdown.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if remaining < 50*time.Millisecond {
+		t.Fatalf("got %v, want the budget of the last stage", remaining)
+	}
+}
+
+func TestWait(t *testing.T) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := lifecycle.Wait(ctx, &wg); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v while the goroutine runs", err, context.DeadlineExceeded)
+	}
+
+	wg.Done()
+	if err := lifecycle.Wait(context.Background(), &wg); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	requestIDKey = "request_id"
+	traceIDKey   = "trace_id"
+	spanIDKey    = "span_id"
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid log format")
+	ErrInvalidLevel  = errors.New("invalid log level")
+)
+
+type (
+	Options struct {
+		// Format is FormatText or FormatJSON.
+		Format string
+		Level  slog.Level
+	}
+
+	requestIDContextKey struct{}
+
+	// handler redacts every record before passing it on, see redact.go, and adds the
+	// request id of its context.
+	handler struct {
+		next slog.Handler
+	}
+)
+
+// WithRequestID makes records logged with ctx carry id as their request_id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// ParseLevel accepts debug, info, warn and error, optionally with an offset like warn+2.
+func ParseLevel(text string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(text)); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidLevel, text)
+	}
+	return level, nil
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, redactText(record.Message), record.PC)
+	if id := RequestID(ctx); id != "" {
+		redacted.AddAttrs(slog.String(requestIDKey, id))
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		redacted.AddAttrs(slog.String(traceIDKey, span.TraceID().String()), slog.String(spanIDKey, span.SpanID().String()))
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+	return &handler{h.next.WithAttrs(redacted)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{h.next.WithGroup(name)}
+}
+
+// New returns a logger writing to w in the format and from the level of options. All its
+// output is redacted.
+func New(w io.Writer, options Options) (*slog.Logger, error) {
+	handlerOptions := &slog.HandlerOptions{Level: options.Level}
+
+	var next slog.Handler
+	switch strings.ToLower(options.Format) {
+	case FormatText, "":
+		next = slog.NewTextHandler(w, handlerOptions)
+	case FormatJSON:
+		next = slog.NewJSONHandler(w, handlerOptions)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, options.Format)
+	}
+	return slog.New(&handler{next}), nil
+}
@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/ilivestrong/auth-service/internal/export"
+)
+
+const (
+	redacted = "[REDACTED]"
+)
+
+var (
+	// secretKeys are attribute keys whose values are never logged, also with a prefix such
+	// as fake_otp or refresh_token.
+	secretKeys = map[string]struct{}{
+		"otp":           {},
+		"token":         {},
+		"session_token": {},
+		"admin_token":   {},
+		"authorization": {},
+		"password":      {},
+	}
+
+	phoneNumberPattern = regexp.MustCompile(`\+\d{7,15}`)
+	jwtPattern         = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern      = regexp.MustCompile(`(?i)(bearer\s+)\S+`)
+	// otpPattern matches codes following the word otp, e.g. "otp for +91******7890 is 123456",
+	// once phone numbers are masked.
+	otpPattern = regexp.MustCompile(`(?i)(\botp\b(?:[^\d\n]|\+[\d*]+){0,40}?[^\d*+\n])\d{4,8}\b`)
+)
+
+// redactAttr masks the values of secretKeys and the phone numbers, OTPs and tokens found
+// in any other string, error or stringer value, also inside groups. Structs, maps, slices
+// and pointers are replaced as a whole, as their fields cannot be told apart safely.
+func redactAttr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	if isSecretKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactText(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		attrs := make([]any, len(group))
+		for i, a := range group {
+			attrs[i] = redactAttr(a)
+		}
+		return slog.Group(attr.Key, attrs...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, redactText(v.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, redactText(v.String()))
+		case []byte:
+			return slog.String(attr.Key, redactText(string(v)))
+		case nil:
+			return slog.Attr{Key: attr.Key, Value: value}
+		}
+		return slog.Attr{Key: attr.Key, Value: redactScalar(value.Any())}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	if _, secret := secretKeys[key]; secret {
+		return true
+	}
+	if i := strings.LastIndexByte(key, '_'); i >= 0 {
+		_, secret := secretKeys[key[i+1:]]
+		return secret
+	}
+	return false
+}
+
+// redactScalar keeps values of named bool and number types, redacts the text of named
+// string types and replaces everything else.
+func redactScalar(v any) slog.Value {
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return slog.AnyValue(v)
+	case reflect.String:
+		return slog.StringValue(redactText(fmt.Sprint(v)))
+	}
+	return slog.StringValue(redacted)
+}
+
+func redactText(text string) string {
+	text = jwtPattern.ReplaceAllString(text, redacted)
+	text = bearerPattern.ReplaceAllString(text, "${1}"+redacted)
+	text = phoneNumberPattern.ReplaceAllStringFunc(text, export.RedactPhoneNumber)
+	return otpPattern.ReplaceAllString(text, "${1}"+redacted)
+}
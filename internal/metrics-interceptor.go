@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/ilivestrong/auth-service/internal/metrics"
+)
+
+const (
+	codeOK = "ok"
+)
+
+type (
+	metricsInterceptor struct{}
+)
+
+func (mi *metricsInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return connect.UnaryFunc(func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		start := time.Now()
+		res, err := next(ctx, req)
+		observeCall(req.Spec().Procedure, start, err)
+		return res, err
+	})
+}
+
+func (mi *metricsInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (mi *metricsInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return connect.StreamingHandlerFunc(func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		start := time.Now()
+		err := next(ctx, conn)
+		observeCall(conn.Spec().Procedure, start, err)
+		return err
+	})
+}
+
+func observeCall(procedure string, start time.Time, err error) {
+	code := codeOK
+	if err != nil {
+		code = connect.CodeOf(err).String()
+	}
+
+	metrics.RPCRequests.WithLabelValues(procedure, code).Inc()
+	metrics.RPCDuration.WithLabelValues(procedure).Observe(time.Since(start).Seconds())
+}
+
+// NewMetricsInterceptor counts RPCs by procedure and status code and records their duration.
+func NewMetricsInterceptor() connect.Interceptor {
+	return &metricsInterceptor{}
+}
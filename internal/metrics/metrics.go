@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "auth"
+)
+
+var (
+	RPCRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "RPCs handled, by procedure and status code.",
+	}, []string{"procedure", "code"})
+
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Time taken to handle RPCs, by procedure.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"procedure"})
+
+	Signups = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signups_total",
+		Help:      "Profiles created.",
+	})
+
+	Verifications = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "verifications_total",
+		Help:      "Phone numbers verified.",
+	})
+
+	OtpMismatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "otp_mismatches_total",
+		Help:      "Incorrect OTPs entered, by RPC.",
+	}, []string{"rpc"})
+
+	Logins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Sessions started.",
+	})
+
+	Logouts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logouts_total",
+		Help:      "Sessions ended by logging out.",
+	})
+
+	MQPublishFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mq_publish_failures_total",
+		Help:      "Messages dropped as they could not be published or queued for publishing, by message type.",
+	}, []string{"message_type"})
+
+	MQPublishRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mq_publish_retries_total",
+		Help:      "Failed attempts to publish domain events that are retried, by message type.",
+	}, []string{"message_type"})
+
+	MQConsumerLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mq_consumer_lag_seconds",
+		Help:      "Time from publishing OtpCreated messages until they are handled.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	})
+)
+
+// RegisterActiveSessions reports count, the number of logged in users, as a gauge.
+func RegisterActiveSessions(count func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Users currently logged in.",
+	}, func() float64 { return float64(count()) })
+}
+
+// ObserveConsumerLag records the lag of a message published at sentAt, if it carries a timestamp.
+func ObserveConsumerLag(sentAt time.Time) {
+	if !sentAt.IsZero() {
+		MQConsumerLag.Observe(time.Since(sentAt).Seconds())
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,376 @@
+package migrate
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+
+	// irreversibleMarker starts the down file of a migration that cannot be reverted,
+	// followed by the reason.
+	irreversibleMarker = "-- irreversible:"
+
+	lockTimeout      = time.Minute
+	lockPollInterval = time.Second
+)
+
+var (
+	ErrUnknownDialect  = errors.New("no migrations for database dialect")
+	ErrInvalidFileName = errors.New("invalid migration file name")
+	ErrSchemaMismatch  = errors.New("database schema version does not match this build")
+	ErrLocked          = errors.New("migrations are locked by another process")
+	ErrUnknownVersion  = errors.New("unknown migration version")
+	ErrIrreversible    = errors.New("migration cannot be reverted")
+
+	//go:embed postgres/*.sql sqlite/*.sql
+	files embed.FS
+)
+
+type (
+	// Migrator applies the SQL migrations embedded for a database dialect. Every migration
+	// runs in its own transaction and a lock row keeps concurrent runs apart.
+	Migrator interface {
+		// Up applies pending migrations up to and including target, or all of them when target is 0.
+		Up(ctx context.Context, target int64) ([]Migration, error)
+		// Down reverts the latest steps applied migrations. Nothing is reverted when one of
+		// them is irreversible.
+		Down(ctx context.Context, steps int) ([]Migration, error)
+		Status(ctx context.Context) (*Status, error)
+		// Check fails with ErrSchemaMismatch unless exactly the embedded migrations are applied.
+		Check(ctx context.Context) error
+		// Unlock removes a lock left behind by a migration run that died.
+		Unlock(ctx context.Context) error
+	}
+
+	Migration struct {
+		Version int64
+		Name    string
+		Up      string
+		Down    string
+		// Irreversible is why the migration cannot be reverted, empty when it can.
+		Irreversible string
+	}
+
+	Status struct {
+		Current int64
+		Latest  int64
+		Applied []AppliedMigration
+		Pending []Migration
+	}
+
+	AppliedMigration struct {
+		Version   int64
+		Name      string
+		AppliedAt time.Time
+	}
+
+	schemaMigration struct {
+		Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+		Name      string
+		AppliedAt time.Time
+	}
+
+	migrator struct {
+		db         *gorm.DB
+		migrations []Migration
+		owner      string
+	}
+)
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+func (m *migrator) Up(ctx context.Context, target int64) ([]Migration, error) {
+	if target == 0 {
+		target = m.latest()
+	}
+	if target != 0 && m.find(target) == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	var done []Migration
+	err := m.locked(ctx, func() error {
+		current, err := m.current(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version <= current || migration.Version > target {
+				continue
+			}
+			if err := m.apply(ctx, migration.Up, func(tx *gorm.DB) error {
+				return tx.Create(&schemaMigration{migration.Version, migration.Name, time.Now().UTC()}).Error
+			}); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+
+	var done []Migration
+	err := m.locked(ctx, func() error {
+		var applied []schemaMigration
+		if err := m.db.WithContext(ctx).Order("version DESC").Limit(steps).Find(&applied).Error; err != nil {
+			return err
+		}
+
+		for _, row := range applied {
+			migration := m.find(row.Version)
+			if migration == nil {
+				return fmt.Errorf("%w: %d is applied but not part of this build", ErrUnknownVersion, row.Version)
+			}
+			if migration.Irreversible != "" {
+				return fmt.Errorf("%w: %04d_%s, %s", ErrIrreversible, migration.Version, migration.Name, migration.Irreversible)
+			}
+		}
+
+		for _, row := range applied {
+			migration := m.find(row.Version)
+			if err := m.apply(ctx, migration.Down, func(tx *gorm.DB) error {
+				return tx.Delete(&schemaMigration{}, row.Version).Error
+			}); err != nil {
+				return fmt.Errorf("reverting migration %04d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, *migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+func (m *migrator) Status(ctx context.Context) (*Status, error) {
+	if err := m.ensureTables(ctx); err != nil {
+		return nil, err
+	}
+
+	var applied []schemaMigration
+	if err := m.db.WithContext(ctx).Order("version ASC").Find(&applied).Error; err != nil {
+		return nil, err
+	}
+
+	status := &Status{Latest: m.latest()}
+	versions := make(map[int64]bool, len(applied))
+	for _, row := range applied {
+		status.Applied = append(status.Applied, AppliedMigration{row.Version, row.Name, row.AppliedAt})
+		status.Current = row.Version
+		versions[row.Version] = true
+	}
+	for _, migration := range m.migrations {
+		if !versions[migration.Version] {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+func (m *migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(status.Pending) > 0 {
+		return fmt.Errorf("%w: database is at version %d, this build needs %d, run `migrate up`", ErrSchemaMismatch, status.Current, status.Latest)
+	}
+	if status.Current > status.Latest {
+		return fmt.Errorf("%w: database is at version %d, newer than %d known to this build", ErrSchemaMismatch, status.Current, status.Latest)
+	}
+	return nil
+}
+
+func (m *migrator) Unlock(ctx context.Context) error {
+	if err := m.ensureTables(ctx); err != nil {
+		return err
+	}
+	return m.db.WithContext(ctx).Exec("DELETE FROM schema_migrations_lock").Error
+}
+
+// apply runs the statements of script and record in one transaction.
+func (m *migrator) apply(ctx context.Context, script string, record func(tx *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, statement := range splitStatements(script) {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		return record(tx)
+	})
+}
+
+// locked runs fn while holding the migration lock, waiting up to lockTimeout for it.
+func (m *migrator) locked(ctx context.Context, fn func() error) error {
+	if err := m.ensureTables(ctx); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		result := m.db.WithContext(ctx).Exec(
+			"INSERT INTO schema_migrations_lock (id, owner, locked_at) VALUES (1, ?, ?) ON CONFLICT DO NOTHING",
+			m.owner, time.Now().UTC(),
+		)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			var owner string
+			m.db.WithContext(ctx).Raw("SELECT owner FROM schema_migrations_lock WHERE id = 1").Scan(&owner)
+			return fmt.Errorf("%w: held by %s, remove a stale lock with `migrate unlock`", ErrLocked, owner)
+		}
+
+		select {
+		case <-time.After(lockPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	defer m.db.WithContext(context.WithoutCancel(ctx)).Exec("DELETE FROM schema_migrations_lock WHERE id = 1 AND owner = ?", m.owner)
+	return fn()
+}
+
+func (m *migrator) ensureTables(ctx context.Context) error {
+	db := m.db.WithContext(ctx)
+	if err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name TEXT NOT NULL, applied_at TIMESTAMP NOT NULL)").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations_lock (id INTEGER PRIMARY KEY, owner TEXT NOT NULL, locked_at TIMESTAMP NOT NULL)").Error
+}
+
+func (m *migrator) current(ctx context.Context) (int64, error) {
+	var version *int64
+	if err := m.db.WithContext(ctx).Model(&schemaMigration{}).Select("MAX(version)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	if version == nil {
+		return 0, nil
+	}
+	return *version, nil
+}
+
+func (m *migrator) latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *migrator) find(version int64) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// splitStatements splits script into statements, each ending with a semicolon at the end
+// of a line. Comment lines are dropped.
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+	)
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// loadMigrations reads the <version>_<name>.up.sql and .down.sql pairs of dialect.
+func loadMigrations(dialect string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dialect)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDialect, dialect)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		base, direction, ok := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), ".")
+		versionText, name, found := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionText, 10, 64)
+		if !ok || !found || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFileName, entry.Name())
+		}
+
+		script, err := fs.ReadFile(files, path.Join(dialect, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+			if reason, found := strings.CutPrefix(migration.Down, irreversibleMarker); found {
+				migration.Irreversible = strings.TrimSpace(strings.SplitN(reason, "\n", 2)[0])
+			}
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("%w: %04d_%s needs both an up and a down file", ErrInvalidFileName, migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func NewMigrator(db *gorm.DB, dialect string) (Migrator, error) {
+	migrations, err := loadMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	return &migrator{db, migrations, fmt.Sprintf("%s:%d", hostname, os.Getpid())}, nil
+}
@@ -0,0 +1,154 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ilivestrong/auth-service/internal/migrate"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testPostgresDSN names the env var holding the DSN of a throwaway Postgres database, the
+// tests using it drop and recreate every table of the service.
+const testPostgresDSN = "TEST_POSTGRES_DSN"
+
+type (
+	// baselineProfile and baselineEvent are the models as the first release auto-migrated them.
+	baselineProfile struct {
+		gorm.Model
+		ID          string `gorm:"primary_key"`
+		Name        string
+		PhoneNumber string `json:"phone_number" gorm:"unique"`
+		Otp         string
+		IsVerified  bool `json:"is_verified"`
+	}
+
+	baselineEvent struct {
+		gorm.Model
+		ID          string `gorm:"primary_key"`
+		PhoneNumber string `json:"phone_number"`
+		EventType   string
+	}
+)
+
+func (baselineProfile) TableName() string { return "profiles" }
+
+func (baselineEvent) TableName() string { return "events" }
+
+func TestUpCreatesSQLiteSchema(t *testing.T) {
+	db, err := persist.OpenSQLite(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrateUp(t, db, migrate.DialectSQLite)
+	assertModelColumns(t, db)
+}
+
+func TestDownStopsAtIrreversibleMigrations(t *testing.T) {
+	ctx := context.Background()
+	db, err := persist.OpenSQLite(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrateUp(t, db, migrate.DialectSQLite)
+
+	migrator, err := migrate.NewMigrator(db, migrate.DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0003 encrypted personal data
+	if _, err := migrator.Down(ctx, int(status.Latest)); !errors.Is(err, migrate.ErrIrreversible) {
+		t.Fatalf("got %v, want %v", err, migrate.ErrIrreversible)
+	}
+	if status, err := migrator.Status(ctx); err != nil || status.Current != status.Latest {
+		t.Fatalf("got %+v, %v, want nothing reverted", status, err)
+	}
+
+	if _, err := migrator.Down(ctx, int(status.Latest)-3); err != nil {
+		t.Fatalf("reverting down to 0003 failed: %v", err)
+	}
+	if _, err := migrator.Down(ctx, 1); !errors.Is(err, migrate.ErrIrreversible) {
+		t.Fatalf("got %v, want %v", err, migrate.ErrIrreversible)
+	}
+	migrateUp(t, db, migrate.DialectSQLite)
+	assertModelColumns(t, db)
+}
+
+func TestUpAdoptsAutoMigratedPostgres(t *testing.T) {
+	dsn := os.Getenv(testPostgresDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", testPostgresDSN)
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"schema_migrations", "schema_migrations_lock", "job_leases", "data_exports", "event_chain_heads", "event_tombstones", "events", "profiles"} {
+		if err := db.Migrator().DropTable(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.AutoMigrate(&baselineProfile{}, &baselineEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&baselineProfile{ID: "p1", Name: "Jane", PhoneNumber: "+911234567890", Otp: "1234"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&baselineEvent{ID: "e1", PhoneNumber: "+911234567890", EventType: "PROFILE_SIGNUP"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	migrateUp(t, db, migrate.DialectPostgres)
+	assertModelColumns(t, db)
+}
+
+func migrateUp(t *testing.T, db *gorm.DB, dialect string) {
+	t.Helper()
+
+	migrator, err := migrate.NewMigrator(db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background(), 0); err != nil {
+		t.Fatalf("migrate up failed: %v", err)
+	}
+
+	status, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Current != status.Latest {
+		t.Fatalf("migrated to version %d, latest is %d", status.Current, status.Latest)
+	}
+}
+
+// assertModelColumns checks every column of the models exists.
+func assertModelColumns(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	for _, model := range []any{&models.Profile{}, &models.Event{}, &models.EventTombstone{}, &models.EventChainHead{}, &models.DataExport{}, &models.DataExportChunk{}, &models.JobLease{}} {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("%s has no column %s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+-- Schema as created by AutoMigrate before versioned migrations. Databases set up that
+-- way adopt it as version 1: IF NOT EXISTS skips their tables, and the ALTER TABLE
+-- statements add the columns that later versions of the models brought, whichever
+-- version of the service created them.
+CREATE TABLE IF NOT EXISTS profiles (
+	id text PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	name text,
+	phone_number text,
+	otp text,
+	is_verified boolean,
+	CONSTRAINT uni_profiles_phone_number UNIQUE (phone_number)
+);
+
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS created_at timestamptz;
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS name text;
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS phone_number text;
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS otp text;
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS is_verified boolean;
+
+CREATE INDEX IF NOT EXISTS idx_profiles_deleted_at ON profiles (deleted_at);
+
+CREATE TABLE IF NOT EXISTS events (
+	id text PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	profile_id text,
+	phone_number text,
+	event_type text,
+	session_id text,
+	client_ip text,
+	user_agent text,
+	request_id text,
+	outcome text,
+	reason text,
+	metadata json,
+	stream text,
+	sequence bigint,
+	prev_hash text,
+	hash text
+);
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS created_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS updated_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS profile_id text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS phone_number text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS event_type text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS session_id text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS client_ip text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS user_agent text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS request_id text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS outcome text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS reason text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS metadata json;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS stream text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS sequence bigint;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS prev_hash text;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS hash text;
+
+CREATE INDEX IF NOT EXISTS idx_events_deleted_at ON events (deleted_at);
+
+CREATE INDEX IF NOT EXISTS idx_events_profile_id ON events (profile_id);
+
+CREATE INDEX IF NOT EXISTS idx_events_phone_number_created_at ON events (phone_number, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events (created_at DESC, id DESC);
+
+-- unchained events leave hash empty and are exempt from the per stream sequence
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_stream_sequence ON events (stream, sequence) WHERE hash <> '';
+
+CREATE TABLE IF NOT EXISTS event_tombstones (
+	stream text,
+	sequence bigint,
+	hash text,
+	prev_hash text,
+	purged_at timestamptz,
+	rule text NOT NULL DEFAULT '',
+	mac text NOT NULL DEFAULT '',
+	PRIMARY KEY (stream, sequence)
+);
+
+ALTER TABLE event_tombstones ADD COLUMN IF NOT EXISTS rule text NOT NULL DEFAULT '';
+ALTER TABLE event_tombstones ADD COLUMN IF NOT EXISTS mac text NOT NULL DEFAULT '';
+
+-- the latest link of every hash chain stream, with a MAC under the audit hash key
+CREATE TABLE IF NOT EXISTS event_chain_heads (
+	stream text PRIMARY KEY,
+	sequence bigint NOT NULL,
+	hash text NOT NULL,
+	mac text NOT NULL,
+	updated_at timestamptz NOT NULL
+);
+
+-- leases keep background jobs, such as the retention purge, to one instance at a time
+CREATE TABLE IF NOT EXISTS job_leases (
+	name text PRIMARY KEY,
+	owner text NOT NULL,
+	expires_at timestamptz NOT NULL
+);
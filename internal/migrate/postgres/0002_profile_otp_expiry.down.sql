@@ -0,0 +1 @@
+ALTER TABLE profiles DROP COLUMN IF EXISTS otp_expires_at;
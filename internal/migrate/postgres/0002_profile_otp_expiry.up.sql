@@ -0,0 +1 @@
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS otp_expires_at timestamptz;
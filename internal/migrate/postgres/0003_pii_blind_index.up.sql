@@ -0,0 +1,13 @@
+-- Phone numbers and names are stored encrypted from now on and found by their blind
+-- index. Rows written before keep a NULL index until `pii rotate` encrypts them.
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS phone_number_index text;
+
+ALTER TABLE profiles DROP CONSTRAINT IF EXISTS uni_profiles_phone_number;
+
+ALTER TABLE profiles ADD CONSTRAINT uni_profiles_phone_number_index UNIQUE (phone_number_index);
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS phone_number_index text;
+
+DROP INDEX IF EXISTS idx_events_phone_number_created_at;
+
+CREATE INDEX IF NOT EXISTS idx_events_phone_number_index_created_at ON events (phone_number_index, created_at DESC, id DESC);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS data_export_chunks;
+DROP TABLE IF EXISTS data_exports;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+	id text PRIMARY KEY,
+	profile_id text NOT NULL,
+	phone_number text NOT NULL,
+	requested_by text NOT NULL,
+	status text NOT NULL,
+	error text NOT NULL DEFAULT '',
+	archive_size bigint NOT NULL DEFAULT 0,
+	created_at timestamptz NOT NULL,
+	started_at timestamptz,
+	completed_at timestamptz,
+	expires_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_profile_id ON data_exports (profile_id);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_status_created_at ON data_exports (status, created_at);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_expires_at ON data_exports (expires_at);
+
+CREATE TABLE IF NOT EXISTS data_export_chunks (
+	id text PRIMARY KEY,
+	export_id text NOT NULL,
+	sequence bigint NOT NULL,
+	data text NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_export_chunks_export_id_sequence ON data_export_chunks (export_id, sequence);
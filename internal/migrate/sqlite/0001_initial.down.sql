@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS job_leases;
+
+DROP TABLE IF EXISTS event_chain_heads;
+
+DROP TABLE IF EXISTS event_tombstones;
+
+DROP TABLE IF EXISTS events;
+
+DROP TABLE IF EXISTS profiles;
@@ -0,0 +1,72 @@
+CREATE TABLE IF NOT EXISTS profiles (
+	id text PRIMARY KEY,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	name text,
+	phone_number text,
+	otp text,
+	is_verified numeric,
+	CONSTRAINT uni_profiles_phone_number UNIQUE (phone_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_profiles_deleted_at ON profiles (deleted_at);
+
+CREATE TABLE IF NOT EXISTS events (
+	id text PRIMARY KEY,
+	created_at datetime,
+	updated_at datetime,
+	deleted_at datetime,
+	profile_id text,
+	phone_number text,
+	event_type text,
+	session_id text,
+	client_ip text,
+	user_agent text,
+	request_id text,
+	outcome text,
+	reason text,
+	metadata json,
+	stream text,
+	sequence integer,
+	prev_hash text,
+	hash text
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_deleted_at ON events (deleted_at);
+
+CREATE INDEX IF NOT EXISTS idx_events_profile_id ON events (profile_id);
+
+CREATE INDEX IF NOT EXISTS idx_events_phone_number_created_at ON events (phone_number, created_at DESC, id DESC);
+
+CREATE INDEX IF NOT EXISTS idx_events_created_at ON events (created_at DESC, id DESC);
+
+-- unchained events leave hash empty and are exempt from the per stream sequence
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_stream_sequence ON events (stream, sequence) WHERE hash <> '';
+
+CREATE TABLE IF NOT EXISTS event_tombstones (
+	stream text,
+	sequence integer,
+	hash text,
+	prev_hash text,
+	purged_at datetime,
+	rule text NOT NULL DEFAULT '',
+	mac text NOT NULL DEFAULT '',
+	PRIMARY KEY (stream, sequence)
+);
+
+-- the latest link of every hash chain stream, with a MAC under the audit hash key
+CREATE TABLE IF NOT EXISTS event_chain_heads (
+	stream text PRIMARY KEY,
+	sequence integer NOT NULL,
+	hash text NOT NULL,
+	mac text NOT NULL,
+	updated_at datetime NOT NULL
+);
+
+-- leases keep background jobs, such as the retention purge, to one instance at a time
+CREATE TABLE IF NOT EXISTS job_leases (
+	name text PRIMARY KEY,
+	owner text NOT NULL,
+	expires_at datetime NOT NULL
+);
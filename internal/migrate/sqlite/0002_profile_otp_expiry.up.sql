@@ -0,0 +1 @@
+ALTER TABLE profiles ADD COLUMN otp_expires_at datetime;
@@ -0,0 +1 @@
+-- irreversible: phone numbers and names are encrypted since, and the previous version can neither read them nor keep them unique. Restore a backup taken before `migrate up` instead.
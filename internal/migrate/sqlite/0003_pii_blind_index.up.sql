@@ -0,0 +1,12 @@
+-- Phone numbers and names are stored encrypted from now on and found by their blind
+-- index. Rows written before keep a NULL index until `pii rotate` encrypts them. The
+-- unique constraint on phone_number cannot be dropped in SQLite and stays.
+ALTER TABLE profiles ADD COLUMN phone_number_index text;
+
+CREATE UNIQUE INDEX uni_profiles_phone_number_index ON profiles (phone_number_index);
+
+ALTER TABLE events ADD COLUMN phone_number_index text;
+
+DROP INDEX IF EXISTS idx_events_phone_number_created_at;
+
+CREATE INDEX idx_events_phone_number_index_created_at ON events (phone_number_index, created_at DESC, id DESC);
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+const (
+	DataExportPending = "pending"
+	DataExportRunning = "running"
+	DataExportDone    = "done"
+	DataExportFailed  = "failed"
+
+	RequestedBySelf  = "self"
+	RequestedByAdmin = "admin"
+)
+
+type (
+	// DataExport is a request for the archive of everything held about a profile. The
+	// archive is assembled in the background, stored as DataExportChunks and kept until
+	// ExpiresAt.
+	DataExport struct {
+		ID          string `gorm:"primaryKey"`
+		ProfileID   string `gorm:"index"`
+		PhoneNumber string `gorm:"serializer:pii"`
+		RequestedBy string
+		Status      string
+		Error       string
+		// ArchiveSize is the length in bytes of the JSON archive.
+		ArchiveSize int64
+		CreatedAt   time.Time
+		StartedAt   *time.Time
+		CompletedAt *time.Time
+		ExpiresAt   *time.Time
+	}
+
+	// DataExportChunk is a piece of the archive of a data export, stored encrypted. The
+	// pieces in Sequence order make up the archive.
+	DataExportChunk struct {
+		ID       string `gorm:"primaryKey"`
+		ExportID string `gorm:"uniqueIndex:idx_data_export_chunks_export_id_sequence"`
+		Sequence int    `gorm:"uniqueIndex:idx_data_export_chunks_export_id_sequence"`
+		Data     string `gorm:"serializer:pii"`
+	}
+)
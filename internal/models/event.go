@@ -1,12 +1,94 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
 
 type (
 	Event struct {
 		gorm.Model
 		ID          string `gorm:"primary_key"`
-		PhoneNumber string `json:"phone_number"`
-		EventType   string
+		ProfileID   string `json:"profile_id" gorm:"index"`
+		PhoneNumber string `json:"phone_number" gorm:"serializer:pii"`
+		// PhoneNumberIndex is the blind index of PhoneNumber, which is stored encrypted.
+		PhoneNumberIndex string   `json:"-"`
+		EventType        string   `json:"event_type"`
+		SessionID        string   `json:"session_id"`
+		ClientIP         string   `json:"client_ip"`
+		UserAgent        string   `json:"user_agent"`
+		RequestID        string   `json:"request_id"`
+		Outcome          string   `json:"outcome"`
+		Reason           string   `json:"reason"`
+		Metadata         Metadata `json:"metadata" gorm:"type:json"`
+
+		// Hash chain fields, only populated when the audit hash chain is enabled.
+		Stream   string `json:"stream"`
+		Sequence int64  `json:"sequence"`
+		PrevHash string `json:"prev_hash"`
+		Hash     string `json:"hash"`
+	}
+
+	// EventTombstone keeps the chain link of a hash chained event removed by the retention
+	// purge, so the remaining events of its stream can still be verified. Its MAC covers
+	// the link as well as when and by which retention rule the event was purged.
+	EventTombstone struct {
+		Stream   string `gorm:"primaryKey"`
+		Sequence int64  `gorm:"primaryKey;autoIncrement:false"`
+		Hash     string
+		PrevHash string
+		PurgedAt time.Time
+		Rule     string
+		MAC      string
+	}
+
+	// EventChainHead is the latest link of a hash chain stream. Its MAC lets the chain be
+	// verified up to its end, so events cut off the end of a stream are noticed too.
+	EventChainHead struct {
+		Stream    string `gorm:"primaryKey"`
+		Sequence  int64
+		Hash      string
+		MAC       string
+		UpdatedAt time.Time
 	}
+
+	// Metadata holds free-form details of an event and is stored as a JSON column.
+	Metadata map[string]string
 )
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *Metadata) Scan(value any) error {
+	var b []byte
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", value)
+	}
+	return json.Unmarshal(b, m)
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+type (
+	// JobLease is held by the instance running the background job Name until ExpiresAt.
+	JobLease struct {
+		Name      string `gorm:"primaryKey"`
+		Owner     string
+		ExpiresAt time.Time
+	}
+)
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -8,9 +10,12 @@ type (
 	Profile struct {
 		gorm.Model
 		ID          string `gorm:"primary_key"`
-		Name        string
-		PhoneNumber string `json:"phone_number" gorm:"unique"`
-		Otp         string
-		IsVerified  bool `json:"is_verified"`
+		Name        string `gorm:"serializer:pii"`
+		PhoneNumber string `json:"phone_number" gorm:"serializer:pii"`
+		// PhoneNumberIndex is the blind index of PhoneNumber, which is stored encrypted.
+		PhoneNumberIndex string `json:"-" gorm:"unique"`
+		Otp              string
+		OtpExpiresAt     *time.Time `json:"otp_expires_at"`
+		IsVerified       bool       `json:"is_verified"`
 	}
 )
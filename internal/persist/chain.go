@@ -0,0 +1,452 @@
+package persist
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/pii"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// anonymousStream chains events that could not be attributed to a phone number.
+	anonymousStream   = "anonymous"
+	phoneStreamPrefix = "phone:"
+
+	chainKeySize           = 32
+	maxChainAppendAttempts = 5
+	chainVerifyBatchSize   = 500
+
+	// kinds of MACs, so one cannot pass for another
+	macEvent     = "event"
+	macHead      = "head"
+	macTombstone = "tombstone"
+)
+
+var (
+	ErrVerifyChainFailed = errors.New("failed to verify audit log")
+	ErrNoChainKey        = errors.New("no audit hash key configured")
+	ErrInvalidChainKey   = fmt.Errorf("audit hash key must be %d base64 encoded bytes", chainKeySize)
+)
+
+type (
+	// ChainKey keys the MACs chaining audit events. It is kept outside the database, so
+	// whoever can write to the database cannot recompute the chain after editing it.
+	ChainKey []byte
+
+	// ChainReport is the outcome of walking the audit hash chain. BrokenLink is nil
+	// when every checked event links up with its predecessor.
+	ChainReport struct {
+		StreamsChecked int64
+		EventsChecked  int64
+		BrokenLink     *BrokenLink
+	}
+
+	BrokenLink struct {
+		Stream   string
+		EventID  string
+		Sequence int64
+		Reason   string
+	}
+
+	// chainLink is the position and hash of the last verified link of a stream.
+	chainLink struct {
+		sequence int64
+		hash     string
+	}
+
+	// chainWalk verifies the links of one stream, handed to it in sequence order, and
+	// finally its head.
+	chainWalk struct {
+		key  ChainKey
+		link chainLink
+		// tombstones returns the tombstones with a sequence between after and before, in order.
+		tombstones func(after, before int64) ([]models.EventTombstone, error)
+	}
+
+	// chainedEvent is the canonical form of an event that gets hashed, field order is
+	// part of the format and must not change.
+	chainedEvent struct {
+		ID          string            `json:"id"`
+		Stream      string            `json:"stream"`
+		Sequence    int64             `json:"sequence"`
+		PrevHash    string            `json:"prev_hash"`
+		CreatedAt   string            `json:"created_at"`
+		EventType   string            `json:"event_type"`
+		ProfileID   string            `json:"profile_id"`
+		PhoneNumber string            `json:"phone_number"`
+		SessionID   string            `json:"session_id"`
+		ClientIP    string            `json:"client_ip"`
+		UserAgent   string            `json:"user_agent"`
+		RequestID   string            `json:"request_id"`
+		Outcome     string            `json:"outcome"`
+		Reason      string            `json:"reason"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+)
+
+// ParseChainKey decodes a base64 encoded audit hash key.
+func ParseChainKey(encoded string) (ChainKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != chainKeySize {
+		return nil, ErrInvalidChainKey
+	}
+	return key, nil
+}
+
+// eventHash is the MAC of the event's canonical form, including the hash of its predecessor.
+func (key ChainKey) eventHash(event *models.Event) string {
+	return key.mac(macEvent, string(canonicalEvent(event)))
+}
+
+func (key ChainKey) headMAC(head *models.EventChainHead) string {
+	return key.mac(macHead, head.Stream, strconv.FormatInt(head.Sequence, 10), head.Hash)
+}
+
+// tombstoneMAC covers the link a tombstone stands in for and when and by which retention
+// rule the event was purged, so tombstones cannot be made up to hide deleted events.
+func (key ChainKey) tombstoneMAC(tombstone *models.EventTombstone) string {
+	return key.mac(macTombstone, tombstone.Stream, strconv.FormatInt(tombstone.Sequence, 10), tombstone.Hash,
+		tombstone.PrevHash, tombstone.PurgedAt.UTC().Format(time.RFC3339Nano), tombstone.Rule)
+}
+
+// newTombstone stands in for event, purged at purgedAt by rule. purgedAt is cut to the
+// microseconds the database keeps.
+func (key ChainKey) newTombstone(event *models.Event, rule string, purgedAt time.Time) models.EventTombstone {
+	tombstone := models.EventTombstone{
+		Stream:   event.Stream,
+		Sequence: event.Sequence,
+		Hash:     event.Hash,
+		PrevHash: event.PrevHash,
+		PurgedAt: purgedAt.UTC().Truncate(time.Microsecond),
+		Rule:     rule,
+	}
+	tombstone.MAC = key.tombstoneMAC(&tombstone)
+	return tombstone
+}
+
+func (key ChainKey) newHead(stream string, sequence int64, hash string) *models.EventChainHead {
+	head := &models.EventChainHead{Stream: stream, Sequence: sequence, Hash: hash, UpdatedAt: time.Now().UTC()}
+	head.MAC = key.headMAC(head)
+	return head
+}
+
+// mac is the hex HMAC-SHA256 of kind and parts.
+func (key ChainKey) mac(kind string, parts ...string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(kind))
+	for _, part := range parts {
+		m.Write([]byte{0})
+		m.Write([]byte(part))
+	}
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func canonicalEvent(event *models.Event) []byte {
+	metadata := event.Metadata
+	if metadata == nil {
+		// nil is stored as {} and read back as an empty map, hash both the same way
+		metadata = models.Metadata{}
+	}
+
+	canonical, _ := json.Marshal(chainedEvent{
+		ID:          event.ID,
+		Stream:      event.Stream,
+		Sequence:    event.Sequence,
+		PrevHash:    event.PrevHash,
+		CreatedAt:   event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		EventType:   event.EventType,
+		ProfileID:   event.ProfileID,
+		PhoneNumber: event.PhoneNumber,
+		SessionID:   event.SessionID,
+		ClientIP:    event.ClientIP,
+		UserAgent:   event.UserAgent,
+		RequestID:   event.RequestID,
+		Outcome:     event.Outcome,
+		Reason:      event.Reason,
+		Metadata:    metadata,
+	})
+	return canonical
+}
+
+func eventStream(keyring *pii.Keyring, event *models.Event) string {
+	if event.PhoneNumber != "" {
+		return phoneNumberStream(keyring, event.PhoneNumber)
+	}
+	return anonymousStream
+}
+
+// phoneNumberStream is the hash chain stream of the events of phoneNumber, named by its
+// blind index so phone numbers stay out of stream names.
+func phoneNumberStream(keyring *pii.Keyring, phoneNumber string) string {
+	if phoneNumber == "" {
+		return ""
+	}
+	return phoneStreamPrefix + phoneNumberIndex(keyring, phoneNumber)
+}
+
+func (pr *eventRepository) PhoneNumberStream(phoneNumber string) string {
+	return phoneNumberStream(pr.options.PII, phoneNumber)
+}
+
+// appendToChain links event to the head of its stream, inserts it and moves the head on to
+// it. Concurrent appends to the same stream collide on the (stream, sequence) unique index
+// and are retried.
+func appendToChain(db *gorm.DB, key ChainKey, keyring *pii.Keyring, event *models.Event) error {
+	if key == nil {
+		return ErrNoChainKey
+	}
+
+	event.Stream = eventStream(keyring, event)
+	// the database keeps microseconds, hash exactly what will be read back
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+
+	var err error
+	for attempt := 0; attempt < maxChainAppendAttempts; attempt++ {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var head models.EventChainHead
+			if err := tx.Where("stream = ?", event.Stream).Limit(1).Find(&head).Error; err != nil {
+				return err
+			}
+
+			event.Sequence, event.PrevHash = head.Sequence+1, head.Hash
+			event.Hash = key.eventHash(event)
+			if err := tx.Create(event).Error; err != nil {
+				return err
+			}
+			return saveHead(tx, key.newHead(event.Stream, event.Sequence, event.Hash))
+		})
+		if err == nil || !isDuplicateKeyError(db, err) {
+			return err
+		}
+	}
+	return err
+}
+
+func saveHead(tx *gorm.DB, head *models.EventChainHead) error {
+	return tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "stream"}}, UpdateAll: true}).Create(head).Error
+}
+
+// VerifyChain walks the chain of stream, or of every stream when stream is empty, up to
+// its head, and stops at the first link that does not check out.
+func (pr *eventRepository) VerifyChain(ctx context.Context, stream string) (*ChainReport, error) {
+	if pr.options.ChainKey == nil {
+		return nil, ErrNoChainKey
+	}
+
+	streams := []string{stream}
+	if stream == "" {
+		var err error
+		if streams, err = pr.chainStreams(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &ChainReport{}
+	for _, s := range streams {
+		report.StreamsChecked++
+		if err := pr.verifyStream(ctx, s, report); err != nil {
+			return nil, err
+		}
+		if report.BrokenLink != nil {
+			break
+		}
+	}
+	return report, nil
+}
+
+// chainStreams lists every stream that has a head, events or tombstones, so a stream
+// missing any of them is still checked.
+func (pr *eventRepository) chainStreams(ctx context.Context) ([]string, error) {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	var streams []string
+	result := db.Raw(`SELECT stream FROM event_chain_heads
+		UNION SELECT stream FROM events WHERE stream <> ''
+		UNION SELECT stream FROM event_tombstones
+		ORDER BY stream`).Scan(&streams)
+	if result.Error != nil {
+		return nil, ErrVerifyChainFailed
+	}
+	return streams, nil
+}
+
+func (pr *eventRepository) verifyStream(ctx context.Context, stream string, report *ChainReport) error {
+	walk := &chainWalk{key: pr.options.ChainKey, tombstones: func(after, before int64) ([]models.EventTombstone, error) {
+		return pr.tombstonesBetween(ctx, stream, after, before)
+	}}
+
+	for {
+		batch, err := pr.chainBatchAfter(ctx, stream, walk.link.sequence)
+		if err != nil {
+			return err
+		}
+
+		for i := range batch {
+			event := &batch[i]
+			report.EventsChecked++
+
+			reason, err := walk.next(event)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				report.BrokenLink = &BrokenLink{Stream: stream, EventID: event.ID, Sequence: event.Sequence, Reason: reason}
+				return nil
+			}
+		}
+
+		if len(batch) < chainVerifyBatchSize {
+			break
+		}
+	}
+
+	head, err := pr.chainHead(ctx, stream)
+	if err != nil {
+		return err
+	}
+	reason, err := walk.end(head)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		report.BrokenLink = &BrokenLink{Stream: stream, Sequence: walk.link.sequence, Reason: reason}
+	}
+	return nil
+}
+
+// chainBatchAfter loads the next events of stream after sequence. Events whose hash was
+// cleared are included, so they fail verification rather than go unnoticed.
+func (pr *eventRepository) chainBatchAfter(ctx context.Context, stream string, sequence int64) ([]models.Event, error) {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	var batch []models.Event
+	result := db.
+		Where("stream = ? AND sequence > ?", stream, sequence).
+		Order("sequence ASC").
+		Limit(chainVerifyBatchSize).
+		Find(&batch)
+	if result.Error != nil {
+		return nil, ErrVerifyChainFailed
+	}
+	return batch, nil
+}
+
+// chainHead returns the head of stream, nil when it has none.
+func (pr *eventRepository) chainHead(ctx context.Context, stream string) (*models.EventChainHead, error) {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	var head models.EventChainHead
+	result := db.Where("stream = ?", stream).Limit(1).Find(&head)
+	if result.Error != nil {
+		return nil, ErrVerifyChainFailed
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &head, nil
+}
+
+// next verifies event follows the last link, bridging sequence gaps left by retention
+// purges through their tombstones, and advances to event.
+func (w *chainWalk) next(event *models.Event) (string, error) {
+	if !hmac.Equal([]byte(w.key.eventHash(event)), []byte(event.Hash)) {
+		return "event content does not match its hash", nil
+	}
+
+	if reason, err := w.bridge(event.Sequence); reason != "" || err != nil {
+		return reason, err
+	}
+	if event.Sequence != w.link.sequence+1 {
+		return fmt.Sprintf("missing events between sequence %d and %d", w.link.sequence, event.Sequence), nil
+	}
+	if event.PrevHash != w.link.hash {
+		return "previous hash does not match the preceding event", nil
+	}
+
+	w.link = chainLink{event.Sequence, event.Hash}
+	return "", nil
+}
+
+// end verifies the walk reached head, the latest link appended to the stream. A stream
+// without a head must not have any links.
+func (w *chainWalk) end(head *models.EventChainHead) (string, error) {
+	if head == nil {
+		if reason, err := w.bridge(math.MaxInt64); reason != "" || err != nil {
+			return reason, err
+		}
+		if w.link.sequence > 0 {
+			return "the chain head is missing", nil
+		}
+		return "", nil
+	}
+
+	if !hmac.Equal([]byte(w.key.headMAC(head)), []byte(head.MAC)) {
+		return "the chain head does not match its MAC", nil
+	}
+	if reason, err := w.bridge(head.Sequence + 1); reason != "" || err != nil {
+		return reason, err
+	}
+	switch {
+	case w.link.sequence < head.Sequence:
+		return fmt.Sprintf("events after sequence %d are missing, the chain head is at %d", w.link.sequence, head.Sequence), nil
+	case w.link.sequence > head.Sequence:
+		return fmt.Sprintf("events after the chain head at sequence %d", head.Sequence), nil
+	case w.link.hash != head.Hash:
+		return "the chain head does not match the last event", nil
+	}
+	return "", nil
+}
+
+// bridge advances the walk over the tombstones directly following it, up to before.
+func (w *chainWalk) bridge(before int64) (string, error) {
+	if before <= w.link.sequence+1 {
+		return "", nil
+	}
+
+	tombstones, err := w.tombstones(w.link.sequence, before)
+	if err != nil {
+		return "", err
+	}
+	for i := range tombstones {
+		tombstone := &tombstones[i]
+		if tombstone.Sequence != w.link.sequence+1 || tombstone.PrevHash != w.link.hash {
+			break
+		}
+		if !hmac.Equal([]byte(w.key.tombstoneMAC(tombstone)), []byte(tombstone.MAC)) {
+			return fmt.Sprintf("tombstone at sequence %d does not match its MAC", tombstone.Sequence), nil
+		}
+		w.link = chainLink{tombstone.Sequence, tombstone.Hash}
+	}
+	return "", nil
+}
+
+// tombstonesBetween returns the tombstones of stream with a sequence between after and before, in order.
+func (pr *eventRepository) tombstonesBetween(ctx context.Context, stream string, after, before int64) ([]models.EventTombstone, error) {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	var tombstones []models.EventTombstone
+	result := db.
+		Where("stream = ? AND sequence > ? AND sequence < ?", stream, after, before).
+		Order("sequence ASC").
+		Find(&tombstones)
+	if result.Error != nil {
+		return nil, ErrVerifyChainFailed
+	}
+	return tombstones, nil
+}
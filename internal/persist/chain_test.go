@@ -0,0 +1,138 @@
+package persist_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	"gorm.io/gorm"
+)
+
+var testChainKey = persist.ChainKey(strings.Repeat("k", 32))
+
+// chainedRepo returns an event repository chaining into a fresh database, with three
+// events appended to the stream of testPhoneNumber.
+func chainedRepo(t *testing.T) (*gorm.DB, persist.EventRepo) {
+	t.Helper()
+
+	db := openTestDB(t)
+	repo := persist.NewEventRepository(db, persist.EventRepoOptions{HashChain: true, ChainKey: testChainKey, PII: testKeyring})
+	for _, eventType := range []string{"PROFILE_SIGNUP", "PROFILE_VERIFY", "PROFILE_LOGIN"} {
+		if _, err := repo.Create(context.Background(), &models.Event{PhoneNumber: testPhoneNumber, EventType: eventType}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db, repo
+}
+
+func TestVerifyChainDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, db *gorm.DB)
+		reason string
+	}{
+		{"intact", func(t *testing.T, db *gorm.DB) {}, ""},
+		{"edited event", func(t *testing.T, db *gorm.DB) {
+			exec(t, db, "UPDATE events SET event_type = 'PROFILE_VIEW' WHERE sequence = 2")
+		}, "event content does not match its hash"},
+		{"cleared hash", func(t *testing.T, db *gorm.DB) {
+			exec(t, db, "UPDATE events SET hash = '' WHERE sequence = 3")
+		}, "event content does not match its hash"},
+		{"appended with another key", func(t *testing.T, db *gorm.DB) {
+			other := persist.NewEventRepository(db, persist.EventRepoOptions{HashChain: true, ChainKey: persist.ChainKey(strings.Repeat("x", 32)), PII: testKeyring})
+			if _, err := other.Create(context.Background(), &models.Event{PhoneNumber: testPhoneNumber, EventType: "PROFILE_LOGOUT"}); err != nil {
+				t.Fatal(err)
+			}
+		}, "event content does not match its hash"},
+		{"deleted event", func(t *testing.T, db *gorm.DB) {
+			exec(t, db, "DELETE FROM events WHERE sequence = 2")
+		}, "missing events between sequence 1 and 3"},
+		{"deleted last event", func(t *testing.T, db *gorm.DB) {
+			exec(t, db, "DELETE FROM events WHERE sequence = 3")
+		}, "events after sequence 2 are missing, the chain head is at 3"},
+		{"deleted last event and moved the head back", func(t *testing.T, db *gorm.DB) {
+			exec(t, db, "DELETE FROM events WHERE sequence = 3")
+			exec(t, db, "UPDATE event_chain_heads SET sequence = 2, hash = (SELECT hash FROM events WHERE sequence = 2)")
+		}, "the chain head does not match its MAC"},
+		{"deleted head", func(t *testing.T, db *gorm.DB) {
+			exec(t, db, "DELETE FROM event_chain_heads")
+		}, "the chain head is missing"},
+		{"purged event", func(t *testing.T, db *gorm.DB) {
+			purgeSignup(t, db)
+		}, ""},
+		{"edited tombstone", func(t *testing.T, db *gorm.DB) {
+			purgeSignup(t, db)
+			exec(t, db, "UPDATE event_tombstones SET rule = 'PROFILE_VERIFY'")
+		}, "tombstone at sequence 1 does not match its MAC"},
+		{"deleted event behind a tombstone", func(t *testing.T, db *gorm.DB) {
+			exec(t, db, "INSERT INTO event_tombstones (stream, sequence, hash, prev_hash, purged_at, rule, mac) SELECT stream, sequence, hash, prev_hash, created_at, 'default', '' FROM events WHERE sequence = 2")
+			exec(t, db, "DELETE FROM events WHERE sequence = 2")
+		}, "tombstone at sequence 2 does not match its MAC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, repo := chainedRepo(t)
+			tt.tamper(t, db)
+
+			report, err := repo.VerifyChain(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var reason string
+			if report.BrokenLink != nil {
+				reason = report.BrokenLink.Reason
+			}
+			if reason != tt.reason {
+				t.Fatalf("got broken link %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestChainedEventRollsBackWithUnitOfWork(t *testing.T) {
+	ctx := context.Background()
+	db, repo := chainedRepo(t)
+
+	failed := errors.New("token could not be issued")
+	err := persist.NewUnitOfWork(db, 0).Do(ctx, func(ctx context.Context) error {
+		if _, err := repo.Create(ctx, &models.Event{PhoneNumber: testPhoneNumber, EventType: "PROFILE_LOGIN"}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+
+	if _, err := repo.Create(ctx, &models.Event{PhoneNumber: testPhoneNumber, EventType: "PROFILE_LOGIN"}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := repo.VerifyChain(ctx, "")
+	if err != nil || report.BrokenLink != nil || report.EventsChecked != 4 {
+		t.Fatalf("got %+v, %v, want 4 intact events", report, err)
+	}
+}
+
+// purgeSignup purges the first event of the stream chainedRepo writes, leaving a tombstone.
+func purgeSignup(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	repo := persist.NewEventRepository(db, persist.EventRepoOptions{ChainKey: testChainKey, PII: testKeyring})
+	rule := persist.RetentionRule{Name: "PROFILE_SIGNUP", EventTypes: []string{"PROFILE_SIGNUP"}, Before: time.Now().Add(time.Hour)}
+	if purged, err := repo.PurgeExpired(context.Background(), rule, 10, nil); err != nil || purged != 1 {
+		t.Fatalf("purged %d events, %v", purged, err)
+	}
+}
+
+func exec(t *testing.T, db *gorm.DB, sql string) {
+	t.Helper()
+
+	if err := db.Exec(sql).Error; err != nil {
+		t.Fatalf("%s: %v", sql, err)
+	}
+}
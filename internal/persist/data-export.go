@@ -0,0 +1,260 @@
+package persist
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/pii"
+	"gorm.io/gorm"
+)
+
+const (
+	maxClaimAttempts = 5
+)
+
+var (
+	ErrCreateDataExportFailed = errors.New("failed to create data export")
+	ErrUpdateDataExportFailed = errors.New("failed to update data export")
+	ErrGetDataExportFailed    = errors.New("failed to get data export")
+	ErrDataExportNotFound     = errors.New("data export not found or expired")
+)
+
+type (
+	DataExportRepo interface {
+		Create(ctx context.Context, export *models.DataExport) (string, error)
+		// Get returns the export with id unless it expired.
+		Get(ctx context.Context, id string) (*models.DataExport, error)
+		// FindActive returns the pending or running export of profileID.
+		FindActive(ctx context.Context, profileID string) (*models.DataExport, error)
+		// Claim marks the oldest pending export, or one left running for longer than
+		// staleAfter by a worker that died, as running and returns it. It returns nil
+		// when there is nothing to do.
+		Claim(ctx context.Context, staleAfter time.Duration) (*models.DataExport, error)
+		// AppendChunk stores data as piece sequence, counting from one, of the archive of the
+		// running export id.
+		AppendChunk(ctx context.Context, id string, sequence int, data []byte) error
+		// Complete marks the export done once all size bytes of its archive were appended.
+		Complete(ctx context.Context, id string, size int64, expiresAt time.Time) error
+		// Fail marks the export failed and deletes the pieces of its archive.
+		Fail(ctx context.Context, id string, reason string, expiresAt time.Time) error
+		// ReadChunks calls fn with the pieces of the archive of id in order, loading one
+		// at a time.
+		ReadChunks(ctx context.Context, id string, fn func(data []byte) error) error
+		PurgeExpired(ctx context.Context) (int64, error)
+	}
+	dataExportRepository struct {
+		db      *gorm.DB
+		options DataExportRepoOptions
+	}
+
+	DataExportRepoOptions struct {
+		// PII encrypts the phone number and the archive chunks.
+		PII *pii.Keyring
+		// QueryTimeout bounds every call whose context has no earlier deadline, zero means
+		// five seconds.
+		QueryTimeout time.Duration
+	}
+)
+
+func (dr *dataExportRepository) Create(ctx context.Context, export *models.DataExport) (string, error) {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	if export.ID == "" {
+		export.ID = uuid.New().String()
+	}
+	export.Status = models.DataExportPending
+	export.CreatedAt = time.Now().UTC()
+
+	if err := db.Create(export).Error; err != nil {
+		return "", ErrCreateDataExportFailed
+	}
+	return export.ID, nil
+}
+
+func (dr *dataExportRepository) Get(ctx context.Context, id string) (*models.DataExport, error) {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	var export models.DataExport
+	result := db.Where("id = ? AND (expires_at IS NULL OR expires_at > ?)", id, time.Now().UTC()).First(&export)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrDataExportNotFound
+	}
+	if result.Error != nil {
+		return nil, ErrGetDataExportFailed
+	}
+	return &export, nil
+}
+
+func (dr *dataExportRepository) FindActive(ctx context.Context, profileID string) (*models.DataExport, error) {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	var export models.DataExport
+	result := db.
+		Where("profile_id = ? AND status IN ?", profileID, []string{models.DataExportPending, models.DataExportRunning}).
+		Order("created_at DESC").
+		First(&export)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, ErrDataExportNotFound
+	}
+	if result.Error != nil {
+		return nil, ErrGetDataExportFailed
+	}
+	return &export, nil
+}
+
+// Claim picks a candidate and marks it running with a conditional update, trying the next
+// one when another worker claimed it first. The archive chunks a dead worker left behind
+// are deleted, the export starts over.
+func (dr *dataExportRepository) Claim(ctx context.Context, staleAfter time.Duration) (*models.DataExport, error) {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	for attempt := 0; attempt < maxClaimAttempts; attempt++ {
+		now := time.Now().UTC()
+		claimable := db.
+			Where("status = ?", models.DataExportPending).
+			Or("status = ? AND started_at < ?", models.DataExportRunning, now.Add(-staleAfter))
+
+		var export models.DataExport
+		result := db.Where(claimable).Order("created_at ASC").Limit(1).Find(&export)
+		if result.Error != nil {
+			return nil, ErrGetDataExportFailed
+		}
+		if result.RowsAffected == 0 {
+			return nil, nil
+		}
+
+		result = db.Model(&models.DataExport{}).
+			Where("id = ? AND status = ?", export.ID, export.Status).
+			Where("started_at IS NULL OR started_at = ?", export.StartedAt).
+			Updates(map[string]any{"status": models.DataExportRunning, "started_at": now})
+		if result.Error != nil {
+			return nil, ErrUpdateDataExportFailed
+		}
+		if result.RowsAffected == 1 {
+			if err := db.Where("export_id = ?", export.ID).Delete(&models.DataExportChunk{}).Error; err != nil {
+				return nil, ErrUpdateDataExportFailed
+			}
+			export.Status, export.StartedAt = models.DataExportRunning, &now
+			return &export, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dr *dataExportRepository) AppendChunk(ctx context.Context, id string, sequence int, data []byte) error {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	chunk := &models.DataExportChunk{ID: uuid.New().String(), ExportID: id, Sequence: sequence, Data: string(data)}
+	if err := db.Create(chunk).Error; err != nil {
+		return ErrUpdateDataExportFailed
+	}
+	return nil
+}
+
+func (dr *dataExportRepository) Complete(ctx context.Context, id string, size int64, expiresAt time.Time) error {
+	return dr.finish(ctx, id, &models.DataExport{Status: models.DataExportDone, ArchiveSize: size}, expiresAt)
+}
+
+func (dr *dataExportRepository) Fail(ctx context.Context, id string, reason string, expiresAt time.Time) error {
+	return dr.finish(ctx, id, &models.DataExport{Status: models.DataExportFailed, Error: reason}, expiresAt)
+}
+
+// finish stores the outcome of a running export, a failed one loses the chunks appended
+// so far.
+func (dr *dataExportRepository) finish(ctx context.Context, id string, outcome *models.DataExport, expiresAt time.Time) error {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	now, expiresAt := time.Now().UTC(), expiresAt.UTC()
+	outcome.CompletedAt, outcome.ExpiresAt = &now, &expiresAt
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.DataExport{}).Where("id = ? AND status = ?", id, models.DataExportRunning).Updates(outcome)
+		if result.Error != nil {
+			return ErrUpdateDataExportFailed
+		}
+		if result.RowsAffected == 0 {
+			return ErrDataExportNotFound
+		}
+		if outcome.Status == models.DataExportFailed {
+			if err := tx.Where("export_id = ?", id).Delete(&models.DataExportChunk{}).Error; err != nil {
+				return ErrUpdateDataExportFailed
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrDataExportNotFound) {
+		return ErrUpdateDataExportFailed
+	}
+	return err
+}
+
+// ReadChunks runs a query per chunk, each with its own timeout, so large archives are
+// neither loaded at once nor bound by the query timeout.
+func (dr *dataExportRepository) ReadChunks(ctx context.Context, id string, fn func(data []byte) error) error {
+	for sequence := 0; ; {
+		chunk, err := dr.chunkAfter(ctx, id, sequence)
+		if err != nil {
+			return err
+		}
+		if chunk == nil {
+			return nil
+		}
+		if err := fn([]byte(chunk.Data)); err != nil {
+			return err
+		}
+		sequence = chunk.Sequence
+	}
+}
+
+func (dr *dataExportRepository) chunkAfter(ctx context.Context, id string, sequence int) (*models.DataExportChunk, error) {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	var chunk models.DataExportChunk
+	result := db.Where("export_id = ? AND sequence > ?", id, sequence).Order("sequence ASC").Limit(1).Find(&chunk)
+	if result.Error != nil {
+		return nil, ErrGetDataExportFailed
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &chunk, nil
+}
+
+// PurgeExpired deletes the expired exports together with their archive chunks.
+func (dr *dataExportRepository) PurgeExpired(ctx context.Context) (int64, error) {
+	db, cancel := conn(withPII(ctx, dr.options.PII), dr.db, dr.options.QueryTimeout)
+	defer cancel()
+
+	var purged int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+		expired := tx.Model(&models.DataExport{}).Select("id").Where("expires_at <= ?", now)
+		if err := tx.Where("export_id IN (?)", expired).Delete(&models.DataExportChunk{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("expires_at <= ?", now).Delete(&models.DataExport{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, ErrUpdateDataExportFailed
+	}
+	return purged, nil
+}
+
+func NewDataExportRepository(db *gorm.DB, options DataExportRepoOptions) DataExportRepo {
+	return &tracedDataExportRepository{&dataExportRepository{db, options}, dbSystem(db)}
+}
@@ -0,0 +1,79 @@
+package persist_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+)
+
+func TestDataExportChunks(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	exports := persist.NewDataExportRepository(db, persist.DataExportRepoOptions{PII: testKeyring})
+
+	run := func(chunks ...string) string {
+		t.Helper()
+		id, err := exports.Create(ctx, &models.DataExport{ProfileID: "profile", PhoneNumber: testPhoneNumber})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claimed, err := exports.Claim(ctx, time.Minute); err != nil || claimed == nil || claimed.ID != id {
+			t.Fatalf("claimed %v, %v", claimed, err)
+		}
+		for i, chunk := range chunks {
+			if err := exports.AppendChunk(ctx, id, i+1, []byte(chunk)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id
+	}
+	read := func(id string) string {
+		t.Helper()
+		var archive strings.Builder
+		if err := exports.ReadChunks(ctx, id, func(data []byte) error {
+			archive.Write(data)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return archive.String()
+	}
+
+	done := run(`{"profile":`, `"Jane"}`)
+	if err := exports.Complete(ctx, done, 18, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(done); got != `{"profile":"Jane"}` {
+		t.Fatalf("read archive %q", got)
+	}
+
+	var stored []string
+	if err := db.Table("data_export_chunks").Pluck("data", &stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range stored {
+		if strings.Contains(data, "Jane") {
+			t.Fatalf("chunk stored in plaintext: %q", data)
+		}
+	}
+
+	failed := run(`{"partial":`)
+	if err := exports.Fail(ctx, failed, "boom", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(failed); got != "" {
+		t.Fatalf("failed export kept its chunks: %q", got)
+	}
+
+	if purged, err := exports.PurgeExpired(ctx); err != nil || purged != 1 {
+		t.Fatalf("purged %d exports: %v", purged, err)
+	}
+	var left int64
+	if err := db.Table("data_export_chunks").Count(&left).Error; err != nil || left != 0 {
+		t.Fatalf("%d chunks left after purge: %v", left, err)
+	}
+}
@@ -1,52 +1,178 @@
 package persist
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/pii"
 	"gorm.io/gorm"
 )
 
+const (
+	eventBatchSize = 500
+)
+
 var (
 	ErrCreateEventFailed = errors.New("failed to create event")
-	ErrListProfileFailed = errors.New("failed to get event list")
+	ErrListEventsFailed  = errors.New("failed to get event list")
 )
 
 type (
 	EventRepo interface {
-		Create(profile *models.Profile, eventType string) (string, error)
-		List(phoneNumber string) (*[]models.Event, error)
+		Create(ctx context.Context, event *models.Event) (string, error)
+		List(ctx context.Context, filter EventFilter, after *EventCursor, limit int) ([]models.Event, error)
+		VerifyChain(ctx context.Context, stream string) (*ChainReport, error)
+		// PhoneNumberStream is the name of the hash chain stream of the events of phoneNumber.
+		PhoneNumberStream(phoneNumber string) string
+		// ForEach calls fn with every event matching filter, oldest first, loading them in batches.
+		ForEach(ctx context.Context, filter EventFilter, fn func(event *models.Event) error) error
+		CountExpired(ctx context.Context, rule RetentionRule) (int64, error)
+		PurgeExpired(ctx context.Context, rule RetentionRule, limit int, archive func(events []models.Event) error) (int, error)
 	}
 	eventRepository struct {
-		db *gorm.DB
+		db      *gorm.DB
+		options EventRepoOptions
+	}
+
+	EventRepoOptions struct {
+		// HashChain links every new event to the previous one of its stream by hash,
+		// making edits and deletions detectable by VerifyChain.
+		HashChain bool
+		// ChainKey keys the hashes of the chain, it is needed to append to and to verify it.
+		ChainKey ChainKey
+		// PII encrypts the phone number, and keys the phone number index and the names of
+		// the hash chain streams.
+		PII *pii.Keyring
+		// Replicas, when set, serves List and ForEach from read replicas.
+		Replicas *Replicas
+		// QueryTimeout bounds every call whose context has no earlier deadline, zero means
+		// five seconds.
+		QueryTimeout time.Duration
+	}
+
+	// EventFilter narrows List down, zero values match everything.
+	EventFilter struct {
+		ProfileID   string
+		PhoneNumber string
+		EventTypes  []string
+		From        time.Time
+		To          time.Time
+	}
+
+	// EventCursor is the position of the last event of a page. List returns events
+	// ordered newest first by (created_at, id), strictly after the cursor.
+	EventCursor struct {
+		CreatedAt time.Time
+		ID        string
 	}
 )
 
-func (pr *eventRepository) Create(profile *models.Profile, eventType string) (string, error) {
-	newEvent := models.Event{
-		ID:          uuid.New().String(),
-		PhoneNumber: profile.PhoneNumber,
-		EventType:   eventType,
+func (pr *eventRepository) Create(ctx context.Context, event *models.Event) (string, error) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
 	}
-	result := pr.db.Create(&newEvent)
+
+	event.PhoneNumberIndex = phoneNumberIndex(pr.options.PII, event.PhoneNumber)
+
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	if pr.options.HashChain {
+		if err := appendToChain(db, pr.options.ChainKey, pr.options.PII, event); err != nil {
+			return "", ErrCreateEventFailed
+		}
+		return event.ID, nil
+	}
+
+	result := db.Create(event)
 
 	if result.Error != nil || result.RowsAffected == 0 {
 		return "", ErrCreateEventFailed
 	}
-	return newEvent.ID, nil
+	return event.ID, nil
 }
 
-func (pr *eventRepository) List(phoneNumber string) (*[]models.Event, error) {
+func (pr *eventRepository) List(ctx context.Context, filter EventFilter, after *EventCursor, limit int) ([]models.Event, error) {
 	var events []models.Event
-	result := pr.db.Where("phone_number = ?", phoneNumber).Find(&events)
+	err := pr.options.Replicas.readPage(withPII(ctx, pr.options.PII), pr.db, filter.PhoneNumber, pr.options.QueryTimeout, func(db *gorm.DB) (int, error) {
+		query := filtered(db, pr.options.PII, filter)
+		if after != nil {
+			query = query.Where("(created_at < ?) OR (created_at = ? AND id < ?)", after.CreatedAt, after.CreatedAt, after.ID)
+		}
+		err := query.Order("created_at DESC").Order("id DESC").Limit(limit).Find(&events).Error
+		return len(events), err
+	})
+
+	if err != nil {
+		return nil, ErrListEventsFailed
+	}
+	return events, nil
+}
+
+func (pr *eventRepository) ForEach(ctx context.Context, filter EventFilter, fn func(event *models.Event) error) error {
+	var after *EventCursor
+	for {
+		batch, err := pr.batchAfter(ctx, filter, after)
+		if err != nil {
+			return err
+		}
+
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) < eventBatchSize {
+			return nil
+		}
+		last := batch[len(batch)-1]
+		after = &EventCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+}
 
-	if result.Error != nil {
-		return nil, ErrGetProfileFailed
+// batchAfter loads the next eventBatchSize events of filter, oldest first, after the cursor.
+func (pr *eventRepository) batchAfter(ctx context.Context, filter EventFilter, after *EventCursor) ([]models.Event, error) {
+	var batch []models.Event
+	err := pr.options.Replicas.readPage(withPII(ctx, pr.options.PII), pr.db, filter.PhoneNumber, pr.options.QueryTimeout, func(db *gorm.DB) (int, error) {
+		query := filtered(db, pr.options.PII, filter)
+		if after != nil {
+			query = query.Where("(created_at > ?) OR (created_at = ? AND id > ?)", after.CreatedAt, after.CreatedAt, after.ID)
+		}
+		err := query.Order("created_at ASC").Order("id ASC").Limit(eventBatchSize).Find(&batch).Error
+		return len(batch), err
+	})
+
+	if err != nil {
+		return nil, ErrListEventsFailed
+	}
+	return batch, nil
+}
+
+func filtered(db *gorm.DB, keyring *pii.Keyring, filter EventFilter) *gorm.DB {
+	query := db.Model(&models.Event{})
+
+	if filter.ProfileID != "" {
+		query = query.Where("profile_id = ?", filter.ProfileID)
+	}
+	if filter.PhoneNumber != "" {
+		query = query.Where("phone_number_index = ?", phoneNumberIndex(keyring, filter.PhoneNumber))
+	}
+	if len(filter.EventTypes) > 0 {
+		query = query.Where("event_type IN ?", filter.EventTypes)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
 	}
-	return &events, nil
+	return query
 }
 
-func NewEventRepository(db *gorm.DB) EventRepo {
-	return &eventRepository{db}
+func NewEventRepository(db *gorm.DB, options EventRepoOptions) EventRepo {
+	return &tracedEventRepository{&eventRepository{db, options}, dbSystem(db)}
 }
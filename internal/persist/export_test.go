@@ -0,0 +1,12 @@
+package persist
+
+// CheckReplicas runs one lag check of r.
+var CheckReplicas = (*Replicas).check
+
+// SetReplicaLagQuery replaces the PostgreSQL lag query of r, for replicas on SQLite.
+func SetReplicaLagQuery(r *Replicas, query string) {
+	r.lagQuery = query
+}
+
+// WithPII lets tests write models with the pii serializer straight to the database.
+var WithPII = withPII
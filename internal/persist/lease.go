@@ -0,0 +1,65 @@
+package persist
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAcquireLeaseFailed = errors.New("failed to acquire lease")
+)
+
+type (
+	// LeaseRepo hands out named, expiring leases that keep background jobs run by every
+	// instance to one instance at a time.
+	LeaseRepo interface {
+		// Acquire takes, or renews, the lease name for owner until ttl from now. It returns
+		// false when another owner holds a lease that has not expired.
+		Acquire(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error)
+		// Release gives the lease up if owner holds it.
+		Release(ctx context.Context, name string, owner string) error
+	}
+	leaseRepository struct {
+		db *gorm.DB
+	}
+)
+
+func (lr *leaseRepository) Acquire(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	db, cancel := conn(ctx, lr.db, 0)
+	defer cancel()
+
+	now := time.Now().UTC()
+	lease := models.JobLease{Name: name, Owner: owner, ExpiresAt: now.Add(ttl)}
+
+	result := db.Model(&models.JobLease{}).
+		Where("name = ? AND (owner = ? OR expires_at < ?)", name, owner, now).
+		Updates(map[string]any{"owner": lease.Owner, "expires_at": lease.ExpiresAt})
+	if result.Error != nil {
+		return false, ErrAcquireLeaseFailed
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	result = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lease)
+	if result.Error != nil {
+		return false, ErrAcquireLeaseFailed
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (lr *leaseRepository) Release(ctx context.Context, name string, owner string) error {
+	db, cancel := conn(ctx, lr.db, 0)
+	defer cancel()
+
+	return db.Where("name = ? AND owner = ?", name, owner).Delete(&models.JobLease{}).Error
+}
+
+func NewLeaseRepository(db *gorm.DB) LeaseRepo {
+	return &tracedLeaseRepository{&leaseRepository{db}, dbSystem(db)}
+}
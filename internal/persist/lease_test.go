@@ -0,0 +1,36 @@
+package persist_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/persist"
+)
+
+func TestLeaseRepository(t *testing.T) {
+	ctx := context.Background()
+	leases := persist.NewLeaseRepository(openTestDB(t))
+
+	acquire := func(owner string, ttl time.Duration, want bool) {
+		t.Helper()
+		if acquired, err := leases.Acquire(ctx, "job", owner, ttl); err != nil || acquired != want {
+			t.Fatalf("%s acquired the lease: %v, %v, want %v", owner, acquired, err, want)
+		}
+	}
+
+	acquire("a", time.Minute, true)
+	acquire("b", time.Minute, false)
+	acquire("a", time.Minute, true) // renewed
+
+	if err := leases.Release(ctx, "job", "b"); err != nil {
+		t.Fatal(err)
+	}
+	acquire("b", time.Minute, false) // b cannot release a's lease
+
+	if err := leases.Release(ctx, "job", "a"); err != nil {
+		t.Fatal(err)
+	}
+	acquire("b", -time.Second, true)
+	acquire("a", time.Minute, true) // b's lease expired
+}
@@ -0,0 +1,528 @@
+package persist
+
+import (
+	"context"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ilivestrong/auth-service/internal/models"
+)
+
+type (
+	inMemoryProfileRepository struct {
+		mu       sync.RWMutex
+		options  ProfileRepoOptions
+		profiles map[string]models.Profile
+	}
+
+	inMemoryEventRepository struct {
+		mu         sync.RWMutex
+		options    EventRepoOptions
+		events     []models.Event
+		tombstones []models.EventTombstone
+		heads      map[string]models.EventChainHead
+	}
+
+	inMemoryDataExportRepository struct {
+		mu      sync.Mutex
+		exports map[string]models.DataExport
+		chunks  map[string][][]byte
+	}
+
+	inMemoryUnitOfWork struct{}
+)
+
+func (pr *inMemoryProfileRepository) Create(ctx context.Context, phoneNumber string, name string) (string, error) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if _, exists := pr.profiles[phoneNumber]; exists {
+		return "", ErrProfileAlreadyExists
+	}
+
+	profile := models.Profile{ID: uuid.New().String(), Name: name, PhoneNumber: phoneNumber}
+	profile.CreatedAt = time.Now().UTC()
+	profile.UpdatedAt = profile.CreatedAt
+	pr.profiles[phoneNumber] = profile
+	return profile.ID, nil
+}
+
+func (pr *inMemoryProfileRepository) Get(ctx context.Context, phoneNumber string) (*models.Profile, error) {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+
+	profile, exists := pr.profiles[phoneNumber]
+	if !exists {
+		return nil, ErrProfileNotFound
+	}
+	return &profile, nil
+}
+
+func (pr *inMemoryProfileRepository) UpdateOTP(ctx context.Context, phoneNumber string, otp string) error {
+	return pr.update(phoneNumber, func(profile *models.Profile) error {
+		profile.Otp = otp
+		profile.OtpExpiresAt = otpExpiry(pr.options.OtpTTL)
+		return nil
+	})
+}
+
+func (pr *inMemoryProfileRepository) SetOTPVerified(ctx context.Context, phoneNumber string, otp string) error {
+	return pr.update(phoneNumber, func(profile *models.Profile) error {
+		if err := otpConflict(profile, otp, false); err != nil {
+			return err
+		}
+		profile.IsVerified = true
+		return nil
+	})
+}
+
+func (pr *inMemoryProfileRepository) MatchOTP(ctx context.Context, phoneNumber string, otp string) error {
+	profile, err := pr.Get(ctx, phoneNumber)
+	if err != nil {
+		return err
+	}
+	return otpConflict(profile, otp, true)
+}
+
+// update applies fn to the profile of phoneNumber, keeping it unchanged when fn fails.
+func (pr *inMemoryProfileRepository) update(phoneNumber string, fn func(profile *models.Profile) error) error {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	profile, exists := pr.profiles[phoneNumber]
+	if !exists {
+		return ErrProfileNotFound
+	}
+
+	if err := fn(&profile); err != nil {
+		return err
+	}
+	profile.UpdatedAt = time.Now().UTC()
+	pr.profiles[phoneNumber] = profile
+	return nil
+}
+
+func (er *inMemoryEventRepository) Create(ctx context.Context, event *models.Event) (string, error) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	event.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	event.UpdatedAt = event.CreatedAt
+
+	if er.options.HashChain {
+		if er.options.ChainKey == nil {
+			return "", ErrCreateEventFailed
+		}
+
+		event.Stream = eventStream(er.options.PII, event)
+		head := er.heads[event.Stream]
+		event.Sequence, event.PrevHash = head.Sequence+1, head.Hash
+		event.Hash = er.options.ChainKey.eventHash(event)
+		er.heads[event.Stream] = *er.options.ChainKey.newHead(event.Stream, event.Sequence, event.Hash)
+	}
+
+	er.events = append(er.events, copyEvent(event))
+	return event.ID, nil
+}
+
+func (er *inMemoryEventRepository) PhoneNumberStream(phoneNumber string) string {
+	return phoneNumberStream(er.options.PII, phoneNumber)
+}
+
+func (er *inMemoryEventRepository) List(ctx context.Context, filter EventFilter, after *EventCursor, limit int) ([]models.Event, error) {
+	events := er.matching(filter)
+	sort.Slice(events, func(i, j int) bool { return eventBefore(&events[j], &events[i]) })
+
+	var page []models.Event
+	for _, event := range events {
+		if len(page) == limit {
+			break
+		}
+		if after != nil && !after.precedes(&event) {
+			continue
+		}
+		page = append(page, event)
+	}
+	return page, nil
+}
+
+func (er *inMemoryEventRepository) ForEach(ctx context.Context, filter EventFilter, fn func(event *models.Event) error) error {
+	events := er.matching(filter)
+	sort.Slice(events, func(i, j int) bool { return eventBefore(&events[i], &events[j]) })
+
+	for i := range events {
+		if err := fn(&events[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (er *inMemoryEventRepository) VerifyChain(ctx context.Context, stream string) (*ChainReport, error) {
+	if er.options.ChainKey == nil {
+		return nil, ErrNoChainKey
+	}
+
+	er.mu.RLock()
+	defer er.mu.RUnlock()
+
+	byStream := make(map[string][]models.Event)
+	for s := range er.heads {
+		byStream[s] = nil
+	}
+	for _, tombstone := range er.tombstones {
+		if _, ok := byStream[tombstone.Stream]; !ok {
+			byStream[tombstone.Stream] = nil
+		}
+	}
+	for _, event := range er.events {
+		if event.Stream != "" {
+			byStream[event.Stream] = append(byStream[event.Stream], copyEvent(&event))
+		}
+	}
+
+	streams := []string{stream}
+	if stream == "" {
+		streams = make([]string, 0, len(byStream))
+		for s := range byStream {
+			streams = append(streams, s)
+		}
+		sort.Strings(streams)
+	}
+
+	report := &ChainReport{}
+	for _, s := range streams {
+		report.StreamsChecked++
+
+		events := byStream[s]
+		sort.Slice(events, func(i, j int) bool { return events[i].Sequence < events[j].Sequence })
+
+		walk := &chainWalk{key: er.options.ChainKey, tombstones: func(after, before int64) ([]models.EventTombstone, error) {
+			return er.tombstonesBetween(s, after, before), nil
+		}}
+		for i := range events {
+			report.EventsChecked++
+
+			if reason, _ := walk.next(&events[i]); reason != "" {
+				report.BrokenLink = &BrokenLink{Stream: s, EventID: events[i].ID, Sequence: events[i].Sequence, Reason: reason}
+				return report, nil
+			}
+		}
+
+		var head *models.EventChainHead
+		if h, ok := er.heads[s]; ok {
+			head = &h
+		}
+		if reason, _ := walk.end(head); reason != "" {
+			report.BrokenLink = &BrokenLink{Stream: s, Sequence: walk.link.sequence, Reason: reason}
+			return report, nil
+		}
+	}
+	return report, nil
+}
+
+func (er *inMemoryEventRepository) tombstonesBetween(stream string, after, before int64) []models.EventTombstone {
+	var tombstones []models.EventTombstone
+	for _, tombstone := range er.tombstones {
+		if tombstone.Stream == stream && tombstone.Sequence > after && tombstone.Sequence < before {
+			tombstones = append(tombstones, tombstone)
+		}
+	}
+	sort.Slice(tombstones, func(i, j int) bool { return tombstones[i].Sequence < tombstones[j].Sequence })
+	return tombstones
+}
+
+func (er *inMemoryEventRepository) CountExpired(ctx context.Context, rule RetentionRule) (int64, error) {
+	er.mu.RLock()
+	defer er.mu.RUnlock()
+
+	var count int64
+	for i := range er.events {
+		if rule.matches(&er.events[i]) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (er *inMemoryEventRepository) PurgeExpired(ctx context.Context, rule RetentionRule, limit int, archive func(events []models.Event) error) (int, error) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+
+	var batch []models.Event
+	for i := range er.events {
+		if rule.matches(&er.events[i]) {
+			batch = append(batch, copyEvent(&er.events[i]))
+		}
+	}
+	sort.Slice(batch, func(i, j int) bool { return eventBefore(&batch[i], &batch[j]) })
+	if len(batch) > limit {
+		batch = batch[:limit]
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	if archive != nil {
+		if err := archive(batch); err != nil {
+			return 0, ErrPurgeEventsFailed
+		}
+	}
+
+	purged := make(map[string]bool, len(batch))
+	var tombstones []models.EventTombstone
+	for i := range batch {
+		event := &batch[i]
+		purged[event.ID] = true
+		if event.Hash != "" {
+			if er.options.ChainKey == nil {
+				return 0, ErrNoChainKey
+			}
+			tombstones = append(tombstones, er.options.ChainKey.newTombstone(event, rule.Name, time.Now()))
+		}
+	}
+	er.tombstones = append(er.tombstones, tombstones...)
+
+	kept := er.events[:0]
+	for _, event := range er.events {
+		if !purged[event.ID] {
+			kept = append(kept, event)
+		}
+	}
+	er.events = kept
+	return len(batch), nil
+}
+
+// matching returns copies of the events that pass filter.
+func (er *inMemoryEventRepository) matching(filter EventFilter) []models.Event {
+	er.mu.RLock()
+	defer er.mu.RUnlock()
+
+	var events []models.Event
+	for i := range er.events {
+		if filter.matches(&er.events[i]) {
+			events = append(events, copyEvent(&er.events[i]))
+		}
+	}
+	return events
+}
+
+func (filter EventFilter) matches(event *models.Event) bool {
+	switch {
+	case filter.ProfileID != "" && event.ProfileID != filter.ProfileID,
+		filter.PhoneNumber != "" && event.PhoneNumber != filter.PhoneNumber,
+		len(filter.EventTypes) > 0 && !contains(filter.EventTypes, event.EventType),
+		!filter.From.IsZero() && event.CreatedAt.Before(filter.From),
+		!filter.To.IsZero() && !event.CreatedAt.Before(filter.To):
+		return false
+	}
+	return true
+}
+
+func (rule RetentionRule) matches(event *models.Event) bool {
+	if !event.CreatedAt.Before(rule.Before) {
+		return false
+	}
+	if len(rule.EventTypes) > 0 {
+		return contains(rule.EventTypes, event.EventType)
+	}
+	return !contains(rule.ExcludeTypes, event.EventType)
+}
+
+// precedes reports whether event comes after cursor in the newest first order of List.
+func (cursor *EventCursor) precedes(event *models.Event) bool {
+	if !event.CreatedAt.Equal(cursor.CreatedAt) {
+		return event.CreatedAt.Before(cursor.CreatedAt)
+	}
+	return event.ID < cursor.ID
+}
+
+// eventBefore orders events by (created_at, id), the order the database indexes use.
+func eventBefore(a, b *models.Event) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+func copyEvent(event *models.Event) models.Event {
+	c := *event
+	c.Metadata = maps.Clone(event.Metadata)
+	return c
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (dr *inMemoryDataExportRepository) Create(ctx context.Context, export *models.DataExport) (string, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	if export.ID == "" {
+		export.ID = uuid.New().String()
+	}
+	export.Status = models.DataExportPending
+	export.CreatedAt = time.Now().UTC()
+	dr.exports[export.ID] = *export
+	return export.ID, nil
+}
+
+func (dr *inMemoryDataExportRepository) Get(ctx context.Context, id string) (*models.DataExport, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	export, exists := dr.exports[id]
+	if !exists || (export.ExpiresAt != nil && !export.ExpiresAt.After(time.Now())) {
+		return nil, ErrDataExportNotFound
+	}
+	return &export, nil
+}
+
+func (dr *inMemoryDataExportRepository) FindActive(ctx context.Context, profileID string) (*models.DataExport, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	var active *models.DataExport
+	for _, export := range dr.exports {
+		if export.ProfileID != profileID || (export.Status != models.DataExportPending && export.Status != models.DataExportRunning) {
+			continue
+		}
+		if active == nil || export.CreatedAt.After(active.CreatedAt) {
+			active = &export
+		}
+	}
+	if active == nil {
+		return nil, ErrDataExportNotFound
+	}
+	return active, nil
+}
+
+func (dr *inMemoryDataExportRepository) Claim(ctx context.Context, staleAfter time.Duration) (*models.DataExport, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	now := time.Now().UTC()
+	var claimed *models.DataExport
+	for _, export := range dr.exports {
+		stale := export.Status == models.DataExportRunning && export.StartedAt.Before(now.Add(-staleAfter))
+		if export.Status != models.DataExportPending && !stale {
+			continue
+		}
+		if claimed == nil || export.CreatedAt.Before(claimed.CreatedAt) {
+			claimed = &export
+		}
+	}
+	if claimed == nil {
+		return nil, nil
+	}
+
+	claimed.Status, claimed.StartedAt = models.DataExportRunning, &now
+	dr.exports[claimed.ID] = *claimed
+	delete(dr.chunks, claimed.ID)
+	return claimed, nil
+}
+
+func (dr *inMemoryDataExportRepository) AppendChunk(ctx context.Context, id string, sequence int, data []byte) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	if sequence != len(dr.chunks[id])+1 {
+		return ErrUpdateDataExportFailed
+	}
+	dr.chunks[id] = append(dr.chunks[id], append([]byte(nil), data...))
+	return nil
+}
+
+func (dr *inMemoryDataExportRepository) Complete(ctx context.Context, id string, size int64, expiresAt time.Time) error {
+	return dr.finish(id, expiresAt, func(export *models.DataExport) {
+		export.Status, export.ArchiveSize = models.DataExportDone, size
+	})
+}
+
+func (dr *inMemoryDataExportRepository) Fail(ctx context.Context, id string, reason string, expiresAt time.Time) error {
+	return dr.finish(id, expiresAt, func(export *models.DataExport) {
+		export.Status, export.Error = models.DataExportFailed, reason
+		delete(dr.chunks, id)
+	})
+}
+
+func (dr *inMemoryDataExportRepository) ReadChunks(ctx context.Context, id string, fn func(data []byte) error) error {
+	dr.mu.Lock()
+	chunks := dr.chunks[id]
+	dr.mu.Unlock()
+
+	for _, chunk := range chunks {
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dr *inMemoryDataExportRepository) finish(id string, expiresAt time.Time, fn func(export *models.DataExport)) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	export, exists := dr.exports[id]
+	if !exists || export.Status != models.DataExportRunning {
+		return ErrDataExportNotFound
+	}
+
+	now := time.Now().UTC()
+	fn(&export)
+	export.CompletedAt, export.ExpiresAt = &now, &expiresAt
+	dr.exports[id] = export
+	return nil
+}
+
+func (dr *inMemoryDataExportRepository) PurgeExpired(ctx context.Context) (int64, error) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+
+	var purged int64
+	for id, export := range dr.exports {
+		if export.ExpiresAt != nil && !export.ExpiresAt.After(time.Now()) {
+			delete(dr.exports, id)
+			delete(dr.chunks, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Do runs fn straight away, the in-memory repositories apply every call immediately
+// and cannot roll back.
+func (uow inMemoryUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// NewInMemoryProfileRepository keeps profiles in a map, for tests and local runs.
+func NewInMemoryProfileRepository(options ProfileRepoOptions) ProfileRepo {
+	return &inMemoryProfileRepository{options: options, profiles: make(map[string]models.Profile)}
+}
+
+// NewInMemoryEventRepository keeps events in a slice, for tests and local runs.
+func NewInMemoryEventRepository(options EventRepoOptions) EventRepo {
+	return &inMemoryEventRepository{options: options, heads: make(map[string]models.EventChainHead)}
+}
+
+// NewInMemoryDataExportRepository keeps data exports in a map, for tests and local runs.
+func NewInMemoryDataExportRepository() DataExportRepo {
+	return &inMemoryDataExportRepository{exports: make(map[string]models.DataExport), chunks: make(map[string][][]byte)}
+}
+
+func NewInMemoryUnitOfWork() UnitOfWork {
+	return inMemoryUnitOfWork{}
+}
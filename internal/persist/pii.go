@@ -0,0 +1,248 @@
+package persist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/ilivestrong/auth-service/internal/pii"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	piiRotateBatchSize = 500
+)
+
+var (
+	ErrNoPIIKeys         = errors.New("no pii keys configured")
+	ErrRotatePIIFailed   = errors.New("failed to rotate pii encryption")
+	ErrCountPIIFailed    = errors.New("failed to count unencrypted rows")
+	errUnexpectedPIIType = errors.New("pii serializer only supports string fields")
+)
+
+type (
+	// PIIRotation counts the rows RotatePII re-encrypted.
+	PIIRotation struct {
+		Profiles      int
+		Events        int
+		DataExports   int
+		ArchiveChunks int
+	}
+
+	// piiSerializer stores string fields encrypted by the keyring of the query context,
+	// see withPII, with the table and column name as additional data so values cannot be
+	// moved to other columns.
+	piiSerializer struct{}
+
+	piiKey struct{}
+
+	piiTable struct {
+		name    string
+		columns []string
+		// index is the blind index column of the first column, if any.
+		index string
+	}
+)
+
+var (
+	profilesPII = piiTable{name: "profiles", columns: []string{"phone_number", "name"}, index: "phone_number_index"}
+	eventsPII   = piiTable{name: "events", columns: []string{"phone_number"}, index: "phone_number_index"}
+	exportsPII  = piiTable{name: "data_exports", columns: []string{"phone_number"}}
+	chunksPII   = piiTable{name: "data_export_chunks", columns: []string{"data"}}
+)
+
+func init() {
+	schema.RegisterSerializer("pii", piiSerializer{})
+}
+
+func (piiSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("%w: cannot scan %T", errUnexpectedPIIType, dbValue)
+	}
+
+	keyring, _ := ctx.Value(piiKey{}).(*pii.Keyring)
+	if keyring == nil {
+		return ErrNoPIIKeys
+	}
+	plaintext, err := keyring.Decrypt(value, piiAAD(field.Schema.Table, field.DBName))
+	if err != nil {
+		return err
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+func (piiSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, errUnexpectedPIIType
+	}
+
+	keyring, _ := ctx.Value(piiKey{}).(*pii.Keyring)
+	if keyring == nil {
+		return nil, ErrNoPIIKeys
+	}
+	return keyring.Encrypt(value, piiAAD(field.Schema.Table, field.DBName))
+}
+
+// withPII makes the queries run with the returned context encrypt and decrypt the
+// columns tagged with serializer:pii with keyring.
+func withPII(ctx context.Context, keyring *pii.Keyring) context.Context {
+	if keyring == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, piiKey{}, keyring)
+}
+
+func piiAAD(table, column string) string {
+	return table + "." + column
+}
+
+// phoneNumberIndex is the blind index stored and searched in place of a phone number.
+// Without a keyring, as with the in-memory repositories, it is the phone number itself.
+func phoneNumberIndex(keyring *pii.Keyring, phoneNumber string) string {
+	if keyring == nil {
+		return phoneNumber
+	}
+	return keyring.BlindIndex(phoneNumber)
+}
+
+// UnindexedProfiles counts the profiles written before encryption, which cannot be found
+// by phone number until RotatePII encrypted them.
+func UnindexedProfiles(ctx context.Context, db *gorm.DB) (int64, error) {
+	db, cancel := conn(ctx, db, 0)
+	defer cancel()
+
+	var count int64
+	if err := db.Table("profiles").Where("phone_number_index IS NULL").Count(&count).Error; err != nil {
+		return 0, ErrCountPIIFailed
+	}
+	return count, nil
+}
+
+// RotatePII encrypts the rows still holding plaintext with keyring and fills their blind
+// indexes, and re-wraps the data keys of rows encrypted with an older key version, in
+// batches. Soft deleted rows are included.
+func RotatePII(ctx context.Context, db *gorm.DB, keyring *pii.Keyring) (*PIIRotation, error) {
+	if keyring == nil {
+		return &PIIRotation{}, ErrNoPIIKeys
+	}
+
+	var (
+		rotation = &PIIRotation{}
+		err      error
+	)
+	if rotation.Profiles, err = rotateTable(ctx, db, keyring, profilesPII); err != nil {
+		return rotation, err
+	}
+	if rotation.Events, err = rotateTable(ctx, db, keyring, eventsPII); err != nil {
+		return rotation, err
+	}
+	if rotation.DataExports, err = rotateTable(ctx, db, keyring, exportsPII); err != nil {
+		return rotation, err
+	}
+	rotation.ArchiveChunks, err = rotateTable(ctx, db, keyring, chunksPII)
+	return rotation, err
+}
+
+func rotateTable(ctx context.Context, db *gorm.DB, keyring *pii.Keyring, table piiTable) (int, error) {
+	var (
+		rotated int
+		afterID string
+	)
+	for {
+		batch, err := piiBatchAfter(ctx, db, keyring, table, afterID)
+		if err != nil {
+			return rotated, err
+		}
+
+		for _, row := range batch {
+			afterID = columnString(row["id"])
+			if err := rotateRow(ctx, db, keyring, table, row); err != nil {
+				return rotated, fmt.Errorf("%w: %s %s, %v", ErrRotatePIIFailed, table.name, afterID, err)
+			}
+			rotated++
+		}
+
+		if len(batch) < piiRotateBatchSize {
+			return rotated, nil
+		}
+	}
+}
+
+// piiBatchAfter loads the next rows of table, by id, that are unindexed or have a
+// column not encrypted with the current key.
+func piiBatchAfter(ctx context.Context, db *gorm.DB, keyring *pii.Keyring, table piiTable, afterID string) ([]map[string]any, error) {
+	db, cancel := conn(ctx, db, 0)
+	defer cancel()
+
+	current := keyring.CurrentPrefix() + "%"
+	stale := db
+	if table.index != "" {
+		stale = stale.Or(table.index + " IS NULL")
+	}
+	for _, column := range table.columns {
+		stale = stale.Or(fmt.Sprintf("(%s <> '' AND %s NOT LIKE ?)", column, column), current)
+	}
+
+	var batch []map[string]any
+	result := db.Table(table.name).
+		Select(append([]string{"id"}, table.columns...)).
+		Where("id > ?", afterID).
+		Where(stale).
+		Order("id ASC").
+		Limit(piiRotateBatchSize).
+		Find(&batch)
+	if result.Error != nil {
+		return nil, ErrRotatePIIFailed
+	}
+	return batch, nil
+}
+
+func rotateRow(ctx context.Context, db *gorm.DB, keyring *pii.Keyring, table piiTable, row map[string]any) error {
+	updates := make(map[string]any, len(table.columns)+1)
+	for i, column := range table.columns {
+		value := columnString(row[column])
+		aad := piiAAD(table.name, column)
+
+		plaintext, err := keyring.Decrypt(value, aad)
+		if err != nil {
+			return err
+		}
+		if i == 0 && table.index != "" {
+			updates[table.index] = keyring.BlindIndex(plaintext)
+		}
+
+		if pii.IsEncrypted(value) {
+			value, err = keyring.Rewrap(value)
+		} else {
+			value, err = keyring.Encrypt(value, aad)
+		}
+		if err != nil {
+			return err
+		}
+		updates[column] = value
+	}
+
+	db, cancel := conn(ctx, db, 0)
+	defer cancel()
+	return db.Table(table.name).Where("id = ?", row["id"]).UpdateColumns(updates).Error
+}
+
+func columnString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
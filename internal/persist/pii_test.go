@@ -0,0 +1,109 @@
+package persist_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ilivestrong/auth-service/internal/persist"
+	"github.com/ilivestrong/auth-service/internal/pii"
+	"gorm.io/gorm"
+)
+
+func TestRotatePIIEncryptsPlaintextRows(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	// written before phone numbers were encrypted
+	exec(t, db, "INSERT INTO profiles (id, created_at, updated_at, name, phone_number, otp, is_verified) VALUES ('p1', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'Jane', '"+testPhoneNumber+"', '', false)")
+
+	repo := persist.NewProfileRepository(db, persist.ProfileRepoOptions{PII: testKeyring})
+	if _, err := repo.Get(ctx, testPhoneNumber); !errors.Is(err, persist.ErrProfileNotFound) {
+		t.Fatalf("got %v, want %v before rotating", err, persist.ErrProfileNotFound)
+	}
+	if unindexed, err := persist.UnindexedProfiles(ctx, db); err != nil || unindexed != 1 {
+		t.Fatalf("got %d unindexed profiles, %v, want 1", unindexed, err)
+	}
+
+	rotation, err := persist.RotatePII(ctx, db, testKeyring)
+	if err != nil || rotation.Profiles != 1 {
+		t.Fatalf("got %+v, %v, want 1 profile rotated", rotation, err)
+	}
+	if unindexed, err := persist.UnindexedProfiles(ctx, db); err != nil || unindexed != 0 {
+		t.Fatalf("got %d unindexed profiles after rotating, %v", unindexed, err)
+	}
+	for _, column := range []string{"name", "phone_number"} {
+		if stored := storedColumn(t, db, column); !strings.HasPrefix(stored, "pii:1:") {
+			t.Errorf("%s is stored as %q, want it encrypted", column, stored)
+		}
+	}
+
+	profile, err := repo.Get(ctx, testPhoneNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "Jane" || profile.PhoneNumber != testPhoneNumber {
+		t.Fatalf("got %s %s, want the profile back decrypted", profile.Name, profile.PhoneNumber)
+	}
+}
+
+func TestRotatePIIRewrapsOlderKeys(t *testing.T) {
+	ctx := context.Background()
+	db := openTestDB(t)
+	if _, err := persist.NewProfileRepository(db, persist.ProfileRepoOptions{PII: testKeyring}).Create(ctx, testPhoneNumber, "Jane"); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := keyring(t, "1:"+testKey(0)+",2:"+testKey(2)+",index:"+testKey(0))
+	rotation, err := persist.RotatePII(ctx, db, rotated)
+	if err != nil || rotation.Profiles != 1 {
+		t.Fatalf("got %+v, %v, want 1 profile rotated", rotation, err)
+	}
+	if stored := storedColumn(t, db, "name"); !strings.HasPrefix(stored, "pii:2:") {
+		t.Fatalf("name is stored as %q, want it wrapped with key 2", stored)
+	}
+	if rotation, err := persist.RotatePII(ctx, db, rotated); err != nil || rotation.Profiles != 0 {
+		t.Fatalf("got %+v, %v, want nothing left to rotate", rotation, err)
+	}
+
+	// key 1 can be dropped now
+	repo := persist.NewProfileRepository(db, persist.ProfileRepoOptions{PII: keyring(t, "2:"+testKey(2)+",index:"+testKey(0))})
+	if profile, err := repo.Get(ctx, testPhoneNumber); err != nil || profile.Name != "Jane" {
+		t.Fatalf("got %+v, %v, want the profile readable without key 1", profile, err)
+	}
+}
+
+func TestRepositoriesNeedPIIKeys(t *testing.T) {
+	repo := persist.NewProfileRepository(openTestDB(t), persist.ProfileRepoOptions{})
+	if _, err := repo.Create(context.Background(), testPhoneNumber, "Jane"); err == nil {
+		t.Fatal("profile was stored without pii keys")
+	}
+}
+
+// storedColumn returns column of the only profile as stored.
+func storedColumn(t *testing.T, db *gorm.DB, column string) string {
+	t.Helper()
+
+	var value string
+	if err := db.Raw("SELECT " + column + " FROM profiles").Scan(&value).Error; err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func keyring(t *testing.T, keys string) *pii.Keyring {
+	t.Helper()
+
+	k, err := pii.ParseKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// testKey is a base64 encoded key with every byte set to b.
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
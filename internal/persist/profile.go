@@ -1,11 +1,14 @@
 package persist
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/ilivestrong/auth-service/internal/models"
-	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/ilivestrong/auth-service/internal/pii"
 	"gorm.io/gorm"
 )
 
@@ -15,84 +18,195 @@ var (
 	ErrGetProfileFailed     = errors.New("failed to get profile")
 	ErrProfileNotFound      = errors.New("profile not found")
 	ErrProfileAlreadyExists = errors.New("profile with this phone number already exists")
-)
 
-const (
-	PGDuplicateKeyErrorCode = "23505"
+	// ErrProfileConflict is wrapped by the errors of conditional updates that found the
+	// profile in a state that does not allow the update.
+	ErrProfileConflict = errors.New("profile is not in the expected state")
+	ErrAlreadyVerified = fmt.Errorf("%w: phone number is already verified", ErrProfileConflict)
+	ErrNotVerified     = fmt.Errorf("%w: phone number is not verified", ErrProfileConflict)
+	ErrOtpMismatch     = fmt.Errorf("%w: otp does not match", ErrProfileConflict)
+	ErrOtpExpired      = fmt.Errorf("%w: otp has expired", ErrProfileConflict)
 )
 
 type (
 	ProfileRepo interface {
-		Create(phoneNumber string, name string) (string, error)
-		Get(phoneNumber string) (*models.Profile, error)
-		UpdateOTP(phone_number string, otp string) error
-		SetOTPVerified(phone_number string) error
+		Create(ctx context.Context, phoneNumber string, name string) (string, error)
+		Get(ctx context.Context, phoneNumber string) (*models.Profile, error)
+		UpdateOTP(ctx context.Context, phone_number string, otp string) error
+		// SetOTPVerified marks the profile verified if otp is its current, unexpired OTP.
+		SetOTPVerified(ctx context.Context, phone_number string, otp string) error
+		// MatchOTP checks otp is the current, unexpired OTP of the verified profile.
+		MatchOTP(ctx context.Context, phone_number string, otp string) error
 	}
 	profileRepository struct {
-		db *gorm.DB
+		db      *gorm.DB
+		options ProfileRepoOptions
+	}
+
+	ProfileRepoOptions struct {
+		// OtpTTL is how long an OTP can be used to verify a phone number and log in, zero
+		// means forever.
+		OtpTTL time.Duration
+		// PII encrypts the phone number and name, and keys the phone number index.
+		PII *pii.Keyring
+		// Replicas, when set, serves Get from read replicas.
+		Replicas *Replicas
+		// QueryTimeout bounds every call whose context has no earlier deadline, zero means
+		// five seconds.
+		QueryTimeout time.Duration
 	}
 )
 
-func (pr *profileRepository) Create(phone_number string, name string) (string, error) {
+func (pr *profileRepository) Create(ctx context.Context, phone_number string, name string) (string, error) {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
 	newProfile := &models.Profile{
-		ID:          uuid.New().String(),
-		Name:        name,
-		PhoneNumber: phone_number,
-		IsVerified:  false,
+		ID:               uuid.New().String(),
+		Name:             name,
+		PhoneNumber:      phone_number,
+		PhoneNumberIndex: phoneNumberIndex(pr.options.PII, phone_number),
+		IsVerified:       false,
 	}
-	result := pr.db.Create(newProfile)
+	result := db.Create(newProfile)
 
 	if result.Error != nil || result.RowsAffected == 0 {
 
-		if pgErr, ok := result.Error.(*pgconn.PgError); ok && pgErr.Code == PGDuplicateKeyErrorCode {
+		if isDuplicateKeyError(db, result.Error) {
 			return "", ErrProfileAlreadyExists
 		}
 		return "", ErrCreateProfileFailed
 	}
 
+	pr.options.Replicas.wrote(phone_number)
 	return newProfile.ID, nil
 }
 
-func (pr *profileRepository) Get(phone_number string) (*models.Profile, error) {
+func (pr *profileRepository) Get(ctx context.Context, phone_number string) (*models.Profile, error) {
 	var profile models.Profile
-	result := pr.db.Where("phone_number = ?", phone_number).First(&profile)
+	err := pr.options.Replicas.read(withPII(ctx, pr.options.PII), pr.db, phone_number, pr.options.QueryTimeout, func(db *gorm.DB) error {
+		return db.Where("phone_number_index = ?", phoneNumberIndex(pr.options.PII, phone_number)).First(&profile).Error
+	})
 
-	if result.Error != nil {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProfileNotFound
+	}
+	if err != nil {
 		return nil, ErrGetProfileFailed
 	}
 	return &profile, nil
 }
 
-func (pr *profileRepository) UpdateOTP(phone_number string, otp string) error {
-	profile, err := pr.Get(phone_number)
-	if err != nil {
-		return err
-	}
+// UpdateOTP only touches the OTP columns, so it cannot undo a verification running at
+// the same time.
+func (pr *profileRepository) UpdateOTP(ctx context.Context, phone_number string, otp string) error {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
 
-	profile.Otp = otp
-	result := pr.db.Save(profile)
+	result := db.Model(&models.Profile{}).
+		Where("phone_number_index = ?", phoneNumberIndex(pr.options.PII, phone_number)).
+		Updates(map[string]any{"otp": otp, "otp_expires_at": otpExpiry(pr.options.OtpTTL)})
 
-	if result.Error != nil || result.RowsAffected == 0 {
+	if result.Error != nil {
 		return ErrUpdateProfileFailed
 	}
+	if result.RowsAffected == 0 {
+		return ErrProfileNotFound
+	}
+	pr.options.Replicas.wrote(phone_number)
 	return nil
 }
 
-func (pr *profileRepository) SetOTPVerified(phone_number string) error {
-	profile, err := pr.Get(phone_number)
+func (pr *profileRepository) SetOTPVerified(ctx context.Context, phone_number string, otp string) error {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	result := currentOtp(db.Model(&models.Profile{}), pr.options.PII, phone_number, otp, false).
+		Update("is_verified", true)
+
+	if result.Error != nil {
+		return ErrUpdateProfileFailed
+	}
+	if result.RowsAffected == 1 {
+		pr.options.Replicas.wrote(phone_number)
+		return nil
+	}
+	return pr.otpConflict(ctx, phone_number, otp, false)
+}
+
+func (pr *profileRepository) MatchOTP(ctx context.Context, phone_number string, otp string) error {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	var matched int64
+	if err := currentOtp(db.Model(&models.Profile{}), pr.options.PII, phone_number, otp, true).Count(&matched).Error; err != nil {
+		return ErrGetProfileFailed
+	}
+	if matched == 1 {
+		return nil
+	}
+	return pr.otpConflict(ctx, phone_number, otp, true)
+}
+
+// otpConflict tells why nothing matched otp of the profile with the given verification state.
+func (pr *profileRepository) otpConflict(ctx context.Context, phone_number string, otp string, verified bool) error {
+	profile, err := pr.Get(ReadPrimary(ctx), phone_number)
 	if err != nil {
 		return err
 	}
+	if err := otpConflict(profile, otp, verified); err != nil {
+		return err
+	}
+	// the profile changed between the query and the read
+	return ErrProfileConflict
+}
+
+// currentOtp narrows db to the profile of phone_number in the given verification state, if
+// otp is its current, unexpired OTP. Verifying and logging in check the OTP the same way.
+func currentOtp(db *gorm.DB, keyring *pii.Keyring, phone_number string, otp string, verified bool) *gorm.DB {
+	return db.
+		Where("phone_number_index = ? AND is_verified = ? AND otp <> '' AND otp = ?", phoneNumberIndex(keyring, phone_number), verified, otp).
+		Where("otp_expires_at IS NULL OR otp_expires_at > ?", time.Now().UTC())
+}
 
-	profile.IsVerified = true
-	result := pr.db.Save(profile)
+// isDuplicateKeyError reports unique constraint violations, whichever driver db uses.
+func isDuplicateKeyError(db *gorm.DB, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		return errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey)
+	}
+	return false
+}
 
-	if result.Error != nil || result.RowsAffected == 0 {
-		return ErrUpdateProfileFailed
+// otpConflict tells why otp does not match profile in the given verification state, nil when
+// it does.
+func otpConflict(profile *models.Profile, otp string, verified bool) error {
+	switch {
+	case profile.IsVerified && !verified:
+		return ErrAlreadyVerified
+	case !profile.IsVerified && verified:
+		return ErrNotVerified
+	case profile.Otp == "" || profile.Otp != otp:
+		return ErrOtpMismatch
+	case profile.OtpExpiresAt != nil && !profile.OtpExpiresAt.After(time.Now()):
+		return ErrOtpExpired
 	}
 	return nil
 }
 
-func NewProfileRepository(db *gorm.DB) ProfileRepo {
-	return &profileRepository{db}
+func otpExpiry(ttl time.Duration) *time.Time {
+	if ttl <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	return &expiresAt
+}
+
+func NewProfileRepository(db *gorm.DB, options ProfileRepoOptions) ProfileRepo {
+	return &tracedProfileRepository{&profileRepository{db, options}, dbSystem(db)}
 }
@@ -0,0 +1,221 @@
+package persist_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/migrate"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	"github.com/ilivestrong/auth-service/internal/pii"
+	"gorm.io/gorm"
+)
+
+const testPhoneNumber = "+911234567890"
+
+// testKeyring encrypts the personal data of the SQLite repositories under test.
+var testKeyring, _ = pii.ParseKeys("1:" + base64.StdEncoding.EncodeToString(make([]byte, 32)) + ",index:" + base64.StdEncoding.EncodeToString(make([]byte, 32)))
+
+// profileRepos returns every ProfileRepo implementation, the SQLite one on a migrated
+// database in a temporary directory.
+func profileRepos(t *testing.T, options persist.ProfileRepoOptions) map[string]persist.ProfileRepo {
+	t.Helper()
+
+	db := openTestDB(t)
+	options.PII = testKeyring
+	return map[string]persist.ProfileRepo{
+		"sqlite": persist.NewProfileRepository(db, options),
+		"memory": persist.NewInMemoryProfileRepository(options),
+	}
+}
+
+// openTestDB opens a migrated SQLite database in a temporary directory.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := persist.OpenSQLite(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	migrator, err := migrate.NewMigrator(db, migrate.DialectSQLite)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	if _, err := migrator.Up(context.Background(), 0); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return db
+}
+
+func TestConcurrentOtpUpdatesKeepVerification(t *testing.T) {
+	for name, repo := range profileRepos(t, persist.ProfileRepoOptions{}) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, err := repo.Create(ctx, testPhoneNumber, "Jane"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.UpdateOTP(ctx, testPhoneNumber, "111111"); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			verifyErr := make(chan error, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				verifyErr <- repo.SetOTPVerified(ctx, testPhoneNumber, "111111")
+			}()
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := repo.UpdateOTP(ctx, testPhoneNumber, "111111"); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if err := <-verifyErr; err != nil {
+				t.Fatalf("verification failed: %v", err)
+			}
+			profile, err := repo.Get(ctx, testPhoneNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !profile.IsVerified {
+				t.Fatal("a concurrent otp update reverted the verification")
+			}
+		})
+	}
+}
+
+func TestConcurrentVerificationSucceedsOnce(t *testing.T) {
+	for name, repo := range profileRepos(t, persist.ProfileRepoOptions{}) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if _, err := repo.Create(ctx, testPhoneNumber, "Jane"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.UpdateOTP(ctx, testPhoneNumber, "111111"); err != nil {
+				t.Fatal(err)
+			}
+
+			const attempts = 20
+			errs := make(chan error, attempts)
+			var wg sync.WaitGroup
+			for i := 0; i < attempts; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- repo.SetOTPVerified(ctx, testPhoneNumber, "111111")
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			var verified int
+			for err := range errs {
+				switch {
+				case err == nil:
+					verified++
+				case !errors.Is(err, persist.ErrAlreadyVerified):
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+			if verified != 1 {
+				t.Fatalf("%d verifications succeeded, want exactly 1", verified)
+			}
+		})
+	}
+}
+
+func TestSetOTPVerifiedErrors(t *testing.T) {
+	for name, repo := range profileRepos(t, persist.ProfileRepoOptions{OtpTTL: 50 * time.Millisecond}) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if err := repo.SetOTPVerified(ctx, testPhoneNumber, "111111"); !errors.Is(err, persist.ErrProfileNotFound) {
+				t.Fatalf("unknown profile: got %v, want %v", err, persist.ErrProfileNotFound)
+			}
+			if err := repo.UpdateOTP(ctx, testPhoneNumber, "111111"); !errors.Is(err, persist.ErrProfileNotFound) {
+				t.Fatalf("otp for unknown profile: got %v, want %v", err, persist.ErrProfileNotFound)
+			}
+
+			if _, err := repo.Create(ctx, testPhoneNumber, "Jane"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetOTPVerified(ctx, testPhoneNumber, ""); !errors.Is(err, persist.ErrOtpMismatch) {
+				t.Fatalf("no otp yet: got %v, want %v", err, persist.ErrOtpMismatch)
+			}
+
+			if err := repo.UpdateOTP(ctx, testPhoneNumber, "111111"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetOTPVerified(ctx, testPhoneNumber, "222222"); !errors.Is(err, persist.ErrOtpMismatch) {
+				t.Fatalf("wrong otp: got %v, want %v", err, persist.ErrOtpMismatch)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			if err := repo.SetOTPVerified(ctx, testPhoneNumber, "111111"); !errors.Is(err, persist.ErrOtpExpired) {
+				t.Fatalf("expired otp: got %v, want %v", err, persist.ErrOtpExpired)
+			}
+			if !errors.Is(persist.ErrOtpExpired, persist.ErrProfileConflict) {
+				t.Fatal("conflict errors must wrap ErrProfileConflict")
+			}
+
+			if err := repo.UpdateOTP(ctx, testPhoneNumber, "333333"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.SetOTPVerified(ctx, testPhoneNumber, "333333"); err != nil {
+				t.Fatalf("fresh otp: %v", err)
+			}
+		})
+	}
+}
+
+func TestMatchOTP(t *testing.T) {
+	for name, repo := range profileRepos(t, persist.ProfileRepoOptions{OtpTTL: 50 * time.Millisecond}) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if err := repo.MatchOTP(ctx, testPhoneNumber, "111111"); !errors.Is(err, persist.ErrProfileNotFound) {
+				t.Fatalf("unknown profile: got %v, want %v", err, persist.ErrProfileNotFound)
+			}
+			if _, err := repo.Create(ctx, testPhoneNumber, "Jane"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.UpdateOTP(ctx, testPhoneNumber, "111111"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.MatchOTP(ctx, testPhoneNumber, "111111"); !errors.Is(err, persist.ErrNotVerified) {
+				t.Fatalf("unverified profile: got %v, want %v", err, persist.ErrNotVerified)
+			}
+
+			if err := repo.SetOTPVerified(ctx, testPhoneNumber, "111111"); err != nil {
+				t.Fatal(err)
+			}
+			if err := repo.MatchOTP(ctx, testPhoneNumber, "111111"); err != nil {
+				t.Fatalf("current otp: %v", err)
+			}
+			if err := repo.MatchOTP(ctx, testPhoneNumber, "222222"); !errors.Is(err, persist.ErrOtpMismatch) {
+				t.Fatalf("wrong otp: got %v, want %v", err, persist.ErrOtpMismatch)
+			}
+
+			time.Sleep(100 * time.Millisecond)
+			if err := repo.MatchOTP(ctx, testPhoneNumber, "111111"); !errors.Is(err, persist.ErrOtpExpired) {
+				t.Fatalf("expired otp: got %v, want %v", err, persist.ErrOtpExpired)
+			}
+		})
+	}
+}
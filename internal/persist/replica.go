@@ -0,0 +1,217 @@
+package persist
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	replicaCheckInterval = 5 * time.Second
+
+	// replicaLagQuery is the replay lag of a PostgreSQL standby in seconds, zero when it
+	// has replayed everything it received.
+	replicaLagQuery = `SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END`
+)
+
+type (
+	// Replicas routes read-only repository calls to read replicas. A phone number whose
+	// profile was written by this process is read from the primary for StickyFor
+	// afterwards, so callers see their own writes, and replicas that lag more than MaxLag
+	// or fail are skipped until the next lag check finds them healthy again. A nil
+	// *Replicas reads from the primary.
+	//
+	// Audit events do not make reads sticky, as nearly every call writes one and would
+	// keep every active user on the primary. Event pages may trail by up to MaxLag, and
+	// an empty page is read again from the primary.
+	//
+	// Writes are only remembered in the memory of this process. Another instance that did
+	// not see the write may still read it from a lagging replica, so deployments with
+	// several instances have to send a user's requests to one instance, e.g. with sticky
+	// sessions at the load balancer, to keep read-your-writes.
+	Replicas struct {
+		options  ReplicaOptions
+		replicas []*replica
+		next     atomic.Uint64
+		lagQuery string
+
+		mu     sync.Mutex
+		writes map[string]time.Time
+	}
+
+	ReplicaOptions struct {
+		MaxLag    time.Duration
+		StickyFor time.Duration
+	}
+
+	replica struct {
+		name    string
+		db      *gorm.DB
+		healthy atomic.Bool
+	}
+
+	primaryKey struct{}
+)
+
+// ReadPrimary makes the repository calls made with the returned context read from the
+// primary, for reads that must see the latest state.
+func ReadPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// Run measures the lag of every replica straight away and then every replicaCheckInterval,
+// until ctx is done.
+func (r *Replicas) Run(ctx context.Context) {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		r.check(ctx)
+		r.forgetWrites()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Replicas) Close() error {
+	var errs []error
+	for _, rep := range r.replicas {
+		if sqlDB, err := rep.db.DB(); err == nil {
+			errs = append(errs, sqlDB.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *Replicas) check(ctx context.Context) {
+	for _, rep := range r.replicas {
+		db, cancel := conn(ctx, rep.db, 0)
+		var lag float64
+		err := db.Raw(r.lagQuery).Scan(&lag).Error
+		cancel()
+
+		lagging := time.Duration(lag*float64(time.Second)) > r.options.MaxLag
+		healthy := err == nil && !lagging
+		if rep.healthy.Swap(healthy) == healthy {
+			continue
+		}
+
+		switch {
+		case healthy:
+			slog.Info("read replica is back in use", "replica", rep.name)
+		case err != nil:
+			slog.Warn("read replica is not used, lag check failed", "replica", rep.name, "error", err)
+		default:
+			slog.Warn("read replica is not used, it lags behind", "replica", rep.name, "lag_seconds", lag)
+		}
+	}
+}
+
+// wrote records that key was just written, its reads go to the primary for StickyFor.
+func (r *Replicas) wrote(key string) {
+	if r == nil || key == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes[key] = time.Now()
+}
+
+func (r *Replicas) forgetWrites() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, at := range r.writes {
+		if time.Since(at) > r.options.StickyFor {
+			delete(r.writes, key)
+		}
+	}
+}
+
+// pick returns the replica to read key from in round robin order, nil when the read has
+// to go to the primary.
+func (r *Replicas) pick(ctx context.Context, key string) *replica {
+	if r == nil || ctx.Value(txKey{}) != nil || ctx.Value(primaryKey{}) != nil {
+		return nil
+	}
+
+	if key != "" {
+		r.mu.Lock()
+		at, written := r.writes[key]
+		r.mu.Unlock()
+		if written && time.Since(at) <= r.options.StickyFor {
+			return nil
+		}
+	}
+
+	start := r.next.Add(1)
+	for i := range r.replicas {
+		rep := r.replicas[(start+uint64(i))%uint64(len(r.replicas))]
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// read runs query on a replica if one can serve key, and on primary otherwise, limited to
+// timeout. A query that fails or finds nothing on a replica runs again on primary, which
+// may be ahead.
+func (r *Replicas) read(ctx context.Context, primary *gorm.DB, key string, timeout time.Duration, query func(db *gorm.DB) error) error {
+	if rep := r.pick(ctx, key); rep != nil {
+		db, cancel := conn(ctx, rep.db, timeout)
+		err := query(db)
+		cancel()
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) && rep.healthy.Swap(false) {
+			slog.WarnContext(ctx, "read replica is not used, query failed", "replica", rep.name, "error", err)
+		}
+	}
+
+	db, cancel := conn(ctx, primary, timeout)
+	defer cancel()
+	return query(db)
+}
+
+// readPage runs query like read, but also reads again from primary when a replica finds
+// no rows, as one lagging behind does for rows just written. query returns the number
+// of rows it found.
+func (r *Replicas) readPage(ctx context.Context, primary *gorm.DB, key string, timeout time.Duration, query func(db *gorm.DB) (int, error)) error {
+	err := r.read(ctx, primary, key, timeout, func(db *gorm.DB) error {
+		found, err := query(db)
+		if err == nil && found == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return err
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// NewReplicas routes reads to the given replica connections, keyed by a name for logs.
+// Replicas are only used once Run found them healthy.
+func NewReplicas(replicas map[string]*gorm.DB, options ReplicaOptions) *Replicas {
+	r := &Replicas{options: options, lagQuery: replicaLagQuery, writes: make(map[string]time.Time)}
+	for name, db := range replicas {
+		r.replicas = append(r.replicas, &replica{name: name, db: db})
+	}
+	return r
+}
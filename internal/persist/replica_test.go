@@ -0,0 +1,188 @@
+package persist_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	"gorm.io/gorm"
+)
+
+// replicaSetup is a primary and a replica database holding the test profile under
+// different names, so a read tells where it was served from.
+type replicaSetup struct {
+	primary  *gorm.DB
+	replica  *gorm.DB
+	replicas *persist.Replicas
+	repo     persist.ProfileRepo
+}
+
+func newReplicaSetup(t *testing.T, options persist.ReplicaOptions) *replicaSetup {
+	t.Helper()
+
+	s := &replicaSetup{primary: openTestDB(t), replica: openTestDB(t)}
+	ctx := context.Background()
+	if _, err := persist.NewProfileRepository(s.primary, persist.ProfileRepoOptions{PII: testKeyring}).Create(ctx, testPhoneNumber, "Primary"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := persist.NewProfileRepository(s.replica, persist.ProfileRepoOptions{PII: testKeyring}).Create(ctx, testPhoneNumber, "Replica"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.replicas = persist.NewReplicas(map[string]*gorm.DB{"replica": s.replica}, options)
+	persist.SetReplicaLagQuery(s.replicas, "SELECT 0")
+	persist.CheckReplicas(s.replicas, ctx)
+	s.repo = persist.NewProfileRepository(s.primary, persist.ProfileRepoOptions{PII: testKeyring, Replicas: s.replicas})
+	return s
+}
+
+// readFrom fails the test unless reading the test profile with ctx is served by want.
+func (s *replicaSetup) readFrom(t *testing.T, ctx context.Context, want string) {
+	t.Helper()
+
+	profile, err := s.repo.Get(ctx, testPhoneNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != want {
+		t.Fatalf("read served by %s, want %s", profile.Name, want)
+	}
+}
+
+func TestReplicasServeReads(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+	s.readFrom(t, context.Background(), "Replica")
+}
+
+func TestReplicasUnusedUntilChecked(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+	unchecked := persist.NewReplicas(map[string]*gorm.DB{"replica": s.replica}, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+	s.repo = persist.NewProfileRepository(s.primary, persist.ProfileRepoOptions{PII: testKeyring, Replicas: unchecked})
+
+	s.readFrom(t, context.Background(), "Primary")
+}
+
+func TestReplicasReadPrimary(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+	s.readFrom(t, persist.ReadPrimary(context.Background()), "Primary")
+
+	uow := persist.NewUnitOfWork(s.primary, 0)
+	err := uow.Do(context.Background(), func(ctx context.Context) error {
+		s.readFrom(t, ctx, "Primary")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicasStickAfterWrite(t *testing.T) {
+	const stickyFor = 200 * time.Millisecond
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second, StickyFor: stickyFor})
+
+	if err := s.repo.UpdateOTP(context.Background(), testPhoneNumber, "111111"); err != nil {
+		t.Fatal(err)
+	}
+	s.readFrom(t, context.Background(), "Primary")
+
+	time.Sleep(stickyFor + 50*time.Millisecond)
+	s.readFrom(t, context.Background(), "Replica")
+}
+
+func TestReplicasSkipLagging(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+
+	persist.SetReplicaLagQuery(s.replicas, "SELECT 30")
+	persist.CheckReplicas(s.replicas, context.Background())
+	s.readFrom(t, context.Background(), "Primary")
+
+	persist.SetReplicaLagQuery(s.replicas, "SELECT 1")
+	persist.CheckReplicas(s.replicas, context.Background())
+	s.readFrom(t, context.Background(), "Replica")
+}
+
+func TestReplicasSkipFailedLagCheck(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+
+	persist.SetReplicaLagQuery(s.replicas, "SELECT no_such_column")
+	persist.CheckReplicas(s.replicas, context.Background())
+	s.readFrom(t, context.Background(), "Primary")
+}
+
+func TestReplicasFallBackToPrimary(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+
+	// signed up on the primary, not replicated yet
+	const phoneNumber = "+911234567891"
+	if _, err := persist.NewProfileRepository(s.primary, persist.ProfileRepoOptions{PII: testKeyring}).Create(context.Background(), phoneNumber, "Jane"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.repo.Get(context.Background(), phoneNumber); err != nil {
+		t.Fatalf("profile missing on the replica was not read from the primary: %v", err)
+	}
+
+	// a miss on both stays a miss
+	if _, err := s.repo.Get(context.Background(), "+911234567892"); !errors.Is(err, persist.ErrProfileNotFound) {
+		t.Fatalf("got %v, want %v", err, persist.ErrProfileNotFound)
+	}
+	s.readFrom(t, context.Background(), "Replica")
+}
+
+func TestReplicasSkipFailedQuery(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+
+	if err := s.replica.Exec("ALTER TABLE profiles RENAME TO profiles_moved").Error; err != nil {
+		t.Fatal(err)
+	}
+	s.readFrom(t, context.Background(), "Primary")
+
+	// the replica stays out of use until the next lag check, even once it works again
+	if err := s.replica.Exec("ALTER TABLE profiles_moved RENAME TO profiles").Error; err != nil {
+		t.Fatal(err)
+	}
+	s.readFrom(t, context.Background(), "Primary")
+
+	persist.CheckReplicas(s.replicas, context.Background())
+	s.readFrom(t, context.Background(), "Replica")
+}
+
+func TestReplicasDoNotStickAfterEvents(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second, StickyFor: time.Minute})
+	events := persist.NewEventRepository(s.primary, persist.EventRepoOptions{PII: testKeyring, Replicas: s.replicas})
+
+	if _, err := events.Create(context.Background(), &models.Event{PhoneNumber: testPhoneNumber, EventType: "PROFILE_VIEW"}); err != nil {
+		t.Fatal(err)
+	}
+	s.readFrom(t, context.Background(), "Replica")
+}
+
+func TestReplicasLaggingEventPages(t *testing.T) {
+	s := newReplicaSetup(t, persist.ReplicaOptions{MaxLag: 5 * time.Second})
+	ctx := context.Background()
+
+	// written on the primary, not replicated yet
+	if _, err := persist.NewEventRepository(s.primary, persist.EventRepoOptions{PII: testKeyring}).Create(ctx, &models.Event{PhoneNumber: testPhoneNumber, EventType: "PROFILE_LOGIN"}); err != nil {
+		t.Fatal(err)
+	}
+	repo := persist.NewEventRepository(s.primary, persist.EventRepoOptions{PII: testKeyring, Replicas: s.replicas})
+	filter := persist.EventFilter{PhoneNumber: testPhoneNumber}
+
+	events, err := repo.List(ctx, filter, nil, 10)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("got %d events, %v, want the event from the primary", len(events), err)
+	}
+
+	var exported int
+	if err := repo.ForEach(ctx, filter, func(*models.Event) error { exported++; return nil }); err != nil || exported != 1 {
+		t.Fatalf("got %d events, %v, want the event from the primary", exported, err)
+	}
+
+	// an empty page on both stays empty
+	if events, err := repo.List(ctx, persist.EventFilter{PhoneNumber: "+911234567892"}, nil, 10); err != nil || len(events) != 0 {
+		t.Fatalf("got %d events, %v, want none", len(events), err)
+	}
+	s.readFrom(t, ctx, "Replica")
+}
@@ -0,0 +1,105 @@
+package persist
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPurgeEventsFailed = errors.New("failed to purge events")
+)
+
+type (
+	// RetentionRule selects the events created before Before, either of EventTypes or, when
+	// EventTypes is empty, of any type not in ExcludeTypes. Name is recorded on the
+	// tombstones of the events it purges.
+	RetentionRule struct {
+		Name         string
+		EventTypes   []string
+		ExcludeTypes []string
+		Before       time.Time
+	}
+)
+
+func (pr *eventRepository) CountExpired(ctx context.Context, rule RetentionRule) (int64, error) {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	var count int64
+	if err := expired(db, rule).Count(&count).Error; err != nil {
+		return 0, ErrPurgeEventsFailed
+	}
+	return count, nil
+}
+
+// PurgeExpired hard deletes up to limit of the oldest events matching rule in one short
+// transaction, handing them to archive first when it is not nil. Hash chained events
+// leave a tombstone behind so their stream stays verifiable.
+func (pr *eventRepository) PurgeExpired(ctx context.Context, rule RetentionRule, limit int, archive func(events []models.Event) error) (int, error) {
+	db, cancel := conn(withPII(ctx, pr.options.PII), pr.db, pr.options.QueryTimeout)
+	defer cancel()
+
+	var purged int
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var batch []models.Event
+		if err := expired(tx, rule).Order("created_at ASC").Limit(limit).Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if archive != nil {
+			if err := archive(batch); err != nil {
+				return err
+			}
+		}
+
+		ids := make([]string, 0, len(batch))
+		var tombstones []models.EventTombstone
+		purgedAt := time.Now()
+		for i := range batch {
+			event := &batch[i]
+			ids = append(ids, event.ID)
+			if event.Hash != "" {
+				if pr.options.ChainKey == nil {
+					return ErrNoChainKey
+				}
+				tombstones = append(tombstones, pr.options.ChainKey.newTombstone(event, rule.Name, purgedAt))
+			}
+		}
+
+		if len(tombstones) > 0 {
+			if err := tx.Create(&tombstones).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.Event{})
+		purged = int(result.RowsAffected)
+		return result.Error
+	})
+	if errors.Is(err, ErrNoChainKey) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, ErrPurgeEventsFailed
+	}
+	return purged, nil
+}
+
+func expired(db *gorm.DB, rule RetentionRule) *gorm.DB {
+	query := db.Unscoped().Model(&models.Event{}).Where("created_at < ?", rule.Before)
+
+	if len(rule.EventTypes) > 0 {
+		return query.Where("event_type IN ?", rule.EventTypes)
+	}
+	if len(rule.ExcludeTypes) > 0 {
+		query = query.Where("event_type NOT IN ?", rule.ExcludeTypes)
+	}
+	return query
+}
@@ -0,0 +1,33 @@
+package persist
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	sqliteBusyTimeout = 5 * time.Second
+)
+
+// OpenSQLite opens the SQLite database at path, ":memory:" keeps it in memory. SQLite has
+// a single writer, so the pool is limited to one connection which also keeps an in-memory
+// database alive. Timestamps are stored in UTC as they are compared as text.
+func OpenSQLite(path string) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("%s?_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)", path, sqliteBusyTimeout.Milliseconds())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
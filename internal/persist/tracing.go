@@ -0,0 +1,214 @@
+package persist
+
+import (
+	"context"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+var (
+	tracer = otel.Tracer("github.com/ilivestrong/auth-service/internal/persist")
+)
+
+type (
+	// The traced repositories wrap every call of the gorm repositories in a span.
+	tracedProfileRepository struct {
+		next   ProfileRepo
+		system string
+	}
+
+	tracedEventRepository struct {
+		next   EventRepo
+		system string
+	}
+
+	tracedDataExportRepository struct {
+		next   DataExportRepo
+		system string
+	}
+
+	tracedLeaseRepository struct {
+		next   LeaseRepo
+		system string
+	}
+
+	tracedUnitOfWork struct {
+		next   UnitOfWork
+		system string
+	}
+)
+
+func (tr *tracedProfileRepository) Create(ctx context.Context, phoneNumber string, name string) (string, error) {
+	return traced(ctx, tr.system, "ProfileRepo.Create", func(ctx context.Context) (string, error) {
+		return tr.next.Create(ctx, phoneNumber, name)
+	})
+}
+
+func (tr *tracedProfileRepository) Get(ctx context.Context, phoneNumber string) (*models.Profile, error) {
+	return traced(ctx, tr.system, "ProfileRepo.Get", func(ctx context.Context) (*models.Profile, error) {
+		return tr.next.Get(ctx, phoneNumber)
+	})
+}
+
+func (tr *tracedProfileRepository) UpdateOTP(ctx context.Context, phoneNumber string, otp string) error {
+	return tracedErr(ctx, tr.system, "ProfileRepo.UpdateOTP", func(ctx context.Context) error {
+		return tr.next.UpdateOTP(ctx, phoneNumber, otp)
+	})
+}
+
+func (tr *tracedProfileRepository) SetOTPVerified(ctx context.Context, phoneNumber string, otp string) error {
+	return tracedErr(ctx, tr.system, "ProfileRepo.SetOTPVerified", func(ctx context.Context) error {
+		return tr.next.SetOTPVerified(ctx, phoneNumber, otp)
+	})
+}
+
+func (tr *tracedProfileRepository) MatchOTP(ctx context.Context, phoneNumber string, otp string) error {
+	return tracedErr(ctx, tr.system, "ProfileRepo.MatchOTP", func(ctx context.Context) error {
+		return tr.next.MatchOTP(ctx, phoneNumber, otp)
+	})
+}
+
+func (tr *tracedEventRepository) Create(ctx context.Context, event *models.Event) (string, error) {
+	return traced(ctx, tr.system, "EventRepo.Create", func(ctx context.Context) (string, error) {
+		return tr.next.Create(ctx, event)
+	})
+}
+
+func (tr *tracedEventRepository) List(ctx context.Context, filter EventFilter, after *EventCursor, limit int) ([]models.Event, error) {
+	return traced(ctx, tr.system, "EventRepo.List", func(ctx context.Context) ([]models.Event, error) {
+		return tr.next.List(ctx, filter, after, limit)
+	})
+}
+
+func (tr *tracedEventRepository) VerifyChain(ctx context.Context, stream string) (*ChainReport, error) {
+	return traced(ctx, tr.system, "EventRepo.VerifyChain", func(ctx context.Context) (*ChainReport, error) {
+		return tr.next.VerifyChain(ctx, stream)
+	})
+}
+
+// PhoneNumberStream runs no query, it is not traced.
+func (tr *tracedEventRepository) PhoneNumberStream(phoneNumber string) string {
+	return tr.next.PhoneNumberStream(phoneNumber)
+}
+
+func (tr *tracedEventRepository) ForEach(ctx context.Context, filter EventFilter, fn func(event *models.Event) error) error {
+	return tracedErr(ctx, tr.system, "EventRepo.ForEach", func(ctx context.Context) error {
+		return tr.next.ForEach(ctx, filter, fn)
+	})
+}
+
+func (tr *tracedEventRepository) CountExpired(ctx context.Context, rule RetentionRule) (int64, error) {
+	return traced(ctx, tr.system, "EventRepo.CountExpired", func(ctx context.Context) (int64, error) {
+		return tr.next.CountExpired(ctx, rule)
+	})
+}
+
+func (tr *tracedEventRepository) PurgeExpired(ctx context.Context, rule RetentionRule, limit int, archive func(events []models.Event) error) (int, error) {
+	return traced(ctx, tr.system, "EventRepo.PurgeExpired", func(ctx context.Context) (int, error) {
+		return tr.next.PurgeExpired(ctx, rule, limit, archive)
+	})
+}
+
+func (tr *tracedDataExportRepository) Create(ctx context.Context, export *models.DataExport) (string, error) {
+	return traced(ctx, tr.system, "DataExportRepo.Create", func(ctx context.Context) (string, error) {
+		return tr.next.Create(ctx, export)
+	})
+}
+
+func (tr *tracedDataExportRepository) Get(ctx context.Context, id string) (*models.DataExport, error) {
+	return traced(ctx, tr.system, "DataExportRepo.Get", func(ctx context.Context) (*models.DataExport, error) {
+		return tr.next.Get(ctx, id)
+	})
+}
+
+func (tr *tracedDataExportRepository) FindActive(ctx context.Context, profileID string) (*models.DataExport, error) {
+	return traced(ctx, tr.system, "DataExportRepo.FindActive", func(ctx context.Context) (*models.DataExport, error) {
+		return tr.next.FindActive(ctx, profileID)
+	})
+}
+
+func (tr *tracedDataExportRepository) Claim(ctx context.Context, staleAfter time.Duration) (*models.DataExport, error) {
+	return traced(ctx, tr.system, "DataExportRepo.Claim", func(ctx context.Context) (*models.DataExport, error) {
+		return tr.next.Claim(ctx, staleAfter)
+	})
+}
+
+func (tr *tracedDataExportRepository) AppendChunk(ctx context.Context, id string, sequence int, data []byte) error {
+	return tracedErr(ctx, tr.system, "DataExportRepo.AppendChunk", func(ctx context.Context) error {
+		return tr.next.AppendChunk(ctx, id, sequence, data)
+	})
+}
+
+func (tr *tracedDataExportRepository) Complete(ctx context.Context, id string, size int64, expiresAt time.Time) error {
+	return tracedErr(ctx, tr.system, "DataExportRepo.Complete", func(ctx context.Context) error {
+		return tr.next.Complete(ctx, id, size, expiresAt)
+	})
+}
+
+func (tr *tracedDataExportRepository) Fail(ctx context.Context, id string, reason string, expiresAt time.Time) error {
+	return tracedErr(ctx, tr.system, "DataExportRepo.Fail", func(ctx context.Context) error {
+		return tr.next.Fail(ctx, id, reason, expiresAt)
+	})
+}
+
+func (tr *tracedDataExportRepository) ReadChunks(ctx context.Context, id string, fn func(data []byte) error) error {
+	return tracedErr(ctx, tr.system, "DataExportRepo.ReadChunks", func(ctx context.Context) error {
+		return tr.next.ReadChunks(ctx, id, fn)
+	})
+}
+
+func (tr *tracedDataExportRepository) PurgeExpired(ctx context.Context) (int64, error) {
+	return traced(ctx, tr.system, "DataExportRepo.PurgeExpired", func(ctx context.Context) (int64, error) {
+		return tr.next.PurgeExpired(ctx)
+	})
+}
+
+func (tr *tracedLeaseRepository) Acquire(ctx context.Context, name string, owner string, ttl time.Duration) (bool, error) {
+	return traced(ctx, tr.system, "LeaseRepo.Acquire", func(ctx context.Context) (bool, error) {
+		return tr.next.Acquire(ctx, name, owner, ttl)
+	})
+}
+
+func (tr *tracedLeaseRepository) Release(ctx context.Context, name string, owner string) error {
+	return tracedErr(ctx, tr.system, "LeaseRepo.Release", func(ctx context.Context) error {
+		return tr.next.Release(ctx, name, owner)
+	})
+}
+
+func (tu *tracedUnitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return tracedErr(ctx, tu.system, "UnitOfWork.Do", func(ctx context.Context) error {
+		return tu.next.Do(ctx, fn)
+	})
+}
+
+func traced[T any](ctx context.Context, system string, name string, call func(ctx context.Context) (T, error)) (T, error) {
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", system)),
+	)
+	defer span.End()
+
+	result, err := call(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return result, err
+}
+
+func tracedErr(ctx context.Context, system string, name string, call func(ctx context.Context) error) error {
+	_, err := traced(ctx, system, name, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, call(ctx)
+	})
+	return err
+}
+
+func dbSystem(db *gorm.DB) string {
+	return db.Dialector.Name()
+}
@@ -0,0 +1,63 @@
+package persist
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultQueryTimeout bounds repository calls when their options set no QueryTimeout.
+	defaultQueryTimeout = 5 * time.Second
+)
+
+type (
+	// UnitOfWork runs several repository calls in one transaction. Repositories join the
+	// transaction when they are called with the context handed to fn.
+	UnitOfWork interface {
+		Do(ctx context.Context, fn func(ctx context.Context) error) error
+	}
+
+	unitOfWork struct {
+		db           *gorm.DB
+		queryTimeout time.Duration
+	}
+
+	txKey struct{}
+)
+
+// Do commits when fn returns nil and rolls back otherwise. Nested calls run in a savepoint
+// of the outer transaction.
+func (uow *unitOfWork) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	db, cancel := conn(ctx, uow.db, uow.queryTimeout)
+	defer cancel()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(db.Statement.Context, txKey{}, tx))
+	})
+}
+
+// conn returns db, or the transaction carried by ctx, bound to ctx and limited to timeout,
+// or defaultQueryTimeout when it is zero. The returned cancel func must be called once the
+// queries are done.
+func conn(ctx context.Context, db *gorm.DB, timeout time.Duration) (*gorm.DB, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		db = tx
+	}
+
+	cancel := context.CancelFunc(func() {})
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > timeout {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	return db.WithContext(ctx), cancel
+}
+
+// NewUnitOfWork runs units of work on db, each limited to queryTimeout as a whole.
+func NewUnitOfWork(db *gorm.DB, queryTimeout time.Duration) UnitOfWork {
+	return &tracedUnitOfWork{&unitOfWork{db, queryTimeout}, dbSystem(db)}
+}
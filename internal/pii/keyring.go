@@ -0,0 +1,267 @@
+package pii
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// prefix marks encrypted values, anything else is plaintext written before encryption.
+	prefix = "pii:"
+
+	keySize   = 32
+	indexName = "index"
+)
+
+var (
+	ErrInvalidKeys  = errors.New("invalid pii keys")
+	ErrUnknownKey   = errors.New("value is encrypted with an unknown key version")
+	ErrInvalidValue = errors.New("malformed encrypted value")
+	ErrDecrypt      = errors.New("failed to decrypt value")
+
+	encoding = base64.RawStdEncoding
+)
+
+type (
+	// Keyring encrypts values with envelope encryption: every value gets its own random
+	// data key for AES-GCM, and the data key is stored next to the ciphertext, wrapped
+	// with the current key-encryption key. Older key versions only unwrap, so keys are
+	// rotated by adding a version and re-wrapping the stored data keys with Rewrap.
+	//
+	// Encrypted values look like pii:<key version>:<wrapped data key>:<ciphertext>.
+	Keyring struct {
+		keks    map[int]cipher.AEAD
+		current int
+		index   []byte
+	}
+)
+
+// Encrypt encrypts plaintext for the column named by aad, which has to be passed to
+// Decrypt again. Empty values stay empty.
+func (k *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := seal(aead, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := k.wrap(dek, k.current)
+	if err != nil {
+		return "", err
+	}
+	return format(k.current, wrapped, ciphertext), nil
+}
+
+// Decrypt returns the plaintext of value, or value itself when it is not encrypted.
+func (k *Keyring) Decrypt(value, aad string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	version, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	dek, err := k.unwrap(wrapped, version)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(aead, ciphertext, []byte(aad))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap re-wraps the data key of value with the current key, without touching the
+// ciphertext. Values already on the current key and plaintext values are returned as is.
+func (k *Keyring) Rewrap(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	version, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	if version == k.current {
+		return value, nil
+	}
+
+	dek, err := k.unwrap(wrapped, version)
+	if err != nil {
+		return "", err
+	}
+	if wrapped, err = k.wrap(dek, k.current); err != nil {
+		return "", err
+	}
+	return format(k.current, wrapped, ciphertext), nil
+}
+
+// BlindIndex is the hex HMAC-SHA256 of value, for finding and deduplicating encrypted
+// values without decrypting them. Empty values have an empty index.
+func (k *Keyring) BlindIndex(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CurrentPrefix starts every value encrypted with the current key.
+func (k *Keyring) CurrentPrefix() string {
+	return fmt.Sprintf("%s%d:", prefix, k.current)
+}
+
+func (k *Keyring) wrap(dek []byte, version int) ([]byte, error) {
+	return seal(k.keks[version], dek, versionAAD(version))
+}
+
+func (k *Keyring) unwrap(wrapped []byte, version int) ([]byte, error) {
+	kek, ok := k.keks[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, version)
+	}
+
+	dek, err := open(kek, wrapped, versionAAD(version))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return dek, nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func format(version int, wrapped, ciphertext []byte) string {
+	return fmt.Sprintf("%s%d:%s:%s", prefix, version, encoding.EncodeToString(wrapped), encoding.EncodeToString(ciphertext))
+}
+
+func parse(value string) (int, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return 0, nil, nil, ErrInvalidValue
+	}
+
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, nil, nil, ErrInvalidValue
+	}
+	wrapped, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, nil, ErrInvalidValue
+	}
+	ciphertext, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return 0, nil, nil, ErrInvalidValue
+	}
+	return version, wrapped, ciphertext, nil
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the result.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidValue
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// versionAAD binds a wrapped data key to the version of the key that wrapped it.
+func versionAAD(version int) []byte {
+	return []byte("kek:" + strconv.Itoa(version))
+}
+
+// ParseKeys reads a keyring from lines, or comma separated entries, of the form
+// <version>:<base64 key> for key-encryption keys and index:<base64 key> for the blind
+// index key. All keys are 32 bytes, the highest version encrypts.
+func ParseKeys(text string) (*Keyring, error) {
+	k := &Keyring{keks: make(map[int]cipher.AEAD)}
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(text, ",", "\n")))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, encoded, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("%w: entry is not <version>:<base64 key>", ErrInvalidKeys)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("%w: key %s is not %d base64 encoded bytes", ErrInvalidKeys, name, keySize)
+		}
+
+		if name == indexName {
+			k.index = key
+			continue
+		}
+
+		version, err := strconv.Atoi(name)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: key version %q is not a positive number", ErrInvalidKeys, name)
+		}
+		if _, exists := k.keks[version]; exists {
+			return nil, fmt.Errorf("%w: key version %d is given twice", ErrInvalidKeys, version)
+		}
+		if k.keks[version], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+		k.current = max(k.current, version)
+	}
+
+	if len(k.keks) == 0 {
+		return nil, fmt.Errorf("%w: no key-encryption key", ErrInvalidKeys)
+	}
+	if k.index == nil {
+		return nil, fmt.Errorf("%w: no index key", ErrInvalidKeys)
+	}
+	return k, nil
+}
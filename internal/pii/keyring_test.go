@@ -0,0 +1,208 @@
+package pii_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ilivestrong/auth-service/internal/pii"
+)
+
+const aad = "profiles.phone_number"
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := keyring(t, "1:"+key(1)+",index:"+key(9))
+
+	encrypted, err := k.Encrypt("+911234567890", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, "pii:1:") || strings.Contains(encrypted, "1234567890") {
+		t.Fatalf("got %q, want it encrypted with key 1", encrypted)
+	}
+	if again, _ := k.Encrypt("+911234567890", aad); again == encrypted {
+		t.Fatal("encrypting twice gave the same ciphertext")
+	}
+
+	decrypted, err := k.Decrypt(encrypted, aad)
+	if err != nil || decrypted != "+911234567890" {
+		t.Fatalf("got %q, %v, want the plaintext back", decrypted, err)
+	}
+}
+
+func TestEncryptKeepsEmptyValues(t *testing.T) {
+	k := keyring(t, "1:"+key(1)+",index:"+key(9))
+
+	if encrypted, err := k.Encrypt("", aad); err != nil || encrypted != "" {
+		t.Fatalf("got %q, %v, want empty", encrypted, err)
+	}
+	if index := k.BlindIndex(""); index != "" {
+		t.Fatalf("got index %q, want empty", index)
+	}
+}
+
+func TestDecryptPassesPlaintextThrough(t *testing.T) {
+	k := keyring(t, "1:"+key(1)+",index:"+key(9))
+
+	if decrypted, err := k.Decrypt("+911234567890", aad); err != nil || decrypted != "+911234567890" {
+		t.Fatalf("got %q, %v, want the plaintext as is", decrypted, err)
+	}
+}
+
+func TestDecryptIsBoundToAAD(t *testing.T) {
+	k := keyring(t, "1:"+key(1)+",index:"+key(9))
+
+	encrypted, err := k.Encrypt("Jane", "profiles.name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Decrypt(encrypted, aad); !errors.Is(err, pii.ErrDecrypt) {
+		t.Fatalf("got %v, want %v for a value moved to another column", err, pii.ErrDecrypt)
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	k := keyring(t, "1:"+key(1)+",index:"+key(9))
+	encrypted, err := k.Encrypt("Jane", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{"unknown key version", strings.Replace(encrypted, "pii:1:", "pii:3:", 1), pii.ErrUnknownKey},
+		{"wrong key version", strings.Replace(encrypted, "pii:1:", "pii:2:", 1), pii.ErrUnknownKey},
+		{"missing part", encrypted[:strings.LastIndex(encrypted, ":")], pii.ErrInvalidValue},
+		{"not base64", encrypted + "!", pii.ErrInvalidValue},
+		{"flipped ciphertext", flipLast(encrypted), pii.ErrDecrypt},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := k.Decrypt(test.value, aad); !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRewrapAfterVersionBump(t *testing.T) {
+	old := keyring(t, "1:"+key(1)+",index:"+key(9))
+	encrypted, err := old.Encrypt("Jane", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bumped := keyring(t, "1:"+key(1)+",2:"+key(2)+",index:"+key(9))
+	if bumped.CurrentPrefix() != "pii:2:" {
+		t.Fatalf("got current prefix %q, want the highest version", bumped.CurrentPrefix())
+	}
+	rewrapped, err := bumped.Rewrap(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(rewrapped, "pii:2:") {
+		t.Fatalf("got %q, want it wrapped with key 2", rewrapped)
+	}
+	// only the data key is re-wrapped
+	if encrypted[strings.LastIndex(encrypted, ":"):] != rewrapped[strings.LastIndex(rewrapped, ":"):] {
+		t.Fatal("rewrap changed the ciphertext")
+	}
+	if again, err := bumped.Rewrap(rewrapped); err != nil || again != rewrapped {
+		t.Fatalf("got %q, %v, want a value on the current key left as is", again, err)
+	}
+	if plaintext, err := bumped.Rewrap("Jane"); err != nil || plaintext != "Jane" {
+		t.Fatalf("got %q, %v, want plaintext left as is", plaintext, err)
+	}
+
+	current := keyring(t, "2:"+key(2)+",index:"+key(9))
+	if decrypted, err := current.Decrypt(rewrapped, aad); err != nil || decrypted != "Jane" {
+		t.Fatalf("got %q, %v, want it readable without key 1", decrypted, err)
+	}
+	if _, err := current.Decrypt(encrypted, aad); !errors.Is(err, pii.ErrUnknownKey) {
+		t.Fatalf("got %v, want %v for a value still on key 1", err, pii.ErrUnknownKey)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	k := keyring(t, "1:"+key(1)+",index:"+key(9))
+	rotated := keyring(t, "2:"+key(2)+",index:"+key(9))
+	other := keyring(t, "1:"+key(1)+",index:"+key(8))
+
+	index := k.BlindIndex("+911234567890")
+	if len(index) != 64 {
+		t.Fatalf("got %q, want 64 hex digits", index)
+	}
+	if rotated.BlindIndex("+911234567890") != index {
+		t.Fatal("the index changed with the key-encryption key")
+	}
+	if other.BlindIndex("+911234567890") == index {
+		t.Fatal("the index did not change with the index key")
+	}
+	if k.BlindIndex("+911234567891") == index {
+		t.Fatal("two phone numbers have the same index")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+		err  string
+	}{
+		{"comma separated", "1:" + key(1) + ",index:" + key(9), ""},
+		{"lines with comments", "# rotated 2024\n1:" + key(1) + "\n2: " + key(2) + "\n\nindex:" + key(9) + "\n", ""},
+		{"no separator", "1" + key(1) + ",index:" + key(9), "entry is not <version>:<base64 key>"},
+		{"not base64", "1:not base64!,index:" + key(9), "key 1 is not 32 base64 encoded bytes"},
+		{"short key", "1:" + base64.StdEncoding.EncodeToString([]byte("short")) + ",index:" + key(9), "key 1 is not 32 base64 encoded bytes"},
+		{"version not a number", "v1:" + key(1) + ",index:" + key(9), `key version "v1" is not a positive number`},
+		{"version zero", "0:" + key(1) + ",index:" + key(9), `key version "0" is not a positive number`},
+		{"version twice", "1:" + key(1) + ",1:" + key(2) + ",index:" + key(9), "key version 1 is given twice"},
+		{"no key-encryption key", "index:" + key(9), "no key-encryption key"},
+		{"no index key", "1:" + key(1), "no index key"},
+		{"empty", "", "no key-encryption key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := pii.ParseKeys(test.keys)
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, pii.ErrInvalidKeys) || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got %v, want %v: %s", err, pii.ErrInvalidKeys, test.err)
+			}
+		})
+	}
+}
+
+func keyring(t *testing.T, keys string) *pii.Keyring {
+	t.Helper()
+
+	k, err := pii.ParseKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// key is a base64 encoded key with every byte set to b.
+func key(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// flipLast changes the last base64 digit of value.
+func flipLast(value string) string {
+	last := value[len(value)-1]
+	flipped := byte('A')
+	if last == 'A' {
+		flipped = 'B'
+	}
+	return value[:len(value)-1] + string(flipped)
+}
@@ -46,6 +46,35 @@ const (
 	AuthServiceGetProfileProcedure = "/auth.v1.AuthService/GetProfile"
 	// AuthServiceLogoutProcedure is the fully-qualified name of the AuthService's Logout RPC.
 	AuthServiceLogoutProcedure = "/auth.v1.AuthService/Logout"
+	// AuthServiceListMyEventsProcedure is the fully-qualified name of the AuthService's ListMyEvents
+	// RPC.
+	AuthServiceListMyEventsProcedure = "/auth.v1.AuthService/ListMyEvents"
+	// AuthServiceListEventsProcedure is the fully-qualified name of the AuthService's ListEvents RPC.
+	AuthServiceListEventsProcedure = "/auth.v1.AuthService/ListEvents"
+	// AuthServiceVerifyAuditLogProcedure is the fully-qualified name of the AuthService's
+	// VerifyAuditLog RPC.
+	AuthServiceVerifyAuditLogProcedure = "/auth.v1.AuthService/VerifyAuditLog"
+	// AuthServiceExportEventsProcedure is the fully-qualified name of the AuthService's ExportEvents
+	// RPC.
+	AuthServiceExportEventsProcedure = "/auth.v1.AuthService/ExportEvents"
+	// AuthServiceExportMyDataProcedure is the fully-qualified name of the AuthService's ExportMyData
+	// RPC.
+	AuthServiceExportMyDataProcedure = "/auth.v1.AuthService/ExportMyData"
+	// AuthServiceGetMyDataExportProcedure is the fully-qualified name of the AuthService's
+	// GetMyDataExport RPC.
+	AuthServiceGetMyDataExportProcedure = "/auth.v1.AuthService/GetMyDataExport"
+	// AuthServiceDownloadMyDataExportProcedure is the fully-qualified name of the AuthService's
+	// DownloadMyDataExport RPC.
+	AuthServiceDownloadMyDataExportProcedure = "/auth.v1.AuthService/DownloadMyDataExport"
+	// AuthServiceExportUserDataProcedure is the fully-qualified name of the AuthService's
+	// ExportUserData RPC.
+	AuthServiceExportUserDataProcedure = "/auth.v1.AuthService/ExportUserData"
+	// AuthServiceGetDataExportProcedure is the fully-qualified name of the AuthService's GetDataExport
+	// RPC.
+	AuthServiceGetDataExportProcedure = "/auth.v1.AuthService/GetDataExport"
+	// AuthServiceDownloadDataExportProcedure is the fully-qualified name of the AuthService's
+	// DownloadDataExport RPC.
+	AuthServiceDownloadDataExportProcedure = "/auth.v1.AuthService/DownloadDataExport"
 )
 
 // These variables are the protoreflect.Descriptor objects for the RPCs defined in this package.
@@ -56,6 +85,16 @@ var (
 	authServiceLoginWithPhoneNumberMethodDescriptor  = authServiceServiceDescriptor.Methods().ByName("LoginWithPhoneNumber")
 	authServiceGetProfileMethodDescriptor            = authServiceServiceDescriptor.Methods().ByName("GetProfile")
 	authServiceLogoutMethodDescriptor                = authServiceServiceDescriptor.Methods().ByName("Logout")
+	authServiceListMyEventsMethodDescriptor          = authServiceServiceDescriptor.Methods().ByName("ListMyEvents")
+	authServiceListEventsMethodDescriptor            = authServiceServiceDescriptor.Methods().ByName("ListEvents")
+	authServiceVerifyAuditLogMethodDescriptor        = authServiceServiceDescriptor.Methods().ByName("VerifyAuditLog")
+	authServiceExportEventsMethodDescriptor          = authServiceServiceDescriptor.Methods().ByName("ExportEvents")
+	authServiceExportMyDataMethodDescriptor          = authServiceServiceDescriptor.Methods().ByName("ExportMyData")
+	authServiceGetMyDataExportMethodDescriptor       = authServiceServiceDescriptor.Methods().ByName("GetMyDataExport")
+	authServiceDownloadMyDataExportMethodDescriptor  = authServiceServiceDescriptor.Methods().ByName("DownloadMyDataExport")
+	authServiceExportUserDataMethodDescriptor        = authServiceServiceDescriptor.Methods().ByName("ExportUserData")
+	authServiceGetDataExportMethodDescriptor         = authServiceServiceDescriptor.Methods().ByName("GetDataExport")
+	authServiceDownloadDataExportMethodDescriptor    = authServiceServiceDescriptor.Methods().ByName("DownloadDataExport")
 )
 
 // AuthServiceClient is a client for the auth.v1.AuthService service.
@@ -65,6 +104,25 @@ type AuthServiceClient interface {
 	LoginWithPhoneNumber(context.Context, *connect.Request[v1.LoginWithPhoneNumberRequest]) (*connect.Response[v1.LoginWithPhoneNumberResponse], error)
 	GetProfile(context.Context, *connect.Request[v1.GetProfileRequest]) (*connect.Response[v1.GetProfileResponse], error)
 	Logout(context.Context, *connect.Request[v1.LogoutRequest]) (*connect.Response[v1.LogoutResponse], error)
+	ListMyEvents(context.Context, *connect.Request[v1.ListMyEventsRequest]) (*connect.Response[v1.ListMyEventsResponse], error)
+	// Admin only, requires the admin token as bearer token.
+	ListEvents(context.Context, *connect.Request[v1.ListEventsRequest]) (*connect.Response[v1.ListEventsResponse], error)
+	// Admin only, walks the audit log hash chain.
+	VerifyAuditLog(context.Context, *connect.Request[v1.VerifyAuditLogRequest]) (*connect.Response[v1.VerifyAuditLogResponse], error)
+	// Admin only, streams the events of a time range oldest first.
+	ExportEvents(context.Context, *connect.Request[v1.ExportEventsRequest]) (*connect.ServerStreamForClient[v1.ExportEventsResponse], error)
+	// Starts assembling everything held about the logged in user into a JSON archive,
+	// or returns the export already in progress.
+	ExportMyData(context.Context, *connect.Request[v1.ExportMyDataRequest]) (*connect.Response[v1.ExportMyDataResponse], error)
+	GetMyDataExport(context.Context, *connect.Request[v1.GetMyDataExportRequest]) (*connect.Response[v1.GetMyDataExportResponse], error)
+	// Streams the archive of a done export.
+	DownloadMyDataExport(context.Context, *connect.Request[v1.DownloadMyDataExportRequest]) (*connect.ServerStreamForClient[v1.DownloadMyDataExportResponse], error)
+	// Admin only, ExportMyData for any phone number.
+	ExportUserData(context.Context, *connect.Request[v1.ExportUserDataRequest]) (*connect.Response[v1.ExportUserDataResponse], error)
+	// Admin only, GetMyDataExport for any export.
+	GetDataExport(context.Context, *connect.Request[v1.GetDataExportRequest]) (*connect.Response[v1.GetDataExportResponse], error)
+	// Admin only, DownloadMyDataExport for any export.
+	DownloadDataExport(context.Context, *connect.Request[v1.DownloadDataExportRequest]) (*connect.ServerStreamForClient[v1.DownloadDataExportResponse], error)
 }
 
 // NewAuthServiceClient constructs a client for the auth.v1.AuthService service. By default, it uses
@@ -107,6 +165,66 @@ func NewAuthServiceClient(httpClient connect.HTTPClient, baseURL string, opts ..
 			connect.WithSchema(authServiceLogoutMethodDescriptor),
 			connect.WithClientOptions(opts...),
 		),
+		listMyEvents: connect.NewClient[v1.ListMyEventsRequest, v1.ListMyEventsResponse](
+			httpClient,
+			baseURL+AuthServiceListMyEventsProcedure,
+			connect.WithSchema(authServiceListMyEventsMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		listEvents: connect.NewClient[v1.ListEventsRequest, v1.ListEventsResponse](
+			httpClient,
+			baseURL+AuthServiceListEventsProcedure,
+			connect.WithSchema(authServiceListEventsMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		verifyAuditLog: connect.NewClient[v1.VerifyAuditLogRequest, v1.VerifyAuditLogResponse](
+			httpClient,
+			baseURL+AuthServiceVerifyAuditLogProcedure,
+			connect.WithSchema(authServiceVerifyAuditLogMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		exportEvents: connect.NewClient[v1.ExportEventsRequest, v1.ExportEventsResponse](
+			httpClient,
+			baseURL+AuthServiceExportEventsProcedure,
+			connect.WithSchema(authServiceExportEventsMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		exportMyData: connect.NewClient[v1.ExportMyDataRequest, v1.ExportMyDataResponse](
+			httpClient,
+			baseURL+AuthServiceExportMyDataProcedure,
+			connect.WithSchema(authServiceExportMyDataMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getMyDataExport: connect.NewClient[v1.GetMyDataExportRequest, v1.GetMyDataExportResponse](
+			httpClient,
+			baseURL+AuthServiceGetMyDataExportProcedure,
+			connect.WithSchema(authServiceGetMyDataExportMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		downloadMyDataExport: connect.NewClient[v1.DownloadMyDataExportRequest, v1.DownloadMyDataExportResponse](
+			httpClient,
+			baseURL+AuthServiceDownloadMyDataExportProcedure,
+			connect.WithSchema(authServiceDownloadMyDataExportMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		exportUserData: connect.NewClient[v1.ExportUserDataRequest, v1.ExportUserDataResponse](
+			httpClient,
+			baseURL+AuthServiceExportUserDataProcedure,
+			connect.WithSchema(authServiceExportUserDataMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		getDataExport: connect.NewClient[v1.GetDataExportRequest, v1.GetDataExportResponse](
+			httpClient,
+			baseURL+AuthServiceGetDataExportProcedure,
+			connect.WithSchema(authServiceGetDataExportMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
+		downloadDataExport: connect.NewClient[v1.DownloadDataExportRequest, v1.DownloadDataExportResponse](
+			httpClient,
+			baseURL+AuthServiceDownloadDataExportProcedure,
+			connect.WithSchema(authServiceDownloadDataExportMethodDescriptor),
+			connect.WithClientOptions(opts...),
+		),
 	}
 }
 
@@ -117,6 +235,16 @@ type authServiceClient struct {
 	loginWithPhoneNumber  *connect.Client[v1.LoginWithPhoneNumberRequest, v1.LoginWithPhoneNumberResponse]
 	getProfile            *connect.Client[v1.GetProfileRequest, v1.GetProfileResponse]
 	logout                *connect.Client[v1.LogoutRequest, v1.LogoutResponse]
+	listMyEvents          *connect.Client[v1.ListMyEventsRequest, v1.ListMyEventsResponse]
+	listEvents            *connect.Client[v1.ListEventsRequest, v1.ListEventsResponse]
+	verifyAuditLog        *connect.Client[v1.VerifyAuditLogRequest, v1.VerifyAuditLogResponse]
+	exportEvents          *connect.Client[v1.ExportEventsRequest, v1.ExportEventsResponse]
+	exportMyData          *connect.Client[v1.ExportMyDataRequest, v1.ExportMyDataResponse]
+	getMyDataExport       *connect.Client[v1.GetMyDataExportRequest, v1.GetMyDataExportResponse]
+	downloadMyDataExport  *connect.Client[v1.DownloadMyDataExportRequest, v1.DownloadMyDataExportResponse]
+	exportUserData        *connect.Client[v1.ExportUserDataRequest, v1.ExportUserDataResponse]
+	getDataExport         *connect.Client[v1.GetDataExportRequest, v1.GetDataExportResponse]
+	downloadDataExport    *connect.Client[v1.DownloadDataExportRequest, v1.DownloadDataExportResponse]
 }
 
 // SignupWithPhoneNumber calls auth.v1.AuthService.SignupWithPhoneNumber.
@@ -144,6 +272,56 @@ func (c *authServiceClient) Logout(ctx context.Context, req *connect.Request[v1.
 	return c.logout.CallUnary(ctx, req)
 }
 
+// ListMyEvents calls auth.v1.AuthService.ListMyEvents.
+func (c *authServiceClient) ListMyEvents(ctx context.Context, req *connect.Request[v1.ListMyEventsRequest]) (*connect.Response[v1.ListMyEventsResponse], error) {
+	return c.listMyEvents.CallUnary(ctx, req)
+}
+
+// ListEvents calls auth.v1.AuthService.ListEvents.
+func (c *authServiceClient) ListEvents(ctx context.Context, req *connect.Request[v1.ListEventsRequest]) (*connect.Response[v1.ListEventsResponse], error) {
+	return c.listEvents.CallUnary(ctx, req)
+}
+
+// VerifyAuditLog calls auth.v1.AuthService.VerifyAuditLog.
+func (c *authServiceClient) VerifyAuditLog(ctx context.Context, req *connect.Request[v1.VerifyAuditLogRequest]) (*connect.Response[v1.VerifyAuditLogResponse], error) {
+	return c.verifyAuditLog.CallUnary(ctx, req)
+}
+
+// ExportEvents calls auth.v1.AuthService.ExportEvents.
+func (c *authServiceClient) ExportEvents(ctx context.Context, req *connect.Request[v1.ExportEventsRequest]) (*connect.ServerStreamForClient[v1.ExportEventsResponse], error) {
+	return c.exportEvents.CallServerStream(ctx, req)
+}
+
+// ExportMyData calls auth.v1.AuthService.ExportMyData.
+func (c *authServiceClient) ExportMyData(ctx context.Context, req *connect.Request[v1.ExportMyDataRequest]) (*connect.Response[v1.ExportMyDataResponse], error) {
+	return c.exportMyData.CallUnary(ctx, req)
+}
+
+// GetMyDataExport calls auth.v1.AuthService.GetMyDataExport.
+func (c *authServiceClient) GetMyDataExport(ctx context.Context, req *connect.Request[v1.GetMyDataExportRequest]) (*connect.Response[v1.GetMyDataExportResponse], error) {
+	return c.getMyDataExport.CallUnary(ctx, req)
+}
+
+// DownloadMyDataExport calls auth.v1.AuthService.DownloadMyDataExport.
+func (c *authServiceClient) DownloadMyDataExport(ctx context.Context, req *connect.Request[v1.DownloadMyDataExportRequest]) (*connect.ServerStreamForClient[v1.DownloadMyDataExportResponse], error) {
+	return c.downloadMyDataExport.CallServerStream(ctx, req)
+}
+
+// ExportUserData calls auth.v1.AuthService.ExportUserData.
+func (c *authServiceClient) ExportUserData(ctx context.Context, req *connect.Request[v1.ExportUserDataRequest]) (*connect.Response[v1.ExportUserDataResponse], error) {
+	return c.exportUserData.CallUnary(ctx, req)
+}
+
+// GetDataExport calls auth.v1.AuthService.GetDataExport.
+func (c *authServiceClient) GetDataExport(ctx context.Context, req *connect.Request[v1.GetDataExportRequest]) (*connect.Response[v1.GetDataExportResponse], error) {
+	return c.getDataExport.CallUnary(ctx, req)
+}
+
+// DownloadDataExport calls auth.v1.AuthService.DownloadDataExport.
+func (c *authServiceClient) DownloadDataExport(ctx context.Context, req *connect.Request[v1.DownloadDataExportRequest]) (*connect.ServerStreamForClient[v1.DownloadDataExportResponse], error) {
+	return c.downloadDataExport.CallServerStream(ctx, req)
+}
+
 // AuthServiceHandler is an implementation of the auth.v1.AuthService service.
 type AuthServiceHandler interface {
 	SignupWithPhoneNumber(context.Context, *connect.Request[v1.SignupWithPhoneNumberRequest]) (*connect.Response[v1.SignupWithPhoneNumberResponse], error)
@@ -151,6 +329,25 @@ type AuthServiceHandler interface {
 	LoginWithPhoneNumber(context.Context, *connect.Request[v1.LoginWithPhoneNumberRequest]) (*connect.Response[v1.LoginWithPhoneNumberResponse], error)
 	GetProfile(context.Context, *connect.Request[v1.GetProfileRequest]) (*connect.Response[v1.GetProfileResponse], error)
 	Logout(context.Context, *connect.Request[v1.LogoutRequest]) (*connect.Response[v1.LogoutResponse], error)
+	ListMyEvents(context.Context, *connect.Request[v1.ListMyEventsRequest]) (*connect.Response[v1.ListMyEventsResponse], error)
+	// Admin only, requires the admin token as bearer token.
+	ListEvents(context.Context, *connect.Request[v1.ListEventsRequest]) (*connect.Response[v1.ListEventsResponse], error)
+	// Admin only, walks the audit log hash chain.
+	VerifyAuditLog(context.Context, *connect.Request[v1.VerifyAuditLogRequest]) (*connect.Response[v1.VerifyAuditLogResponse], error)
+	// Admin only, streams the events of a time range oldest first.
+	ExportEvents(context.Context, *connect.Request[v1.ExportEventsRequest], *connect.ServerStream[v1.ExportEventsResponse]) error
+	// Starts assembling everything held about the logged in user into a JSON archive,
+	// or returns the export already in progress.
+	ExportMyData(context.Context, *connect.Request[v1.ExportMyDataRequest]) (*connect.Response[v1.ExportMyDataResponse], error)
+	GetMyDataExport(context.Context, *connect.Request[v1.GetMyDataExportRequest]) (*connect.Response[v1.GetMyDataExportResponse], error)
+	// Streams the archive of a done export.
+	DownloadMyDataExport(context.Context, *connect.Request[v1.DownloadMyDataExportRequest], *connect.ServerStream[v1.DownloadMyDataExportResponse]) error
+	// Admin only, ExportMyData for any phone number.
+	ExportUserData(context.Context, *connect.Request[v1.ExportUserDataRequest]) (*connect.Response[v1.ExportUserDataResponse], error)
+	// Admin only, GetMyDataExport for any export.
+	GetDataExport(context.Context, *connect.Request[v1.GetDataExportRequest]) (*connect.Response[v1.GetDataExportResponse], error)
+	// Admin only, DownloadMyDataExport for any export.
+	DownloadDataExport(context.Context, *connect.Request[v1.DownloadDataExportRequest], *connect.ServerStream[v1.DownloadDataExportResponse]) error
 }
 
 // NewAuthServiceHandler builds an HTTP handler from the service implementation. It returns the path
@@ -189,6 +386,66 @@ func NewAuthServiceHandler(svc AuthServiceHandler, opts ...connect.HandlerOption
 		connect.WithSchema(authServiceLogoutMethodDescriptor),
 		connect.WithHandlerOptions(opts...),
 	)
+	authServiceListMyEventsHandler := connect.NewUnaryHandler(
+		AuthServiceListMyEventsProcedure,
+		svc.ListMyEvents,
+		connect.WithSchema(authServiceListMyEventsMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceListEventsHandler := connect.NewUnaryHandler(
+		AuthServiceListEventsProcedure,
+		svc.ListEvents,
+		connect.WithSchema(authServiceListEventsMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceVerifyAuditLogHandler := connect.NewUnaryHandler(
+		AuthServiceVerifyAuditLogProcedure,
+		svc.VerifyAuditLog,
+		connect.WithSchema(authServiceVerifyAuditLogMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceExportEventsHandler := connect.NewServerStreamHandler(
+		AuthServiceExportEventsProcedure,
+		svc.ExportEvents,
+		connect.WithSchema(authServiceExportEventsMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceExportMyDataHandler := connect.NewUnaryHandler(
+		AuthServiceExportMyDataProcedure,
+		svc.ExportMyData,
+		connect.WithSchema(authServiceExportMyDataMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceGetMyDataExportHandler := connect.NewUnaryHandler(
+		AuthServiceGetMyDataExportProcedure,
+		svc.GetMyDataExport,
+		connect.WithSchema(authServiceGetMyDataExportMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceDownloadMyDataExportHandler := connect.NewServerStreamHandler(
+		AuthServiceDownloadMyDataExportProcedure,
+		svc.DownloadMyDataExport,
+		connect.WithSchema(authServiceDownloadMyDataExportMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceExportUserDataHandler := connect.NewUnaryHandler(
+		AuthServiceExportUserDataProcedure,
+		svc.ExportUserData,
+		connect.WithSchema(authServiceExportUserDataMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceGetDataExportHandler := connect.NewUnaryHandler(
+		AuthServiceGetDataExportProcedure,
+		svc.GetDataExport,
+		connect.WithSchema(authServiceGetDataExportMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
+	authServiceDownloadDataExportHandler := connect.NewServerStreamHandler(
+		AuthServiceDownloadDataExportProcedure,
+		svc.DownloadDataExport,
+		connect.WithSchema(authServiceDownloadDataExportMethodDescriptor),
+		connect.WithHandlerOptions(opts...),
+	)
 	return "/auth.v1.AuthService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.URL.Path {
 		case AuthServiceSignupWithPhoneNumberProcedure:
@@ -201,6 +458,26 @@ func NewAuthServiceHandler(svc AuthServiceHandler, opts ...connect.HandlerOption
 			authServiceGetProfileHandler.ServeHTTP(w, r)
 		case AuthServiceLogoutProcedure:
 			authServiceLogoutHandler.ServeHTTP(w, r)
+		case AuthServiceListMyEventsProcedure:
+			authServiceListMyEventsHandler.ServeHTTP(w, r)
+		case AuthServiceListEventsProcedure:
+			authServiceListEventsHandler.ServeHTTP(w, r)
+		case AuthServiceVerifyAuditLogProcedure:
+			authServiceVerifyAuditLogHandler.ServeHTTP(w, r)
+		case AuthServiceExportEventsProcedure:
+			authServiceExportEventsHandler.ServeHTTP(w, r)
+		case AuthServiceExportMyDataProcedure:
+			authServiceExportMyDataHandler.ServeHTTP(w, r)
+		case AuthServiceGetMyDataExportProcedure:
+			authServiceGetMyDataExportHandler.ServeHTTP(w, r)
+		case AuthServiceDownloadMyDataExportProcedure:
+			authServiceDownloadMyDataExportHandler.ServeHTTP(w, r)
+		case AuthServiceExportUserDataProcedure:
+			authServiceExportUserDataHandler.ServeHTTP(w, r)
+		case AuthServiceGetDataExportProcedure:
+			authServiceGetDataExportHandler.ServeHTTP(w, r)
+		case AuthServiceDownloadDataExportProcedure:
+			authServiceDownloadDataExportHandler.ServeHTTP(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -229,3 +506,43 @@ func (UnimplementedAuthServiceHandler) GetProfile(context.Context, *connect.Requ
 func (UnimplementedAuthServiceHandler) Logout(context.Context, *connect.Request[v1.LogoutRequest]) (*connect.Response[v1.LogoutResponse], error) {
 	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.Logout is not implemented"))
 }
+
+func (UnimplementedAuthServiceHandler) ListMyEvents(context.Context, *connect.Request[v1.ListMyEventsRequest]) (*connect.Response[v1.ListMyEventsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.ListMyEvents is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) ListEvents(context.Context, *connect.Request[v1.ListEventsRequest]) (*connect.Response[v1.ListEventsResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.ListEvents is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) VerifyAuditLog(context.Context, *connect.Request[v1.VerifyAuditLogRequest]) (*connect.Response[v1.VerifyAuditLogResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.VerifyAuditLog is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) ExportEvents(context.Context, *connect.Request[v1.ExportEventsRequest], *connect.ServerStream[v1.ExportEventsResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.ExportEvents is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) ExportMyData(context.Context, *connect.Request[v1.ExportMyDataRequest]) (*connect.Response[v1.ExportMyDataResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.ExportMyData is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) GetMyDataExport(context.Context, *connect.Request[v1.GetMyDataExportRequest]) (*connect.Response[v1.GetMyDataExportResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.GetMyDataExport is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) DownloadMyDataExport(context.Context, *connect.Request[v1.DownloadMyDataExportRequest], *connect.ServerStream[v1.DownloadMyDataExportResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.DownloadMyDataExport is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) ExportUserData(context.Context, *connect.Request[v1.ExportUserDataRequest]) (*connect.Response[v1.ExportUserDataResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.ExportUserData is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) GetDataExport(context.Context, *connect.Request[v1.GetDataExportRequest]) (*connect.Response[v1.GetDataExportResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.GetDataExport is not implemented"))
+}
+
+func (UnimplementedAuthServiceHandler) DownloadDataExport(context.Context, *connect.Request[v1.DownloadDataExportRequest], *connect.ServerStream[v1.DownloadDataExportResponse]) error {
+	return connect.NewError(connect.CodeUnimplemented, errors.New("auth.v1.AuthService.DownloadDataExport is not implemented"))
+}
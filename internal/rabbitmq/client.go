@@ -2,11 +2,12 @@ package rabbitmq
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"sync/atomic"
 
 	"github.com/ilivestrong/auth-service/internal/persist"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
@@ -17,87 +18,157 @@ const (
 	sendotp_queue_binding_key        = "SendOTP.*"
 	sendotp_queue_name               = "otp_request"
 	otpcreated_queue_name            = "otps_created"
+	otpcreated_consumer_tag          = "auth-service"
+
+	otpcreated_retry_exchange_name = "verification.retry"
+	otpcreated_retry_queue_name    = "otps_created.retry"
+	deadletter_exchange_name       = "verification.dlx"
+	otpcreated_deadletter_queue    = "otps_created.dlq"
+	exchange_type_direct           = "direct"
+)
+
+var (
+	ErrPublishFailed = errors.New("failed to publish message")
+	ErrNotConsuming  = errors.New("not consuming otps_created")
 )
 
 type (
 	MQClient interface {
-		Consume()
-		Publish(ctx context.Context, msg string)
-	}
-
-	otpInfo struct {
-		PhoneNumber string `json:"phone_number"`
-		Otp         string `json:"otp"`
+		Consume(ctx context.Context)
+		Publish(ctx context.Context, phoneNumber string) error
+		State() ConnectionState
+		Err() error
+		// Check reports why the client can neither publish nor consume, nil when it can.
+		Check(ctx context.Context) error
+		Close() error
 	}
 
 	otpMQClient struct {
-		ch          *amqp.Channel
+		*connection
 		profileRepo persist.ProfileRepo
+		options     Options
+		consuming   atomic.Bool
 	}
 )
 
-func (otpRPub *otpMQClient) Publish(ctx context.Context, msg string) {
-	err := otpRPub.ch.PublishWithContext(ctx,
+func (otpRPub *otpMQClient) Publish(ctx context.Context, phoneNumber string) error {
+	env, err := newEnvelope(ctx, MessageTypeOtpRequested, &authv1.OtpRequested{PhoneNumber: phoneNumber})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	msg, err := toPublishing(env, otpRPub.options.ContentType)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	ctx, span := startPublish(ctx, sendotp_exchange_name, sendotp_verification_routing_key, &msg)
+	defer func() { endSpan(span, err) }()
+
+	ch, err := otpRPub.channel(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	err = ch.PublishWithContext(ctx,
 		sendotp_exchange_name,
 		sendotp_verification_routing_key,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: "application/plain",
-			Body:        []byte(msg),
-		})
-	failOnError(err, "Failed to publish a message")
+		msg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+	return nil
 }
 
-func (otpEC *otpMQClient) Consume() {
-	msgs, err := otpEC.ch.Consume(otpcreated_queue_name, "", true, false, false, false, nil)
-	failOnError(err, "Failed to consume messages from queue")
-
-	var forever chan struct{}
-	for d := range msgs {
-		log.Printf("OtpCreated event: %s", d.Body)
-		otpInfo := getOtpInfo(d.Body)
-		otpEC.profileRepo.UpdateOTP(otpInfo.PhoneNumber, otpInfo.Otp)
+func (otpEC *otpMQClient) Check(ctx context.Context) error {
+	if err := otpEC.connection.Check(ctx); err != nil {
+		return err
+	}
+	if !otpEC.consuming.Load() {
+		return ErrNotConsuming
 	}
-	<-forever
+	return nil
 }
 
-func declareExchange(ch *amqp.Channel, name string) {
-	err := ch.ExchangeDeclare(name, exchange_type_topic, true, false, false, false, nil)
-	failOnError(err, fmt.Sprintf("failed to declare exchange: %s\n", name))
+func declareExchange(ch *amqp.Channel, name string, kind string) error {
+	if err := ch.ExchangeDeclare(name, kind, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange: %s, %w", name, err)
+	}
+	return nil
 }
 
-func declareQueue(ch *amqp.Channel) amqp.Queue {
+func declareQueue(ch *amqp.Channel) (amqp.Queue, error) {
 	q, err := ch.QueueDeclare(sendotp_queue_name, false, false, false, false, nil)
-	failOnError(err, "failed to declare a queue")
-	return q
+	if err != nil {
+		return q, fmt.Errorf("failed to declare a queue, %w", err)
+	}
+	return q, nil
 }
 
-func bindQueueToExchange(q amqp.Queue, exchange string, ch *amqp.Channel) {
-	err := ch.QueueBind(q.Name, sendotp_queue_binding_key, exchange, false, nil)
-	failOnError(err, fmt.Sprintf("failed to bind queue to exchange: %s\n", exchange))
+func bindQueueToExchange(q amqp.Queue, exchange string, ch *amqp.Channel) error {
+	if err := ch.QueueBind(q.Name, sendotp_queue_binding_key, exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue to exchange: %s, %w", exchange, err)
+	}
+	return nil
 }
 
-func failOnError(err error, msg string) {
-	if err != nil {
-		log.Panicf("%s: %s", msg, err)
+// declareDurableQueue declares name and binds it to exchange under the queue's own name.
+func declareDurableQueue(ch *amqp.Channel, name string, exchange string, args amqp.Table) error {
+	if _, err := ch.QueueDeclare(name, true, false, false, false, args); err != nil {
+		return fmt.Errorf("failed to declare queue: %s, %w", name, err)
 	}
+	if err := ch.QueueBind(name, otpcreated_queue_name, exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue: %s to exchange: %s, %w", name, exchange, err)
+	}
+	return nil
 }
 
-func NewOtpMQClient(amqpConn *amqp.Connection, profileRepo persist.ProfileRepo) MQClient {
-	ch, err := amqpConn.Channel()
-	failOnError(err, "failed to create message channel")
+func declareOtpTopology(ch *amqp.Channel) error {
+	if err := declareExchange(ch, sendotp_exchange_name, exchange_type_topic); err != nil {
+		return err
+	}
+
+	q, err := declareQueue(ch)
+	if err != nil {
+		return err
+	}
+	if err := bindQueueToExchange(q, sendotp_exchange_name, ch); err != nil {
+		return err
+	}
+
+	// otp-service publishes to otps_created through the default exchange, declared here too so
+	// consuming does not depend on it having done so first
+	if _, err := ch.QueueDeclare(otpcreated_queue_name, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue: %s, %w", otpcreated_queue_name, err)
+	}
 
-	declareExchange(ch, sendotp_exchange_name)
-	bindQueueToExchange(declareQueue(ch), sendotp_exchange_name, ch)
-	return &otpMQClient{ch, profileRepo}
+	// Retried deliveries sit in the retry queue until their per-message expiration,
+	// then get dead-lettered straight back onto otps_created via the default exchange.
+	if err := declareExchange(ch, otpcreated_retry_exchange_name, exchange_type_direct); err != nil {
+		return err
+	}
+	err = declareDurableQueue(ch, otpcreated_retry_queue_name, otpcreated_retry_exchange_name, amqp.Table{
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": otpcreated_queue_name,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := declareExchange(ch, deadletter_exchange_name, exchange_type_direct); err != nil {
+		return err
+	}
+	return declareDurableQueue(ch, otpcreated_deadletter_queue, deadletter_exchange_name, nil)
 }
 
-func getOtpInfo(event []byte) *otpInfo {
-	var info otpInfo
-	if err := json.Unmarshal(event, &info); err != nil {
-		failOnError(err, "failed to parse OTPcreated event")
-		return nil
+// NewOtpMQClient returns immediately; the connection is established, and re-established
+// after failures, in the background. Use State to observe it.
+func NewOtpMQClient(amqpAddress string, profileRepo persist.ProfileRepo, options Options) MQClient {
+	return &otpMQClient{
+		connection:  newConnection(amqpAddress, declareOtpTopology),
+		profileRepo: profileRepo,
+		options:     options.withDefaults(),
 	}
-	return &info
 }
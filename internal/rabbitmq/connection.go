@@ -0,0 +1,237 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	StateConnecting ConnectionState = iota
+	StateConnected
+	StateClosed
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+var (
+	ErrClientClosed = errors.New("rabbitmq client is closed")
+	ErrNotConnected = errors.New("rabbitmq is not connected")
+)
+
+type (
+	ConnectionState int32
+
+	// topologyFunc declares the exchanges, queues and bindings a fresh channel relies on.
+	topologyFunc func(ch *amqp.Channel) error
+
+	// connection keeps a single AMQP connection and channel alive, redialing with
+	// backoff and re-declaring the topology whenever the broker drops either of them.
+	connection struct {
+		address  string
+		topology topologyFunc
+
+		mu      sync.RWMutex
+		conn    *amqp.Connection
+		ch      *amqp.Channel
+		ready   chan struct{}
+		lastErr error
+
+		state     atomic.Int32
+		done      chan struct{}
+		closeOnce sync.Once
+		wg        sync.WaitGroup
+	}
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+func (c *connection) State() ConnectionState {
+	return ConnectionState(c.state.Load())
+}
+
+func (c *connection) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+
+// Check reports ErrNotConnected, with the reason of the last disconnect, while there is no open channel.
+func (c *connection) Check(ctx context.Context) error {
+	if c.State() == StateClosed {
+		return ErrClientClosed
+	}
+
+	c.mu.RLock()
+	ch, lastErr := c.ch, c.lastErr
+	c.mu.RUnlock()
+
+	if ch != nil && !ch.IsClosed() {
+		return nil
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%w: %w", ErrNotConnected, lastErr)
+	}
+	return ErrNotConnected
+}
+
+// channel blocks until a usable channel is available, ctx is done or the connection is closed.
+func (c *connection) channel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		c.mu.RLock()
+		ch, ready := c.ch, c.ready
+		c.mu.RUnlock()
+
+		if ch != nil && !ch.IsClosed() {
+			return ch, nil
+		}
+
+		select {
+		case <-ready:
+		case <-c.done:
+			return nil, ErrClientClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *connection) supervise() {
+	defer c.wg.Done()
+
+	for attempt := 0; ; attempt++ {
+		conn, ch, err := c.dial()
+		if err != nil {
+			c.setDisconnected(err)
+			delay := reconnectDelay(attempt)
+			slog.Warn("rabbitmq: connect failed, retrying", "delay", delay, "error", err)
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-c.done:
+				return
+			}
+		}
+
+		attempt = -1
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+		c.setConnected(conn, ch)
+		slog.Info("rabbitmq: connected")
+
+		var reason error
+		select {
+		case <-c.done:
+			ch.Close()
+			conn.Close()
+			return
+		case amqpErr := <-connClosed:
+			reason = closeReason(amqpErr, "connection closed")
+		case amqpErr := <-chClosed:
+			reason = closeReason(amqpErr, "channel closed")
+			conn.Close()
+		}
+
+		slog.Warn("rabbitmq: reconnecting", "reason", reason)
+		c.setDisconnected(reason)
+	}
+}
+
+func (c *connection) dial() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(c.address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if c.topology != nil {
+		if err := c.topology(ch); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, ch, nil
+}
+
+func (c *connection) setConnected(conn *amqp.Connection, ch *amqp.Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn, c.ch, c.lastErr = conn, ch, nil
+	c.state.Store(int32(StateConnected))
+	close(c.ready)
+}
+
+func (c *connection) setDisconnected(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ch != nil {
+		c.ready = make(chan struct{})
+	}
+	c.conn, c.ch, c.lastErr = nil, nil, err
+	c.state.Store(int32(StateConnecting))
+}
+
+func (c *connection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+		c.state.Store(int32(StateClosed))
+	})
+	return nil
+}
+
+func closeReason(amqpErr *amqp.Error, fallback string) error {
+	if amqpErr == nil {
+		return errors.New(fallback)
+	}
+	return amqpErr
+}
+
+// reconnectDelay is an exponential backoff with jitter, capped at maxReconnectDelay.
+func reconnectDelay(attempt int) time.Duration {
+	delay := maxReconnectDelay
+	if attempt < 16 {
+		delay = min(minReconnectDelay<<attempt, maxReconnectDelay)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func newConnection(address string, topology topologyFunc) *connection {
+	c := &connection{
+		address:  address,
+		topology: topology,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.supervise()
+	return c
+}
@@ -0,0 +1,239 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/logging"
+	"github.com/ilivestrong/auth-service/internal/metrics"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	retryCountHeader    = "x-retry-count"
+	failureReasonHeader = "x-failure-reason"
+
+	defaultPrefetch    = 10
+	defaultConcurrency = 1
+	defaultMaxRetries  = 5
+	defaultRetryDelay  = 5 * time.Second
+)
+
+type (
+	Options struct {
+		// ContentType selects how outgoing envelopes are encoded, ContentTypeJSON or ContentTypeProtobuf.
+		ContentType string
+		Prefetch    int
+		Concurrency int
+		MaxRetries  int
+		RetryDelay  time.Duration
+	}
+)
+
+func (opts Options) withDefaults() Options {
+	if opts.ContentType == "" {
+		opts.ContentType = ContentTypeJSON
+	}
+	if opts.Prefetch <= 0 {
+		opts.Prefetch = defaultPrefetch
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultConcurrency
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = defaultMaxRetries
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	return opts
+}
+
+// Consume reads OtpCreated events until ctx is done or the client is closed, resuming on every
+// reconnect. Deliveries are acknowledged manually once they are applied, retried or dead-lettered.
+// When ctx is done it returns as soon as the deliveries being handled are, unhandled ones are
+// left to the broker to redeliver.
+func (otpEC *otpMQClient) Consume(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		ch, err := otpEC.channel(ctx)
+		if err != nil {
+			return
+		}
+
+		msgs, err := otpEC.startConsuming(ch)
+		if err != nil {
+			delay := reconnectDelay(attempt)
+			slog.Warn("rabbitmq: failed to consume, retrying", "queue", otpcreated_queue_name, "delay", delay, "error", err)
+
+			select {
+			case <-time.After(delay):
+				continue
+			case <-otpEC.done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		attempt = -1
+		otpEC.consuming.Store(true)
+		var wg sync.WaitGroup
+		for i := 0; i < otpEC.options.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case d, ok := <-msgs:
+						if !ok {
+							return
+						}
+						otpEC.handleOtpCreated(ch, d)
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		otpEC.consuming.Store(false)
+
+		if ctx.Err() != nil {
+			ch.Cancel(otpcreated_consumer_tag, false)
+			return
+		}
+	}
+}
+
+func (otpEC *otpMQClient) startConsuming(ch *amqp.Channel) (<-chan amqp.Delivery, error) {
+	if err := ch.Qos(otpEC.options.Prefetch, 0, false); err != nil {
+		return nil, err
+	}
+	return ch.Consume(otpcreated_queue_name, otpcreated_consumer_tag, false, false, false, false, nil)
+}
+
+func (otpEC *otpMQClient) handleOtpCreated(ch *amqp.Channel, d amqp.Delivery) {
+	ctx, span := startConsume(d, otpcreated_queue_name)
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	ctx = logging.WithRequestID(ctx, d.CorrelationId)
+	metrics.ObserveConsumerLag(d.Timestamp)
+	slog.DebugContext(ctx, "OtpCreated event received", "message_id", d.MessageId)
+
+	otpInfo, err := decodeOtpCreated(d)
+	if err != nil {
+		otpEC.settle(d, otpEC.deadLetter(ch, d, err))
+		return
+	}
+
+	err = otpEC.profileRepo.UpdateOTP(ctx, otpInfo.GetPhoneNumber(), otpInfo.GetOtp())
+	if err == nil {
+		otpEC.settle(d, nil)
+		return
+	}
+
+	slog.WarnContext(ctx, "failed to update otp", "phone_number", otpInfo.GetPhoneNumber(), "error", err)
+	if retries := retryCount(d); retries < otpEC.options.MaxRetries {
+		otpEC.settle(d, otpEC.retry(ch, d, retries+1))
+		return
+	}
+	otpEC.settle(d, otpEC.deadLetter(ch, d, err))
+}
+
+// settle acks d once it has been handled or handed off, and requeues it if the hand-off failed.
+func (otpEC *otpMQClient) settle(d amqp.Delivery, handOffErr error) {
+	if handOffErr != nil {
+		slog.Error("rabbitmq: failed to hand off delivery, requeueing", "message_id", d.MessageId, "error", handOffErr)
+		if err := d.Nack(false, true); err != nil {
+			slog.Error("rabbitmq: failed to nack delivery", "message_id", d.MessageId, "error", err)
+		}
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		slog.Error("rabbitmq: failed to ack delivery", "message_id", d.MessageId, "error", err)
+	}
+}
+
+func (otpEC *otpMQClient) retry(ch *amqp.Channel, d amqp.Delivery, attempt int) error {
+	headers := copyHeaders(d.Headers)
+	headers[retryCountHeader] = int32(attempt)
+
+	return otpEC.republish(ch, otpcreated_retry_exchange_name, d, headers, strconv.FormatInt(otpEC.options.RetryDelay.Milliseconds(), 10))
+}
+
+func (otpEC *otpMQClient) deadLetter(ch *amqp.Channel, d amqp.Delivery, reason error) error {
+	slog.Warn("rabbitmq: dead-lettering OtpCreated event", "message_id", d.MessageId, "correlation_id", d.CorrelationId, "error", reason)
+
+	headers := copyHeaders(d.Headers)
+	headers[failureReasonHeader] = reason.Error()
+
+	return otpEC.republish(ch, deadletter_exchange_name, d, headers, "")
+}
+
+func (otpEC *otpMQClient) republish(ch *amqp.Channel, exchange string, d amqp.Delivery, headers amqp.Table, expiration string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return ch.PublishWithContext(ctx, exchange, otpcreated_queue_name, false, false, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     d.MessageId,
+		CorrelationId: d.CorrelationId,
+		Timestamp:     d.Timestamp,
+		Type:          d.Type,
+		Expiration:    expiration,
+		Body:          d.Body,
+	})
+}
+
+// decodeOtpCreated decodes the OtpCreated payload of d, also from the bare {phone_number, otp}
+// JSON otp-service published before envelopes, until it is upgraded.
+func decodeOtpCreated(d amqp.Delivery) (*authv1.OtpCreated, error) {
+	if isLegacyJSON(d.Body, d.ContentType) {
+		var otpCreated authv1.OtpCreated
+		if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(d.Body, &otpCreated); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+		}
+		return &otpCreated, nil
+	}
+
+	env, err := decodeEnvelope(d.Body, d.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var otpCreated authv1.OtpCreated
+	if err := unpackEnvelope(env, MessageTypeOtpCreated, &otpCreated); err != nil {
+		return nil, err
+	}
+	return &otpCreated, nil
+}
+
+func retryCount(d amqp.Delivery) int {
+	switch v := d.Headers[retryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+func copyHeaders(headers amqp.Table) amqp.Table {
+	copied := make(amqp.Table, len(headers)+1)
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
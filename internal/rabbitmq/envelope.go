@@ -0,0 +1,166 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+
+	"github.com/google/uuid"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+
+	MessageTypeOtpRequested = "otp.requested"
+	MessageTypeOtpCreated   = "otp.created"
+
+	// SchemaVersion is the envelope version this service produces and understands.
+	SchemaVersion = 1
+
+	schemaVersionHeader = "x-schema-version"
+)
+
+var (
+	ErrUnsupportedContentType   = errors.New("unsupported message content type")
+	ErrUnsupportedSchemaVersion = errors.New("unsupported message schema version")
+	ErrUnexpectedMessageType    = errors.New("unexpected message type")
+	ErrMalformedMessage         = errors.New("malformed message")
+)
+
+type correlationIDKey struct{}
+
+// WithCorrelationID makes outgoing messages published with ctx carry id as their correlation id.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+func correlationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+func newEnvelope(ctx context.Context, msgType string, payload proto.Message) (*authv1.Envelope, error) {
+	packed, err := anypb.New(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	messageID := uuid.New().String()
+	correlationID := correlationIDFromContext(ctx)
+	if correlationID == "" {
+		correlationID = messageID
+	}
+
+	return &authv1.Envelope{
+		MessageId:     messageID,
+		Type:          msgType,
+		SchemaVersion: SchemaVersion,
+		CorrelationId: correlationID,
+		Timestamp:     timestamppb.Now(),
+		Payload:       packed,
+	}, nil
+}
+
+func encodeEnvelope(env *authv1.Envelope, contentType string) ([]byte, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		return protojson.Marshal(env)
+	case ContentTypeProtobuf:
+		return proto.Marshal(env)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+}
+
+func decodeEnvelope(body []byte, contentType string) (*authv1.Envelope, error) {
+	var env authv1.Envelope
+
+	mediaType, err := parseContentType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case ContentTypeJSON:
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &env)
+	case ContentTypeProtobuf:
+		err = proto.Unmarshal(body, &env)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+
+	if env.GetSchemaVersion() != SchemaVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSchemaVersion, env.GetSchemaVersion())
+	}
+	return &env, nil
+}
+
+// parseContentType returns the media type of contentType without parameters such as charset.
+// Messages without one are taken as JSON, as otp-service sent them before envelopes.
+func parseContentType(contentType string) (string, error) {
+	if contentType == "" {
+		return ContentTypeJSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+	return mediaType, nil
+}
+
+// isLegacyJSON tells whether body is a bare JSON payload, as published before envelopes,
+// rather than an envelope.
+func isLegacyJSON(body []byte, contentType string) bool {
+	if mediaType, err := parseContentType(contentType); err != nil || mediaType != ContentTypeJSON {
+		return false
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return false
+	}
+	_, enveloped := fields["payload"]
+	return !enveloped
+}
+
+// unpackEnvelope checks env carries msgType and decodes its payload into dst.
+func unpackEnvelope(env *authv1.Envelope, msgType string, dst proto.Message) error {
+	if env.GetType() != msgType {
+		return fmt.Errorf("%w: got %q, want %q", ErrUnexpectedMessageType, env.GetType(), msgType)
+	}
+	if err := env.GetPayload().UnmarshalTo(dst); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedMessage, err)
+	}
+	return nil
+}
+
+// toPublishing encodes env and mirrors its metadata onto the AMQP properties so brokers
+// and consumers can route or inspect messages without decoding the body.
+func toPublishing(env *authv1.Envelope, contentType string) (amqp.Publishing, error) {
+	body, err := encodeEnvelope(env, contentType)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	return amqp.Publishing{
+		Headers:       amqp.Table{schemaVersionHeader: strconv.Itoa(int(env.GetSchemaVersion()))},
+		ContentType:   contentType,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     env.GetMessageId(),
+		CorrelationId: env.GetCorrelationId(),
+		Timestamp:     env.GetTimestamp().AsTime(),
+		Type:          env.GetType(),
+		Body:          body,
+	}, nil
+}
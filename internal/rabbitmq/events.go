@@ -0,0 +1,205 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/metrics"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	events_exchange_name = "auth.events"
+
+	EventProfileCreated  = "profile.created"
+	EventProfileVerified = "profile.verified"
+	EventSessionStarted  = "session.started"
+	EventSessionEnded    = "session.ended"
+	EventOtpFailed       = "otp.failed"
+
+	outboxSize         = 1024
+	outboxDrainTimeout = 5 * time.Second
+)
+
+var (
+	ErrOutboxFull = errors.New("event outbox is full")
+)
+
+type (
+	// EventPublisher emits domain events to the auth.events topic exchange, routed by event type.
+	EventPublisher interface {
+		PublishEvent(ctx context.Context, eventType string, event *authv1.ProfileEvent) error
+		State() ConnectionState
+		Err() error
+		// Check reports why events cannot be published, nil when they can.
+		Check(ctx context.Context) error
+		Close() error
+	}
+
+	eventPublisher struct {
+		*connection
+		contentType string
+		outbox      chan *outboxEvent
+		stop        chan struct{}
+		stopOnce    sync.Once
+		stopped     chan struct{}
+	}
+
+	outboxEvent struct {
+		env *authv1.Envelope
+		// parent is the span that raised the event, the delivery span continues its trace.
+		parent trace.SpanContext
+	}
+)
+
+// PublishEvent queues the event and returns without waiting for the broker, so a slow
+// or unavailable RabbitMQ never holds up the RPC that raised it.
+func (pub *eventPublisher) PublishEvent(ctx context.Context, eventType string, event *authv1.ProfileEvent) error {
+	env, err := newEnvelope(ctx, eventType, event)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	select {
+	case <-pub.stop:
+		return ErrClientClosed
+	default:
+	}
+
+	select {
+	case pub.outbox <- &outboxEvent{env: env, parent: trace.SpanContextFromContext(ctx)}:
+		return nil
+	default:
+		return ErrOutboxFull
+	}
+}
+
+func (pub *eventPublisher) run() {
+	defer close(pub.stopped)
+
+	for {
+		select {
+		case event := <-pub.outbox:
+			if !pub.deliver(event, pub.stop) {
+				pub.drain(event)
+				return
+			}
+		case <-pub.stop:
+			pub.drain(nil)
+			return
+		}
+	}
+}
+
+// drain delivers pending, if any, and whatever is still queued, giving up after outboxDrainTimeout.
+func (pub *eventPublisher) drain(pending *outboxEvent) {
+	deadline := make(chan struct{})
+	timer := time.AfterFunc(outboxDrainTimeout, func() { close(deadline) })
+	defer timer.Stop()
+
+	for {
+		if pending != nil && !pub.deliver(pending, deadline) {
+			dropped := 0
+			for ; pending != nil; pending = pub.next() {
+				metrics.MQPublishFailures.WithLabelValues(pending.env.GetType()).Inc()
+				dropped++
+			}
+			slog.Warn("rabbitmq: dropped undelivered events on shutdown", "count", dropped)
+			return
+		}
+
+		pending = pub.next()
+		if pending == nil {
+			return
+		}
+	}
+}
+
+// next takes the next queued event without waiting, nil when there is none.
+func (pub *eventPublisher) next() *outboxEvent {
+	select {
+	case event := <-pub.outbox:
+		return event
+	default:
+		return nil
+	}
+}
+
+// deliver keeps retrying event until it is published or abort is closed.
+func (pub *eventPublisher) deliver(event *outboxEvent, abort <-chan struct{}) bool {
+	env := event.env
+	msg, err := toPublishing(env, pub.contentType)
+	if err != nil {
+		slog.Error("rabbitmq: dropping event", "event_type", env.GetType(), "correlation_id", env.GetCorrelationId(), "error", err)
+		metrics.MQPublishFailures.WithLabelValues(env.GetType()).Inc()
+		return true
+	}
+
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), event.parent)
+	_, span := startPublish(ctx, events_exchange_name, env.GetType(), &msg)
+	defer func() { endSpan(span, err) }()
+
+	for attempt := 0; ; attempt++ {
+		err = pub.publish(env.GetType(), msg, abort)
+		if err == nil {
+			return true
+		}
+
+		delay := reconnectDelay(attempt)
+		slog.Warn("rabbitmq: failed to publish event, retrying", "event_type", env.GetType(), "delay", delay, "error", err)
+		metrics.MQPublishRetries.WithLabelValues(env.GetType()).Inc()
+		select {
+		case <-time.After(delay):
+		case <-abort:
+			return false
+		}
+	}
+}
+
+func (pub *eventPublisher) publish(routingKey string, msg amqp.Publishing, abort <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() {
+		select {
+		case <-abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ch, err := pub.channel(ctx)
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, events_exchange_name, routingKey, false, false, msg)
+}
+
+func (pub *eventPublisher) Close() error {
+	pub.stopOnce.Do(func() {
+		close(pub.stop)
+		<-pub.stopped
+	})
+	return pub.connection.Close()
+}
+
+func declareEventsTopology(ch *amqp.Channel) error {
+	return declareExchange(ch, events_exchange_name, exchange_type_topic)
+}
+
+func NewEventPublisher(amqpAddress string, options Options) EventPublisher {
+	pub := &eventPublisher{
+		connection:  newConnection(amqpAddress, declareEventsTopology),
+		contentType: options.withDefaults().ContentType,
+		outbox:      make(chan *outboxEvent, outboxSize),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	go pub.run()
+	return pub
+}
@@ -0,0 +1,339 @@
+package rabbitmq
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ilivestrong/auth-service/internal/logging"
+	"github.com/ilivestrong/auth-service/internal/metrics"
+	"github.com/ilivestrong/auth-service/internal/persist"
+	authv1 "github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	memoryQueueSize = 1024
+	fakeOtpDigits   = 6
+)
+
+type (
+	// MemoryBus is an in-process stand-in for RabbitMQ. Exchanges route with AMQP topic
+	// semantics and the default exchange ("") routes straight to the queue named by the key.
+	MemoryBus struct {
+		mu       sync.RWMutex
+		bindings map[string][]memoryBinding
+		queues   map[string]chan amqp.Delivery
+		done     chan struct{}
+		once     sync.Once
+	}
+
+	memoryBinding struct {
+		pattern string
+		queue   string
+	}
+
+	inMemoryMQClient struct {
+		bus         *MemoryBus
+		profileRepo persist.ProfileRepo
+		options     Options
+		consuming   atomic.Bool
+	}
+
+	inMemoryEventPublisher struct {
+		bus     *MemoryBus
+		options Options
+	}
+)
+
+func (bus *MemoryBus) queue(name string) chan amqp.Delivery {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	q, ok := bus.queues[name]
+	if !ok {
+		q = make(chan amqp.Delivery, memoryQueueSize)
+		bus.queues[name] = q
+	}
+	return q
+}
+
+func (bus *MemoryBus) bind(queue string, pattern string, exchange string) {
+	bus.queue(queue)
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.bindings[exchange] = append(bus.bindings[exchange], memoryBinding{pattern, queue})
+}
+
+func (bus *MemoryBus) publish(ctx context.Context, exchange string, routingKey string, msg amqp.Publishing) error {
+	var targets []string
+	if exchange == "" {
+		targets = []string{routingKey}
+	} else {
+		bus.mu.RLock()
+		for _, b := range bus.bindings[exchange] {
+			if topicMatches(b.pattern, routingKey) {
+				targets = append(targets, b.queue)
+			}
+		}
+		bus.mu.RUnlock()
+	}
+
+	for _, name := range targets {
+		d := amqp.Delivery{
+			Headers:       msg.Headers,
+			ContentType:   msg.ContentType,
+			MessageId:     msg.MessageId,
+			CorrelationId: msg.CorrelationId,
+			Timestamp:     msg.Timestamp,
+			Type:          msg.Type,
+			Exchange:      exchange,
+			RoutingKey:    routingKey,
+			Body:          msg.Body,
+		}
+
+		select {
+		case bus.queue(name) <- d:
+		case <-bus.done:
+			return ErrClientClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// consume calls handle for every message on queue until ctx is done or the bus is closed.
+func (bus *MemoryBus) consume(ctx context.Context, queue string, handle func(amqp.Delivery)) {
+	q := bus.queue(queue)
+	for {
+		select {
+		case d := <-q:
+			handle(d)
+		case <-bus.done:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (bus *MemoryBus) Close() error {
+	bus.once.Do(func() { close(bus.done) })
+	return nil
+}
+
+func (bus *MemoryBus) state() ConnectionState {
+	select {
+	case <-bus.done:
+		return StateClosed
+	default:
+		return StateConnected
+	}
+}
+
+func (bus *MemoryBus) check() error {
+	if bus.state() == StateClosed {
+		return ErrClientClosed
+	}
+	return nil
+}
+
+// topicMatches reports whether routingKey matches an AMQP topic binding pattern, where
+// "*" stands for exactly one word and "#" for zero or more words.
+func topicMatches(pattern string, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern []string, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	}
+	return len(key) > 0 && pattern[0] == key[0] && matchWords(pattern[1:], key[1:])
+}
+
+func (client *inMemoryMQClient) Publish(ctx context.Context, phoneNumber string) error {
+	env, err := newEnvelope(ctx, MessageTypeOtpRequested, &authv1.OtpRequested{PhoneNumber: phoneNumber})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	msg, err := toPublishing(env, client.options.ContentType)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	ctx, span := startPublish(ctx, sendotp_exchange_name, sendotp_verification_routing_key, &msg)
+	defer func() { endSpan(span, err) }()
+
+	if err = client.bus.publish(ctx, sendotp_exchange_name, sendotp_verification_routing_key, msg); err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+	return nil
+}
+
+func (client *inMemoryMQClient) Consume(ctx context.Context) {
+	client.consuming.Store(true)
+	defer client.consuming.Store(false)
+
+	client.bus.consume(ctx, otpcreated_queue_name, func(d amqp.Delivery) {
+		ctx, span := startConsume(d, otpcreated_queue_name)
+		var err error
+		defer func() { endSpan(span, err) }()
+
+		otpCreated, err := decodeOtpCreated(d)
+		if err != nil {
+			slog.ErrorContext(ctx, "memory bus: dropping OtpCreated event", "error", err)
+			return
+		}
+
+		ctx = logging.WithRequestID(ctx, d.CorrelationId)
+		metrics.ObserveConsumerLag(d.Timestamp)
+		if err = client.profileRepo.UpdateOTP(ctx, otpCreated.GetPhoneNumber(), otpCreated.GetOtp()); err != nil {
+			slog.WarnContext(ctx, "failed to update otp", "phone_number", otpCreated.GetPhoneNumber(), "error", err)
+		}
+	})
+}
+
+func (client *inMemoryMQClient) State() ConnectionState { return client.bus.state() }
+func (client *inMemoryMQClient) Err() error             { return nil }
+
+func (client *inMemoryMQClient) Check(ctx context.Context) error {
+	if err := client.bus.check(); err != nil {
+		return err
+	}
+	if !client.consuming.Load() {
+		return ErrNotConsuming
+	}
+	return nil
+}
+
+func (client *inMemoryMQClient) Close() error { return client.bus.Close() }
+
+func (pub *inMemoryEventPublisher) PublishEvent(ctx context.Context, eventType string, event *authv1.ProfileEvent) error {
+	env, err := newEnvelope(ctx, eventType, event)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	msg, err := toPublishing(env, pub.options.ContentType)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishFailed, err)
+	}
+
+	ctx, span := startPublish(ctx, events_exchange_name, eventType, &msg)
+	err = pub.bus.publish(ctx, events_exchange_name, eventType, msg)
+	endSpan(span, err)
+	return err
+}
+
+func (pub *inMemoryEventPublisher) State() ConnectionState { return pub.bus.state() }
+func (pub *inMemoryEventPublisher) Err() error             { return nil }
+
+func (pub *inMemoryEventPublisher) Check(ctx context.Context) error { return pub.bus.check() }
+func (pub *inMemoryEventPublisher) Close() error                    { return pub.bus.Close() }
+
+// runFakeOtpService plays the part of otp-service: it answers every OtpRequested message
+// with a random code on otps_created and prints the code to stdout instead of sending an SMS.
+// It is printed outside the logs, which redact OTPs, as a developer running the service
+// locally has no other way to get it.
+func runFakeOtpService(bus *MemoryBus, contentType string) {
+	bus.consume(context.Background(), sendotp_queue_name, func(d amqp.Delivery) {
+		ctx, span := startConsume(d, sendotp_queue_name)
+		defer span.End()
+
+		env, err := decodeEnvelope(d.Body, d.ContentType)
+		if err != nil {
+			slog.Error("fake otp service: dropping message", "error", err)
+			return
+		}
+
+		var req authv1.OtpRequested
+		if err := unpackEnvelope(env, MessageTypeOtpRequested, &req); err != nil {
+			slog.Error("fake otp service: dropping message", "error", err)
+			return
+		}
+
+		otp, err := generateOtp()
+		if err != nil {
+			slog.Error("fake otp service: failed to generate otp", "error", err)
+			return
+		}
+
+		ctx = WithCorrelationID(logging.WithRequestID(ctx, env.GetCorrelationId()), env.GetCorrelationId())
+		slog.InfoContext(ctx, "fake otp service: otp sent", "phone_number", req.GetPhoneNumber())
+		fmt.Fprintf(os.Stdout, "fake otp service: otp for %s is %s\n", req.GetPhoneNumber(), otp)
+
+		reply, err := newEnvelope(ctx, MessageTypeOtpCreated, &authv1.OtpCreated{PhoneNumber: req.GetPhoneNumber(), Otp: otp})
+		if err != nil {
+			slog.Error("fake otp service: failed to reply", "error", err)
+			return
+		}
+
+		// the reply continues the trace of the request, as otp-service is expected to do
+		msg, err := toPublishing(reply, contentType)
+		if err == nil {
+			publishCtx, publishSpan := startPublish(ctx, "", otpcreated_queue_name, &msg)
+			err = bus.publish(publishCtx, "", otpcreated_queue_name, msg)
+			endSpan(publishSpan, err)
+		}
+		if err != nil {
+			slog.Error("fake otp service: failed to publish OtpCreated", "error", err)
+		}
+	})
+}
+
+func generateOtp() (string, error) {
+	limit := big.NewInt(1)
+	for i := 0; i < fakeOtpDigits; i++ {
+		limit.Mul(limit, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", fakeOtpDigits, n), nil
+}
+
+// NewMemoryBus returns a bus with the same verification topology RabbitMQ gets, and
+// starts the fake OTP generator on it.
+func NewMemoryBus(options Options) *MemoryBus {
+	bus := &MemoryBus{
+		bindings: make(map[string][]memoryBinding),
+		queues:   make(map[string]chan amqp.Delivery),
+		done:     make(chan struct{}),
+	}
+	bus.bind(sendotp_queue_name, sendotp_queue_binding_key, sendotp_exchange_name)
+	bus.queue(otpcreated_queue_name)
+
+	go runFakeOtpService(bus, options.withDefaults().ContentType)
+	return bus
+}
+
+func NewInMemoryMQClient(bus *MemoryBus, profileRepo persist.ProfileRepo, options Options) MQClient {
+	return &inMemoryMQClient{bus: bus, profileRepo: profileRepo, options: options.withDefaults()}
+}
+
+func NewInMemoryEventPublisher(bus *MemoryBus, options Options) EventPublisher {
+	return &inMemoryEventPublisher{bus, options.withDefaults()}
+}
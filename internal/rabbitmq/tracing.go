@@ -0,0 +1,86 @@
+package rabbitmq
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	tracer = otel.Tracer("github.com/ilivestrong/auth-service/internal/rabbitmq")
+)
+
+type (
+	// headerCarrier reads and writes the W3C trace context, traceparent and tracestate, in
+	// AMQP headers, so traces continue in consumers of the message.
+	headerCarrier amqp.Table
+)
+
+func (hc headerCarrier) Get(key string) string {
+	value, _ := hc[key].(string)
+	return value
+}
+
+func (hc headerCarrier) Set(key string, value string) {
+	hc[key] = value
+}
+
+func (hc headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(hc))
+	for key := range hc {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// startPublish starts the producer span of msg and adds its trace context to the headers of msg.
+func startPublish(ctx context.Context, exchange string, routingKey string, msg *amqp.Publishing) (context.Context, trace.Span) {
+	ctx, span := tracer.Start(ctx, destination(exchange, routingKey)+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(exchange, routingKey, msg.MessageId)...),
+	)
+
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Headers))
+	return ctx, span
+}
+
+// startConsume starts the consumer span of d, as child of the span that published it.
+func startConsume(d amqp.Delivery, queue string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(d.Headers))
+	return tracer.Start(ctx, queue+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messagingAttributes(d.Exchange, d.RoutingKey, d.MessageId)...),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func messagingAttributes(exchange string, routingKey string, messageID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination.name", exchange),
+		attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+		attribute.String("messaging.message.id", messageID),
+	}
+}
+
+// destination names the default exchange by the queue messages are routed to.
+func destination(exchange string, routingKey string) string {
+	if exchange == "" {
+		return routingKey
+	}
+	return exchange
+}
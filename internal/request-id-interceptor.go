@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+	"github.com/ilivestrong/auth-service/internal/logging"
+	mq "github.com/ilivestrong/auth-service/internal/rabbitmq"
+)
+
+var (
+	// validRequestID limits the request ids taken from callers, others are replaced.
+	validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+type (
+	requestIDInterceptor struct{}
+)
+
+func (ri *requestIDInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return connect.UnaryFunc(func(
+		ctx context.Context,
+		req connect.AnyRequest,
+	) (connect.AnyResponse, error) {
+		start := time.Now()
+		ctx, id := withRequestID(ctx, req.Header())
+
+		res, err := next(ctx, req)
+		var connectErr *connect.Error
+		if err == nil {
+			res.Header().Set(RequestIDHeader, id)
+		} else if errors.As(err, &connectErr) {
+			connectErr.Meta().Set(RequestIDHeader, id)
+		}
+
+		logCall(ctx, req.Spec().Procedure, start, err)
+		return res, err
+	})
+}
+
+func (ri *requestIDInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (ri *requestIDInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return connect.StreamingHandlerFunc(func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		start := time.Now()
+		ctx, id := withRequestID(ctx, conn.RequestHeader())
+		conn.ResponseHeader().Set(RequestIDHeader, id)
+
+		err := next(ctx, conn)
+		logCall(ctx, conn.Spec().Procedure, start, err)
+		return err
+	})
+}
+
+// withRequestID takes the X-Request-Id of the caller, or generates one, and adds it to ctx
+// for logs, audit events and the correlation id of published messages.
+func withRequestID(ctx context.Context, header http.Header) (context.Context, string) {
+	id := header.Get(RequestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = uuid.New().String()
+	}
+
+	ctx = logging.WithRequestID(ctx, id)
+	return mq.WithCorrelationID(ctx, id), id
+}
+
+func logCall(ctx context.Context, procedure string, start time.Time, err error) {
+	attrs := []any{slog.String("procedure", procedure), slog.Duration("duration", time.Since(start))}
+	if err != nil {
+		slog.WarnContext(ctx, "rpc failed", append(attrs, slog.String("code", connect.CodeOf(err).String()), slog.Any("error", err))...)
+		return
+	}
+	slog.InfoContext(ctx, "rpc finished", attrs...)
+}
+
+// NewRequestIDInterceptor gives every RPC a request id and logs its outcome. It must come
+// before the other interceptors so the id is known to them, only tracing goes first.
+func NewRequestIDInterceptor() connect.Interceptor {
+	return &requestIDInterceptor{}
+}
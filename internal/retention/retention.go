@@ -0,0 +1,311 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilivestrong/auth-service/internal/export"
+	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/persist"
+)
+
+const (
+	defaultRuleName  = "default"
+	defaultBatchSize = 1000
+	defaultInterval  = time.Hour
+
+	// the lease is renewed before every batch, so it only runs out when its holder died
+	purgeLeaseName = "event-retention-purge"
+	purgeLeaseTTL  = 5 * time.Minute
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid retention policy")
+	ErrPurgeRunning  = errors.New("events are being purged by another instance")
+)
+
+type (
+	// Policy says how long events are kept. PerType overrides Default for the listed event
+	// types, a zero duration keeps events forever.
+	Policy struct {
+		Default time.Duration
+		PerType map[string]time.Duration
+	}
+
+	Options struct {
+		Policy    Policy
+		Interval  time.Duration
+		BatchSize int
+		// DryRun only counts what would be purged.
+		DryRun bool
+		// ArchiveDir, when set, gets every purged event appended to an NDJSON file
+		// before it is deleted.
+		ArchiveDir string
+		// Lease, when set, keeps purges to one instance at a time.
+		Lease persist.LeaseRepo
+	}
+
+	Report struct {
+		DryRun bool
+		Rules  []RuleReport
+	}
+
+	RuleReport struct {
+		Name   string
+		Before time.Time
+		// Events is the number of events purged, or that would be purged on a dry run.
+		Events int64
+	}
+
+	Purger struct {
+		repo    persist.EventRepo
+		options Options
+		owner   string
+	}
+)
+
+// ParsePolicy reads a comma separated list of <event type>=<duration> pairs, where the
+// event type "default" covers all types not listed. Durations are Go durations or whole
+// days such as "90d", e.g. "default=90d,PROFILE_VIEW=7d,PROFILE_LOGIN=365d".
+func ParsePolicy(spec string) (Policy, error) {
+	policy := Policy{PerType: make(map[string]time.Duration)}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		eventType, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(eventType) == "" {
+			return Policy{}, fmt.Errorf("%w: %q is not <event type>=<duration>", ErrInvalidPolicy, pair)
+		}
+
+		d, err := parseDuration(strings.TrimSpace(value))
+		if err != nil || d < 0 {
+			return Policy{}, fmt.Errorf("%w: invalid duration %q for %s", ErrInvalidPolicy, value, eventType)
+		}
+
+		if eventType = strings.TrimSpace(eventType); eventType == defaultRuleName {
+			policy.Default = d
+		} else {
+			policy.PerType[eventType] = d
+		}
+	}
+	return policy, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (policy Policy) IsEmpty() bool {
+	if policy.Default > 0 {
+		return false
+	}
+	for _, d := range policy.PerType {
+		if d > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (policy Policy) rules(now time.Time) []persist.RetentionRule {
+	eventTypes := make([]string, 0, len(policy.PerType))
+	for eventType := range policy.PerType {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+
+	var rules []persist.RetentionRule
+	for _, eventType := range eventTypes {
+		if d := policy.PerType[eventType]; d > 0 {
+			rules = append(rules, persist.RetentionRule{Name: eventType, EventTypes: []string{eventType}, Before: now.Add(-d)})
+		}
+	}
+
+	// explicitly listed types are never covered by the default, even when kept forever
+	if policy.Default > 0 {
+		rules = append(rules, persist.RetentionRule{Name: defaultRuleName, ExcludeTypes: eventTypes, Before: now.Add(-policy.Default)})
+	}
+	return rules
+}
+
+// PurgeOnce applies every rule of the policy, batch by batch, until nothing expired is left.
+// It fails with ErrPurgeRunning when another instance holds the purge lease.
+func (pg *Purger) PurgeOnce(ctx context.Context) (*Report, error) {
+	report := &Report{DryRun: pg.options.DryRun}
+
+	if !pg.options.DryRun && pg.options.Lease != nil {
+		if err := pg.lease(ctx); err != nil {
+			return report, err
+		}
+		defer pg.options.Lease.Release(context.WithoutCancel(ctx), purgeLeaseName, pg.owner)
+	}
+
+	var archive *archiver
+	if pg.options.ArchiveDir != "" && !pg.options.DryRun {
+		archive = &archiver{dir: pg.options.ArchiveDir}
+		defer archive.Close()
+	}
+
+	for _, rule := range pg.options.Policy.rules(time.Now()) {
+		ruleReport := RuleReport{Name: rule.Name, Before: rule.Before}
+
+		if pg.options.DryRun {
+			count, err := pg.repo.CountExpired(ctx, rule)
+			if err != nil {
+				return report, err
+			}
+			ruleReport.Events = count
+			report.Rules = append(report.Rules, ruleReport)
+			continue
+		}
+
+		for ctx.Err() == nil {
+			var archiveBatch func(events []models.Event) error
+			if archive != nil {
+				archiveBatch = archive.Write
+			}
+
+			if pg.options.Lease != nil {
+				if err := pg.lease(ctx); err != nil {
+					return report, err
+				}
+			}
+
+			purged, err := pg.repo.PurgeExpired(ctx, rule, pg.options.BatchSize, archiveBatch)
+			if err != nil {
+				return report, err
+			}
+
+			ruleReport.Events += int64(purged)
+			if purged < pg.options.BatchSize {
+				break
+			}
+		}
+		report.Rules = append(report.Rules, ruleReport)
+	}
+	return report, ctx.Err()
+}
+
+// lease takes or renews the purge lease.
+func (pg *Purger) lease(ctx context.Context) error {
+	acquired, err := pg.options.Lease.Acquire(ctx, purgeLeaseName, pg.owner, purgeLeaseTTL)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrPurgeRunning
+	}
+	return nil
+}
+
+// Run purges once every Interval until ctx is done.
+func (pg *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(pg.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := pg.PurgeOnce(ctx)
+		switch {
+		case errors.Is(err, ErrPurgeRunning):
+			slog.Info("event retention purge skipped", "reason", err)
+		case err != nil && ctx.Err() == nil:
+			slog.Error("event retention purge failed", "error", err)
+		}
+		if report != nil {
+			report.log()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (report *Report) log() {
+	verb := "purged"
+	if report.DryRun {
+		verb = "would purge"
+	}
+
+	for _, r := range report.Rules {
+		if r.Events > 0 || report.DryRun {
+			slog.Info("event retention: "+verb, "events", r.Events, "rule", r.Name, "before", r.Before.Format(time.RFC3339))
+		}
+	}
+}
+
+func NewPurger(repo persist.EventRepo, options Options) *Purger {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultBatchSize
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultInterval
+	}
+	hostname, _ := os.Hostname()
+	return &Purger{repo, options, fmt.Sprintf("%s:%d", hostname, os.Getpid())}
+}
+
+// archiver appends purged events to one NDJSON file per purge run, syncing each batch
+// to disk before the batch gets deleted. Phone numbers are redacted, as the archive is
+// kept outside the encrypted database.
+type archiver struct {
+	dir  string
+	file *os.File
+}
+
+func (a *archiver) Write(events []models.Event) error {
+	if a.file == nil {
+		if err := os.MkdirAll(a.dir, 0o750); err != nil {
+			return err
+		}
+
+		name := filepath.Join(a.dir, fmt.Sprintf("events-%s.ndjson", time.Now().UTC().Format("20060102T150405Z")))
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+		if err != nil {
+			return err
+		}
+		a.file = f
+	}
+
+	w, err := export.NewEventWriter(a.file, export.Options{Format: export.FormatNDJSON, RedactPhoneNumbers: true})
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		if err := w.Write(&events[i]); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+func (a *archiver) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
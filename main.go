@@ -1,148 +1,488 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
 	"connectrpc.com/connect"
+	"connectrpc.com/grpchealth"
+	"connectrpc.com/otelconnect"
 	"github.com/ilivestrong/auth-service/internal"
-
-	"github.com/ilivestrong/auth-service/internal/models"
+	"github.com/ilivestrong/auth-service/internal/certs"
+	"github.com/ilivestrong/auth-service/internal/config"
+	"github.com/ilivestrong/auth-service/internal/dataexport"
+	"github.com/ilivestrong/auth-service/internal/health"
+	"github.com/ilivestrong/auth-service/internal/lifecycle"
+	"github.com/ilivestrong/auth-service/internal/logging"
+	"github.com/ilivestrong/auth-service/internal/metrics"
+	"github.com/ilivestrong/auth-service/internal/migrate"
 	"github.com/ilivestrong/auth-service/internal/persist"
+	"github.com/ilivestrong/auth-service/internal/pii"
 	"github.com/ilivestrong/auth-service/internal/protos/gen/auth/v1/authv1connect"
 	mq "github.com/ilivestrong/auth-service/internal/rabbitmq"
+	"github.com/ilivestrong/auth-service/internal/retention"
+	"github.com/ilivestrong/auth-service/internal/tracing"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
 	"github.com/joho/godotenv"
-	amqp "github.com/rabbitmq/amqp091-go"
-)
-
-type (
-	Options struct {
-		AMQPAddress          string
-		DBHost               string
-		DBName               string
-		DBUsername           string
-		DBPassword           string
-		DBPort               string
-		Port                 string
-		TokenExpiryInMinutes int
-	}
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const API_Prefix = "/api/"
 
+// budgets kept back for the shutdown stages from the ones before them, the HTTP server gets
+// what is left of SHUTDOWN_TIMEOUT_IN_SECONDS
+const (
+	consumerShutdownBudget = 3 * time.Second
+	jobsShutdownBudget     = 2 * time.Second
+	outboxShutdownBudget   = 5 * time.Second
+	tracesShutdownBudget   = 2 * time.Second
+	closeShutdownBudget    = time.Second
+)
+
 func main() {
-	err := godotenv.Load()
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("failed to load .env file, %v", err)
+	}
+
+	cfg, args, err := config.Load(config.Options{Args: os.Args[1:], LookupEnv: os.LookupEnv, Usage: usage})
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if len(args) > 0 {
+		runCommand(cfg, args)
+		return
+	}
+	configure(cfg)
+	runServer(cfg)
+}
+
+// configure exits with status 2 unless the settings of sections, all of them when none are
+// named, are valid, and then sets up logging. Settings parsed by other packages are checked
+// with their parsers here, which keeps package config free of them.
+func configure(cfg *config.Config, sections ...string) {
+	v := cfg.Validate(sections...)
+	v.Ensure("mq.content_type", cfg.MQ.ContentType == mq.ContentTypeJSON || cfg.MQ.ContentType == mq.ContentTypeProtobuf,
+		fmt.Sprintf("must be %s or %s", mq.ContentTypeJSON, mq.ContentTypeProtobuf))
+	if cfg.PII.Keys != "" {
+		_, err := pii.ParseKeys(cfg.PII.Keys)
+		v.Check("pii.keys", err)
+	}
+	if cfg.Audit.HashKey != "" {
+		_, err := persist.ParseChainKey(cfg.Audit.HashKey)
+		v.Check("audit.hash_key", err)
+	}
+	_, err := retention.ParsePolicy(cfg.Retention.Policy)
+	v.Check("retention.policy", err)
+	v.Ensure("log.format", cfg.Log.Format == logging.FormatText || cfg.Log.Format == logging.FormatJSON,
+		fmt.Sprintf("must be %s or %s", logging.FormatText, logging.FormatJSON))
+	_, err = logging.ParseLevel(cfg.Log.Level)
+	v.Check("log.level", err)
+	switch cfg.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		v.Invalid("tracing.exporter", fmt.Sprintf("must be %s, %s or %s", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout))
 	}
 
-	options := &Options{
-		AMQPAddress: mustGetEnv("AMQP_ADDRESS"),
-		DBHost:      mustGetEnv("DB_HOST"),
-		DBName:      mustGetEnv("DB_NAME"),
-		DBUsername:  mustGetEnv("DB_USERNAME"),
-		DBPassword:  mustGetEnv("DB_PASSWORD"),
-		DBPort:      mustGetEnv("DB_PORT"),
-		Port:        mustGetEnv("PORT"),
+	if err := v.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
+	setupLogging(cfg.Log)
+}
 
-	tokenExpiryInMinutes, err := strconv.Atoi(mustGetEnv("TOKEN_EXPIRY_IN_MINUTES"))
+func runServer(cfg *config.Config) {
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingOptions(cfg))
+	if err != nil {
+		log.Fatalf("failed to set up tracing, %v", err)
+	}
+	otelInterceptor, err := otelconnect.NewInterceptor(otelconnect.WithoutMetrics())
 	if err != nil {
-		options.TokenExpiryInMinutes = 2
+		log.Fatalf("failed to set up tracing, %v", err)
 	}
-	options.TokenExpiryInMinutes = tokenExpiryInMinutes
 
 	loggedInUsersCache := internal.NewInMemoryCache()
 
-	db := bootDB(options)
-	profileRepo := persist.NewProfileRepository(db)
-	eventRepo := persist.NewEventRepository(db)
+	db, replicas := bootDB(cfg)
+	if unindexed, err := persist.UnindexedProfiles(context.Background(), db); err != nil || unindexed > 0 {
+		log.Fatalf("%d profiles are not encrypted yet, run `pii rotate` first (%v)", unindexed, err)
+	}
+	keyring := piiKeys(cfg)
+	dbTimeout := queryTimeout(cfg)
+	profileRepo := persist.NewProfileRepository(db, persist.ProfileRepoOptions{
+		OtpTTL:       time.Duration(cfg.Auth.OtpTTLInMinutes) * time.Minute,
+		PII:          keyring,
+		Replicas:     replicas,
+		QueryTimeout: dbTimeout,
+	})
+	eventRepo := persist.NewEventRepository(db, persist.EventRepoOptions{
+		HashChain:    cfg.Audit.HashChain,
+		ChainKey:     optionalChainKey(cfg),
+		PII:          keyring,
+		Replicas:     replicas,
+		QueryTimeout: dbTimeout,
+	})
 
-	amqp := bootMQ(options)
-	mqclient := mq.NewOtpMQClient(amqp, profileRepo)
-	authenticator := internal.NewAuthenticator(options.TokenExpiryInMinutes)
+	mqOptions := mq.Options{
+		ContentType: cfg.MQ.ContentType,
+		Prefetch:    cfg.MQ.Prefetch,
+		Concurrency: cfg.MQ.Concurrency,
+		MaxRetries:  cfg.MQ.MaxRetries,
+		RetryDelay:  time.Duration(cfg.MQ.RetryDelayInSecs) * time.Second,
+	}
+	mqclient, eventPublisher := bootMQ(cfg, mqOptions, profileRepo)
+	authenticator := internal.NewAuthenticator(cfg.Auth.TokenExpiryInMinutes)
+	exporter := dataexport.NewExporter(persist.NewDataExportRepository(db, persist.DataExportRepoOptions{PII: keyring, QueryTimeout: dbTimeout}), profileRepo, eventRepo, dataExportOptions(cfg))
 	authSvc := internal.NewAuthService(
 		profileRepo,
 		eventRepo,
+		persist.NewUnitOfWork(db, dbTimeout),
 		mqclient,
+		eventPublisher,
 		authenticator,
 		loggedInUsersCache,
+		exporter,
+	)
+	metrics.RegisterActiveSessions(loggedInUsersCache.Len)
+	interceptors := connect.WithInterceptors(
+		otelInterceptor,
+		internal.NewRequestIDInterceptor(),
+		internal.NewMetricsInterceptor(),
+		internal.NewAuditInterceptor(eventRepo),
+		internal.NewTokenInterceptor(authenticator, loggedInUsersCache, cfg.Auth.AdminToken),
 	)
-	interceptors := connect.WithInterceptors(internal.NewTokenInterceptor(authenticator, loggedInUsersCache))
 
-	go mqclient.Consume()
+	var consumer sync.WaitGroup
+	consumerCtx, stopConsumer := context.WithCancel(context.Background())
+	goWithContext(consumerCtx, &consumer, mqclient.Consume)
+
+	var jobs sync.WaitGroup
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	if purgeOptions := retentionOptions(cfg); !purgeOptions.Policy.IsEmpty() {
+		purgeOptions.Lease = persist.NewLeaseRepository(db)
+		goWithContext(jobsCtx, &jobs, retention.NewPurger(eventRepo, purgeOptions).Run)
+	}
+	goWithContext(jobsCtx, &jobs, exporter.Run)
+	if replicas != nil {
+		goWithContext(jobsCtx, &jobs, replicas.Run)
+	}
 
+	authPath, authHandler := authv1connect.NewAuthServiceHandler(authSvc, interceptors, connect.WithReadMaxBytes(cfg.Server.MaxBodyBytes))
 	mux := http.NewServeMux()
-	mux.Handle(authv1connect.NewAuthServiceHandler(authSvc, interceptors))
+	mux.Handle(authPath, authHandler)
 
 	mux2 := http.NewServeMux()
 	mux2.Handle(API_Prefix, http.StripPrefix("/api", mux))
+	// gRPC clients cannot be given a path prefix, they call the service at the root
+	mux2.Handle(authPath, authHandler)
+	mux2.Handle("/metrics", metrics.Handler())
 
-	log.Printf("listening at localhost:%s\n", options.Port)
-	go http.ListenAndServe(fmt.Sprintf("localhost:%s", options.Port), mux2)
+	checker := health.NewChecker(health.Options{
+		Checks: map[string]health.Check{
+			"database":        pingDB(db),
+			"rabbitmq":        mqclient.Check,
+			"event_publisher": eventPublisher.Check,
+			"cache":           loggedInUsersCache.Ping,
+		},
+		Services: []string{authv1connect.AuthServiceName},
+	})
+	mux2.Handle("/healthz", health.LiveHandler())
+	mux2.Handle("/readyz", checker.ReadyHandler())
+	mux2.Handle(grpchealth.NewHandler(checker))
 
-	shutdownOnSignal(db, amqp)
+	var reloader *certs.Reloader
+	if cfg.Server.TLSCertFile != "" {
+		reloader, err = certs.NewReloader(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile, 0)
+		if err != nil {
+			log.Fatalf("failed to load tls certificate, %v", err)
+		}
+		goWithContext(jobsCtx, &jobs, reloader.Run)
+	}
+
+	server := newHTTPServer(cfg, mux2, reloader)
+	useTLS := reloader != nil
+	slog.Info("listening", "address", server.Addr, "tls", useTLS, "h2c", cfg.Server.H2C)
+	go serve(server, useTLS)
+
+	// every stage stops what depends on the ones after it
+	shutdown := lifecycle.NewManager(time.Duration(cfg.Server.ShutdownTimeoutInSecs) * time.Second)
+	drainDelay := time.Duration(cfg.Server.DrainDelayInSecs) * time.Second
+	shutdown.OnShutdown("readiness", 0, func(ctx context.Context) error {
+		checker.Drain()
+		return nil
+	})
+	// load balancers take a few probes to notice, until then new requests keep coming
+	shutdown.OnShutdown("drain delay", drainDelay, lifecycle.Pause(drainDelay))
+	shutdown.OnShutdown("http server", 0, server.Shutdown)
+	shutdown.OnShutdown("mq consumer", consumerShutdownBudget, lifecycle.Cancel(stopConsumer, &consumer))
+	shutdown.OnShutdown("background jobs", jobsShutdownBudget, lifecycle.Cancel(stopJobs, &jobs))
+	shutdown.OnShutdown("event outbox", outboxShutdownBudget, func(ctx context.Context) error {
+		return eventPublisher.Close()
+	})
+	shutdown.OnShutdown("traces", tracesShutdownBudget, shutdownTracing)
+	shutdown.OnShutdown("database", closeShutdownBudget, func(ctx context.Context) error {
+		// a consumer or job that ran out of time still gets to finish its queries
+		return errors.Join(lifecycle.Wait(ctx, &consumer, &jobs), closeDB(db, replicas))
+	})
+	shutdown.OnShutdown("rabbitmq", closeShutdownBudget, func(ctx context.Context) error {
+		return mqclient.Close()
+	})
+
+	if err := shutdown.WaitForSignal(syscall.SIGINT, syscall.SIGTERM); err != nil {
+		slog.Error("shutdown incomplete", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("shutdown complete")
+}
+
+// newHTTPServer serves handler over HTTPS with the certificate of reloader when given, and
+// otherwise over plain HTTP/1.1, plus HTTP/2 with prior knowledge when h2c is enabled.
+func newHTTPServer(cfg *config.Config, handler http.Handler, reloader *certs.Reloader) *http.Server {
+	server := &http.Server{
+		Addr:              net.JoinHostPort(cfg.Server.BindAddress, strconv.Itoa(cfg.Server.Port)),
+		Handler:           http.MaxBytesHandler(handler, int64(cfg.Server.MaxBodyBytes)),
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeoutInSecs) * time.Second,
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeoutInSecs) * time.Second,
+	}
+
+	if reloader != nil {
+		server.TLSConfig = reloader.TLSConfig()
+	}
+
+	if cfg.Server.H2C {
+		// registered with the server, so Shutdown drains HTTP/2 connections as well
+		h2s := &http2.Server{IdleTimeout: server.IdleTimeout}
+		if err := http2.ConfigureServer(server, h2s); err != nil {
+			log.Fatalf("failed to configure h2c, %v", err)
+		}
+		server.Handler = h2c.NewHandler(server.Handler, h2s)
+	}
+	return server
+}
+
+func serve(server *http.Server, useTLS bool) {
+	var err error
+	if useTLS {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("failed to serve, %v", err)
+	}
 }
 
-func bootDB(options *Options) *gorm.DB {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-		options.DBHost, options.DBUsername, options.DBPassword, options.DBName, options.DBPort)
+func goWithContext(ctx context.Context, wg *sync.WaitGroup, run func(ctx context.Context)) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		run(ctx)
+	}()
+}
 
-	db, err := gorm.Open(postgres.Open(dsn))
+func closeDB(db *gorm.DB, replicas *persist.Replicas) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	err = sqlDB.Close()
+	if replicas != nil {
+		err = errors.Join(err, replicas.Close())
+	}
+	return err
+}
+
+// bootDB opens the database and refuses to go on unless its schema is at the version this
+// build expects, migrating it first when DB_MIGRATE_ON_START is set. The read replicas of
+// DB_REPLICA_DSNS are returned as well, nil when there are none.
+func bootDB(cfg *config.Config) (*gorm.DB, *persist.Replicas) {
+	db := openDB(cfg)
+	migrator := newMigrator(cfg, db)
+
+	if cfg.DB.MigrateOnStart {
+		applied, err := migrator.Up(context.Background(), 0)
+		if err != nil {
+			log.Fatalf("failed to migrate db, %v", err)
+		}
+		for _, migration := range applied {
+			slog.Info("applied migration", "version", migration.Version, "name", migration.Name)
+		}
+	}
+
+	if err := migrator.Check(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	return db, openReplicas(cfg)
+}
+
+func openDB(cfg *config.Config) *gorm.DB {
+	var (
+		db  *gorm.DB
+		err error
+	)
+	if cfg.DB.Driver == config.DBDriverSQLite {
+		db, err = persist.OpenSQLite(cfg.DB.Path)
+	} else {
+		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable",
+			cfg.DB.Host, cfg.DB.Username, cfg.DB.Password, cfg.DB.Name, cfg.DB.Port)
+		db, err = gorm.Open(postgres.Open(dsn))
+	}
 	if err != nil {
 		log.Fatalf("failed to open db connection, %v", err)
 	}
-	db.AutoMigrate(&models.Profile{}, models.Event{})
 	return db
 }
 
-func bootMQ(options *Options) *amqp.Connection {
-	conn, err := amqp.Dial(options.AMQPAddress)
+// chainKey returns the audit hash key, which commands working on the chain require.
+func chainKey(cfg *config.Config) persist.ChainKey {
+	key := optionalChainKey(cfg)
+	if key == nil {
+		log.Fatal("audit.hash_key (AUDIT_HASH_KEY or AUDIT_HASH_KEY_FILE) is required")
+	}
+	return key
+}
+
+// piiKeys returns the keyring encrypting personal data, which every command touching
+// profiles or events needs.
+func piiKeys(cfg *config.Config) *pii.Keyring {
+	if cfg.PII.Keys == "" {
+		log.Fatal("pii.keys (PII_KEYS or PII_KEYS_FILE) is required")
+	}
+	keyring, err := pii.ParseKeys(cfg.PII.Keys)
 	if err != nil {
-		log.Fatalf("failed to connect to RabbitMQ, %v", err)
+		log.Fatalf("invalid pii keys, %v", err)
 	}
-	return conn
+	return keyring
+}
+
+func queryTimeout(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.DB.QueryTimeoutInSecs) * time.Second
 }
 
-func mustGetEnv(key string) string {
-	v, ok := os.LookupEnv(key)
-	if !ok {
-		log.Fatalf("failed to get env for: %s", key)
+// optionalChainKey returns the audit hash key, nil when there is none. Configuration
+// validation already made sure it is valid.
+func optionalChainKey(cfg *config.Config) persist.ChainKey {
+	if cfg.Audit.HashKey == "" {
+		return nil
+	}
+	key, err := persist.ParseChainKey(cfg.Audit.HashKey)
+	if err != nil {
+		log.Fatalf("invalid audit hash key, %v", err)
 	}
-	return v
+	return key
 }
 
-func waitForShutdownSignal() string {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+func openReplicas(cfg *config.Config) *persist.Replicas {
+	if len(cfg.DB.ReplicaDSNs) == 0 {
+		return nil
+	}
 
-	sig := <-c
+	replicas := make(map[string]*gorm.DB, len(cfg.DB.ReplicaDSNs))
+	for i, dsn := range cfg.DB.ReplicaDSNs {
+		// replicas that are down at boot are picked up by the lag check once they are back
+		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{DisableAutomaticPing: true})
+		if err != nil {
+			log.Fatalf("failed to open read replica #%d connection, %v", i+1, err)
+		}
+		replicas[fmt.Sprintf("#%d", i+1)] = db
+	}
+	slog.Info("routing reads to read replicas", "count", len(replicas))
 
-	return sig.String()
+	return persist.NewReplicas(replicas, persist.ReplicaOptions{
+		MaxLag:    time.Duration(cfg.DB.ReplicaMaxLagInSecs) * time.Second,
+		StickyFor: time.Duration(cfg.DB.ReplicaStickyInSecs) * time.Second,
+	})
 }
 
-func shutdownOnSignal(db *gorm.DB, amqp *amqp.Connection) {
-	signalName := waitForShutdownSignal()
-	fmt.Printf("recieved signal: %s starting shutdown...\n", signalName)
+func newMigrator(cfg *config.Config, db *gorm.DB) migrate.Migrator {
+	dialect := migrate.DialectPostgres
+	if cfg.DB.Driver == config.DBDriverSQLite {
+		dialect = migrate.DialectSQLite
+	}
 
-	if db != nil {
-		if dbIns, err := db.DB(); err == nil {
-			dbIns.Close()
-			log.Println("db connection closed")
-		}
+	migrator, err := migrate.NewMigrator(db, dialect)
+	if err != nil {
+		log.Fatalf("failed to load migrations, %v", err)
 	}
+	return migrator
+}
 
-	if amqp != nil {
-		if err := amqp.Close(); err == nil {
-			log.Println("amqp connection closed")
+func pingDB(db *gorm.DB) health.Check {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
 		}
+		return sqlDB.PingContext(ctx)
+	}
+}
+
+// bootMQ wires the OTP client and event publisher to RabbitMQ, or to an in-process bus with a
+// fake OTP generator when MQ_DRIVER=memory.
+func bootMQ(cfg *config.Config, mqOptions mq.Options, profileRepo persist.ProfileRepo) (mq.MQClient, mq.EventPublisher) {
+	if cfg.MQ.Driver == config.MQDriverMemory {
+		slog.Info("using in-memory message bus, otps are printed to stdout")
+		bus := mq.NewMemoryBus(mqOptions)
+		return mq.NewInMemoryMQClient(bus, profileRepo, mqOptions), mq.NewInMemoryEventPublisher(bus, mqOptions)
+	}
+	return mq.NewOtpMQClient(cfg.MQ.AMQPAddress, profileRepo, mqOptions), mq.NewEventPublisher(cfg.MQ.AMQPAddress, mqOptions)
+}
+
+// setupLogging makes the log settings apply to slog and the log package alike, so everything
+// logged goes through the redaction of package logging.
+func setupLogging(cfg config.Log) {
+	level, err := logging.ParseLevel(cfg.Level)
+	if err != nil {
+		log.Fatalf("invalid log level, %v", err)
+	}
+
+	logger, err := logging.New(os.Stderr, logging.Options{Format: cfg.Format, Level: level})
+	if err != nil {
+		log.Fatalf("invalid log format, %v", err)
+	}
+	slog.SetDefault(logger)
+}
+
+func retentionOptions(cfg *config.Config) retention.Options {
+	policy, err := retention.ParsePolicy(cfg.Retention.Policy)
+	if err != nil {
+		log.Fatalf("invalid retention policy, %v", err)
+	}
+
+	return retention.Options{
+		Policy:     policy,
+		Interval:   time.Duration(cfg.Retention.PurgeIntervalInMinutes) * time.Minute,
+		BatchSize:  cfg.Retention.PurgeBatchSize,
+		DryRun:     cfg.Retention.PurgeDryRun,
+		ArchiveDir: cfg.Retention.ArchiveDir,
+	}
+}
+
+func dataExportOptions(cfg *config.Config) dataexport.Options {
+	return dataexport.Options{
+		TTL:          time.Duration(cfg.DataExport.TTLInHours) * time.Hour,
+		PollInterval: time.Duration(cfg.DataExport.PollIntervalInSeconds) * time.Second,
+	}
+}
+
+func tracingOptions(cfg *config.Config) tracing.Options {
+	return tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
 	}
 }